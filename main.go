@@ -8,8 +8,11 @@ import (
     "net/http"
     "time"
 
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
     "github.com/agent-sandbox/agent-sandbox/pkg/config"
     "github.com/agent-sandbox/agent-sandbox/pkg/handler"
+    "github.com/agent-sandbox/agent-sandbox/pkg/sandbox"
+    "github.com/agent-sandbox/agent-sandbox/pkg/scaler"
     "go.uber.org/zap"
     "k8s.io/apimachinery/pkg/util/wait"
     "k8s.io/client-go/rest"
@@ -20,6 +23,7 @@ import (
     "knative.dev/pkg/version"
 
     kubeclient "knative.dev/pkg/client/injection/kube/client"
+    filteredFactory "knative.dev/pkg/client/injection/kube/informers/factory/filtered"
 )
 
 func main() {
@@ -31,6 +35,9 @@ func main() {
     fs.Set("v", "2")
 
     klog.Infof("Loaded config %+v", config.Cfg)
+    if len(*config.Environments) == 0 {
+        klog.Fatalf("No environments loaded from %s", config.Cfg.SandboxEnvironmentConfigFile)
+    }
 
     klog.Info("Setup k8s cluster connection and start informers")
 
@@ -44,7 +51,9 @@ func main() {
 
     kubecfg.QPS = 2 * rest.DefaultQPS
     kubecfg.Burst = 2 * rest.DefaultBurst
-    rootCtx = injection.WithNamespaceScope(rootCtx, config.Cfg.SandboxNamespace)
+    rootCtx = injection.WithNamespaceScope(rootCtx, config.SandboxNamespaces())
+    // cache only the sandbox ReplicaSets and pods, not every object of the watched namespaces
+    rootCtx = filteredFactory.WithSelectors(rootCtx, activator.SandboxSelector)
     rootCtx, informers := injection.Default.SetupInformers(rootCtx, kubecfg)
 
     kubeClient := kubeclient.Get(rootCtx)
@@ -67,8 +76,19 @@ func main() {
     }
     log.Printf("Starting informers %v", len(informers))
 
+    // the activator is shared, so the scaler sees the last events recorded by the api server
+    a := activator.NewActivator(rootCtx)
+
+    klog.Info("Starting the sandbox scaler")
+    go scaler.NewScaler(rootCtx, a).RunScaling()
+
+    if config.Cfg.SandboxCRDEnabled {
+        klog.Info("Starting the sandbox resource reconciler")
+        go sandbox.NewReconciler(rootCtx, sandbox.NewController(rootCtx, a)).Run()
+    }
+
     klog.Info("Starting the api server")
-    apiServer := handler.New(rootCtx)
+    apiServer := handler.New(rootCtx, a)
     if err := apiServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
         log.Print("Failed to run HTTP server", zap.Error(err))
     }
@@ -0,0 +1,180 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package activator
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    "github.com/agent-sandbox/agent-sandbox/pkg/status"
+    corev1 "k8s.io/api/core/v1"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/util/wait"
+    "k8s.io/client-go/util/retry"
+    "k8s.io/klog/v2"
+    kubeclient "knative.dev/pkg/client/injection/kube/client"
+)
+
+const (
+    // EventTypeActivated is recorded on the ReplicaSet when a scaled down sandbox is scaled up by a request.
+    EventTypeActivated string = "SandboxActivated"
+)
+
+// activation is an in flight wakeup of a sandbox, shared by all the requests waiting on it.
+type activation struct {
+    done chan struct{}
+    err  error
+}
+
+// Activate makes sure the sandbox has a Ready pod to route to. A sandbox scaled down to 0 is scaled back to 1
+// and the call holds until a Ready pod has an IP or config.Cfg.SandboxActivationTimeout is reached.
+// Concurrent calls for the same sandbox wait on the same activation.
+func (a *Activator) Activate(ctx context.Context, name string) error {
+    rs, err := GetReplicaSet(a.rootCtx, name)
+    if err != nil {
+        return fmt.Errorf("sandbox %s not found: %v", name, err)
+    }
+    if rs.Spec.Replicas != nil && *rs.Spec.Replicas > 0 && a.hasReadyPod(name) {
+        return nil
+    }
+    if isPaused(rs.Annotations["sandbox-data"]) {
+        return fmt.Errorf("sandbox %s is paused, resume it first", name)
+    }
+
+    a.activationsMu.Lock()
+    act, ok := a.activations[name]
+    if !ok {
+        act = &activation{done: make(chan struct{})}
+        a.activations[name] = act
+        go a.activate(name, act)
+    }
+    a.activationsMu.Unlock()
+
+    select {
+    case <-act.done:
+        return act.err
+    case <-ctx.Done():
+        return fmt.Errorf("canceled waiting for sandbox %s activation: %v", name, ctx.Err())
+    }
+}
+
+// activate runs detached from the request, one canceled request must not abort the wakeup for the others.
+func (a *Activator) activate(name string, act *activation) {
+    defer func() {
+        a.activationsMu.Lock()
+        delete(a.activations, name)
+        a.activationsMu.Unlock()
+        close(act.done)
+    }()
+
+    start := time.Now()
+    rs, err := GetReplicaSet(a.rootCtx, name)
+    if err != nil {
+        act.err = fmt.Errorf("sandbox %s not found: %v", name, err)
+        return
+    }
+    if rs.Spec.Replicas == nil || *rs.Spec.Replicas == 0 {
+        klog.Infof("Activating scaled down sandbox %s", name)
+        if err := a.scaleUp(rs.Namespace, name); err != nil {
+            act.err = fmt.Errorf("failed to scale up sandbox %s: %v", name, err)
+            return
+        }
+        a.recorder.Eventf(rs, corev1.EventTypeNormal, EventTypeActivated, "Sandbox scaled up from zero by an incoming request")
+    }
+
+    timeout := config.Cfg.SandboxActivationTimeout
+    if perr := wait.PollUntilContextTimeout(a.rootCtx, 200*time.Millisecond, timeout, true, func(ctx context.Context) (bool, error) {
+        return a.hasReadyPod(name), nil
+    }); perr != nil {
+        act.err = fmt.Errorf("timeout after %s waiting for sandbox %s to be ready: %v", timeout, name, perr)
+        return
+    }
+    klog.Infof("Sandbox %s activated in %s", name, time.Since(start))
+}
+
+// scaleUp scales the sandbox to one replica, the idle status recorded by the idler is reset to running.
+func (a *Activator) scaleUp(namespace string, name string) error {
+    client := kubeclient.Get(a.rootCtx).AppsV1().ReplicaSets(namespace)
+    return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+        rs, err := client.Get(context.TODO(), name, v1meta.GetOptions{})
+        if err != nil {
+            return err
+        }
+        replicas := int32(1)
+        rs.Spec.Replicas = &replicas
+        if raw, ok := wakeUp(rs.Annotations["sandbox-data"]); ok {
+            rs.Annotations["sandbox-data"] = raw
+        }
+        _, err = client.Update(context.TODO(), rs, v1meta.UpdateOptions{})
+        return err
+    })
+}
+
+// wakeUp sets the status of the sandbox-data annotation from idle to running, the other fields are kept as they are.
+func wakeUp(raw string) (string, bool) {
+    data := map[string]json.RawMessage{}
+    if err := json.Unmarshal([]byte(raw), &data); err != nil || string(data["status"]) != strconv.Quote(status.Idle) {
+        return "", false
+    }
+    data["status"] = json.RawMessage(strconv.Quote(status.Running))
+    updated, err := json.Marshal(data)
+    if err != nil {
+        return "", false
+    }
+    return string(updated), true
+}
+
+// isPaused reads the status from the sandbox-data annotation, a paused sandbox is only woken up by an explicit resume.
+func isPaused(raw string) bool {
+    data := struct {
+        Status string `json:"status"`
+    }{}
+    if err := json.Unmarshal([]byte(raw), &data); err != nil {
+        return false
+    }
+    return data.Status == status.Paused
+}
+
+func (a *Activator) hasReadyPod(name string) bool {
+    pods, err := GetPods(a.rootCtx, name)
+    if err != nil {
+        return false
+    }
+    for _, pod := range pods {
+        if IsPodReady(pod) {
+            return true
+        }
+    }
+    return false
+}
+
+// IsPodReady reports whether the pod can serve requests: not deleting, Ready and has an IP.
+func IsPodReady(pod *corev1.Pod) bool {
+    if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" {
+        return false
+    }
+    for _, cond := range pod.Status.Conditions {
+        if cond.Type == corev1.PodReady {
+            return cond.Status == corev1.ConditionTrue
+        }
+    }
+    return false
+}
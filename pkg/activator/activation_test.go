@@ -0,0 +1,230 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package activator
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    appsv1 "k8s.io/api/apps/v1"
+    corev1 "k8s.io/api/core/v1"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/runtime"
+    appsinformers "k8s.io/client-go/informers/apps/v1"
+    coreinformers "k8s.io/client-go/informers/core/v1"
+    kubefake "k8s.io/client-go/kubernetes/fake"
+    appslisters "k8s.io/client-go/listers/apps/v1"
+    corelisters "k8s.io/client-go/listers/core/v1"
+    k8stesting "k8s.io/client-go/testing"
+    "k8s.io/client-go/tools/cache"
+    "k8s.io/client-go/tools/record"
+    kubeclient "knative.dev/pkg/client/injection/kube/client"
+    rsfiltered "knative.dev/pkg/client/injection/kube/informers/apps/v1/replicaset/filtered"
+    podfiltered "knative.dev/pkg/client/injection/kube/informers/core/v1/pod/filtered"
+    "knative.dev/pkg/controller"
+)
+
+// testReplicaSetInformer serves the lister of the indexer, the informer is never run.
+type testReplicaSetInformer struct {
+    indexer cache.Indexer
+}
+
+func (i *testReplicaSetInformer) Informer() cache.SharedIndexInformer {
+    return nil
+}
+
+func (i *testReplicaSetInformer) Lister() appslisters.ReplicaSetLister {
+    return appslisters.NewReplicaSetLister(i.indexer)
+}
+
+var _ appsinformers.ReplicaSetInformer = &testReplicaSetInformer{}
+
+// testPodInformer serves the lister of the indexer, the informer is never run.
+type testPodInformer struct {
+    indexer cache.Indexer
+}
+
+func (i *testPodInformer) Informer() cache.SharedIndexInformer {
+    return nil
+}
+
+func (i *testPodInformer) Lister() corelisters.PodLister {
+    return corelisters.NewPodLister(i.indexer)
+}
+
+var _ coreinformers.PodInformer = &testPodInformer{}
+
+// testActivator is an activator on a fake client, the updates of the ReplicaSets are copied to the informer cache.
+type testActivator struct {
+    *Activator
+    kube *kubefake.Clientset
+    pods cache.Indexer
+
+    mu      sync.Mutex
+    scaleUp int
+}
+
+func newTestActivator(t *testing.T, rs *appsv1.ReplicaSet) *testActivator {
+    rss := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+    pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+    if err := rss.Add(rs); err != nil {
+        t.Fatal(err)
+    }
+    kube := kubefake.NewSimpleClientset(rs.DeepCopy())
+    a := &testActivator{kube: kube, pods: pods}
+    kube.PrependReactor("update", "replicasets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+        a.mu.Lock()
+        a.scaleUp++
+        a.mu.Unlock()
+        return false, nil, rss.Update(action.(k8stesting.UpdateAction).GetObject())
+    })
+
+    ctx, cancel := context.WithCancel(context.Background())
+    t.Cleanup(cancel)
+    ctx = context.WithValue(ctx, kubeclient.Key{}, kube)
+    ctx = context.WithValue(ctx, rsfiltered.Key{Selector: SandboxSelector}, &testReplicaSetInformer{indexer: rss})
+    ctx = context.WithValue(ctx, podfiltered.Key{Selector: SandboxSelector}, &testPodInformer{indexer: pods})
+    ctx = controller.WithEventRecorder(ctx, record.NewFakeRecorder(100))
+    a.Activator = NewActivator(ctx)
+    return a
+}
+
+func (a *testActivator) scaleUps() int {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    return a.scaleUp
+}
+
+// scaledDown is the ReplicaSet of a sandbox scaled to zero with the status.
+func scaledDown(name string, status string) *appsv1.ReplicaSet {
+    replicas := int32(0)
+    return &appsv1.ReplicaSet{
+        ObjectMeta: v1meta.ObjectMeta{
+            Name:        name,
+            Namespace:   config.Cfg.SandboxNamespace,
+            Labels:      map[string]string{"owner": "agent-sandbox", "sandbox": name},
+            Annotations: map[string]string{"sandbox-data": `{"name":"` + name + `","status":"` + status + `"}`},
+        },
+        Spec: appsv1.ReplicaSetSpec{Replicas: &replicas},
+    }
+}
+
+func readyPod(name string) *corev1.Pod {
+    return &corev1.Pod{
+        ObjectMeta: v1meta.ObjectMeta{
+            Name:      name + "-pod",
+            Namespace: config.Cfg.SandboxNamespace,
+            Labels:    map[string]string{"owner": "agent-sandbox", "sandbox": name},
+        },
+        Status: corev1.PodStatus{
+            PodIP:      "10.0.0.1",
+            Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+        },
+    }
+}
+
+func TestActivateCoalesces(t *testing.T) {
+    a := newTestActivator(t, scaledDown("idle", "idle"))
+
+    const requests = 10
+    errs := make(chan error, requests)
+    for i := 0; i < requests; i++ {
+        go func() {
+            errs <- a.Activate(context.Background(), "idle")
+        }()
+    }
+    // the pod gets ready once the sandbox was scaled up
+    deadline := time.Now().Add(5 * time.Second)
+    for a.scaleUps() == 0 && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    if err := a.pods.Add(readyPod("idle")); err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < requests; i++ {
+        if err := <-errs; err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if got := a.scaleUps(); got != 1 {
+        t.Fatalf("expected the concurrent requests to scale up once, got %d", got)
+    }
+    rs, err := a.kube.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Get(context.TODO(), "idle", v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if *rs.Spec.Replicas != 1 || rs.Annotations["sandbox-data"] != `{"name":"idle","status":"running"}` {
+        t.Fatalf("expected a running sandbox of one replica, got %d replicas and %s", *rs.Spec.Replicas, rs.Annotations["sandbox-data"])
+    }
+}
+
+func TestActivateCanceledRequest(t *testing.T) {
+    a := newTestActivator(t, scaledDown("idle", "idle"))
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err := a.Activate(ctx, "idle"); err == nil {
+        t.Fatal("expected the canceled request to fail")
+    }
+    // the activation goes on for the other requests
+    if err := a.pods.Add(readyPod("idle")); err != nil {
+        t.Fatal(err)
+    }
+    if err := a.Activate(context.Background(), "idle"); err != nil {
+        t.Fatal(err)
+    }
+    if got := a.scaleUps(); got != 1 {
+        t.Fatalf("expected one scale up, got %d", got)
+    }
+}
+
+func TestActivateTimeout(t *testing.T) {
+    saved := config.Cfg.SandboxActivationTimeout
+    config.Cfg.SandboxActivationTimeout = 300 * time.Millisecond
+    t.Cleanup(func() { config.Cfg.SandboxActivationTimeout = saved })
+
+    a := newTestActivator(t, scaledDown("slow", "idle"))
+    if err := a.Activate(context.Background(), "slow"); err == nil {
+        t.Fatal("expected a sandbox without a ready pod to time out")
+    }
+}
+
+func TestActivatePaused(t *testing.T) {
+    a := newTestActivator(t, scaledDown("paused", "paused"))
+    if err := a.Activate(context.Background(), "paused"); err == nil {
+        t.Fatal("expected a paused sandbox not to be woken up")
+    }
+    if got := a.scaleUps(); got != 0 {
+        t.Fatalf("expected no scale up, got %d", got)
+    }
+}
+
+func TestWakeUp(t *testing.T) {
+    raw, ok := wakeUp(`{"name":"sb","status":"idle","labels":{"a":"b"}}`)
+    if !ok || raw != `{"labels":{"a":"b"},"name":"sb","status":"running"}` {
+        t.Fatalf("expected the idle sandbox to be running, got %s", raw)
+    }
+    for _, status := range []string{"paused", "running", "restarting"} {
+        if _, ok := wakeUp(`{"name":"sb","status":"` + status + `"}`); ok {
+            t.Errorf("expected the %s status to be kept", status)
+        }
+    }
+}
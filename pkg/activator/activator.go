@@ -19,19 +19,30 @@ package activator
 import (
     "context"
     "fmt"
+    "sync"
+    "time"
 
     "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    appsv1 "k8s.io/api/apps/v1"
     corev1 "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
     v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/labels"
+    appsinformers "k8s.io/client-go/informers/apps/v1"
+    coreinformers "k8s.io/client-go/informers/core/v1"
     "k8s.io/klog/v2"
     kubeclient "knative.dev/pkg/client/injection/kube/client"
-    rsclient "knative.dev/pkg/client/injection/kube/informers/apps/v1/replicaset"
+    rsfiltered "knative.dev/pkg/client/injection/kube/informers/apps/v1/replicaset/filtered"
+    podfiltered "knative.dev/pkg/client/injection/kube/informers/core/v1/pod/filtered"
 
     "k8s.io/client-go/tools/record"
 )
 
 const (
     ComponentName = "agent-sandbox-activator"
+
+    // SandboxSelector selects the sandbox ReplicaSets and pods and the warm pods, the informers cache nothing else.
+    SandboxSelector = "owner in (agent-sandbox,agent-sandbox-pool)"
 )
 
 const (
@@ -43,19 +54,30 @@ const (
 type Activator struct {
     rootCtx  context.Context
     recorder record.EventRecorder
+
+    // lastEvents caches the unix time of the last event by eventType/name,
+    // avoid listing the events from kube-api on every lookup
+    lastEvents sync.Map
+
+    // activations in flight by sandbox name
+    activations   map[string]*activation
+    activationsMu sync.Mutex
 }
 
 func NewActivator(ctx context.Context) *Activator {
     recorder := getRecorder(ctx)
     a := &Activator{
-        rootCtx:  ctx,
-        recorder: recorder,
+        rootCtx:     ctx,
+        recorder:    recorder,
+        activations: make(map[string]*activation),
     }
     return a
 }
 
 func (a *Activator) RecordLastEvent(eventType string, name string) {
-    rs, err := rsclient.Get(a.rootCtx).Lister().ReplicaSets(config.Cfg.SandboxNamespace).Get(name)
+    a.lastEvents.Store(eventKey(eventType, name), time.Now().Unix())
+
+    rs, err := GetReplicaSet(a.rootCtx, name)
     if err != nil {
         klog.ErrorS(err, "Failed to record event ", "name", name)
         return
@@ -68,6 +90,42 @@ func (a *Activator) RecordLastEvent(eventType string, name string) {
 // GetLastRequestTime gets the last request event for the given sandbox name.
 // return lastTimestamp of EventTypeLastRequest
 func (a *Activator) GetLastRequestTime(name string) int64 {
+    return a.getLastEventTime(EventTypeLastRequest, name)
+}
+
+// GetLastActiveTime return the unix time of the last request or response of the given sandbox name, 0 if never active.
+func (a *Activator) GetLastActiveTime(name string) int64 {
+    last := a.getLastEventTime(EventTypeLastRequest, name)
+    if resp := a.getLastEventTime(EventTypeLastResponse, name); resp > last {
+        last = resp
+    }
+    return last
+}
+
+// CachedLastActiveTime is GetLastActiveTime without listing the events from kube-api, so it is cheap enough for every
+// sandbox of a list. It is 0 until the sandbox was active or its events were listed, e.g. by the idler.
+func (a *Activator) CachedLastActiveTime(name string) int64 {
+    var last int64
+    for _, eventType := range []string{EventTypeLastRequest, EventTypeLastResponse} {
+        if val, ok := a.lastEvents.Load(eventKey(eventType, name)); ok && val.(int64) > last {
+            last = val.(int64)
+        }
+    }
+    return last
+}
+
+// Forget drops the cached events of the given sandbox name, call it once the sandbox is deleted.
+func (a *Activator) Forget(name string) {
+    a.lastEvents.Delete(eventKey(EventTypeLastRequest, name))
+    a.lastEvents.Delete(eventKey(EventTypeLastResponse, name))
+}
+
+func (a *Activator) getLastEventTime(eventType string, name string) int64 {
+    key := eventKey(eventType, name)
+    if val, ok := a.lastEvents.Load(key); ok {
+        return val.(int64)
+    }
+
     kubeClient := kubeclient.Get(a.rootCtx)
 
     fieldSelector := fmt.Sprintf("involvedObject.name=%s,involvedObject.kind=ReplicaSet", name)
@@ -76,16 +134,70 @@ func (a *Activator) GetLastRequestTime(name string) int64 {
         FieldSelector: fieldSelector,
     }
 
-    items, err := kubeClient.CoreV1().Events(config.Cfg.SandboxNamespace).List(context.TODO(), listOptions)
+    items, err := kubeClient.CoreV1().Events(SandboxNamespace(a.rootCtx, name)).List(context.TODO(), listOptions)
     if err != nil {
-        klog.ErrorS(err, "Failed to get last request event", "name", name)
+        klog.ErrorS(err, "Failed to get last event", "name", name, "type", eventType)
         return 0
     }
+    var last int64
     for _, item := range items.Items {
-        if item.Reason == EventTypeLastRequest {
-            return item.LastTimestamp.Unix()
+        if item.Reason == eventType && item.LastTimestamp.Unix() > last {
+            last = item.LastTimestamp.Unix()
         }
     }
+    // a newer RecordLastEvent wins over the value listed from kube-api
+    actual, _ := a.lastEvents.LoadOrStore(key, last)
+
+    return actual.(int64)
+}
+
+// GetReplicaSet returns the sandbox ReplicaSet by name from the informer cache. The sandbox names are unique over all
+// the namespaces, with config.Cfg.SandboxNamespacePerTenant it is searched in the namespaces of all tenants.
+func GetReplicaSet(ctx context.Context, name string) (*appsv1.ReplicaSet, error) {
+    lister := ReplicaSets(ctx).Lister()
+    if !config.Cfg.SandboxNamespacePerTenant {
+        return lister.ReplicaSets(config.Cfg.SandboxNamespace).Get(name)
+    }
+    rss, err := lister.List(labels.SelectorFromSet(labels.Set{"owner": "agent-sandbox", "sandbox": name}))
+    if err != nil {
+        return nil, err
+    }
+    for _, rs := range rss {
+        if rs.Name == name {
+            return rs, nil
+        }
+    }
+    return nil, apierrors.NewNotFound(appsv1.Resource("replicasets"), name)
+}
+
+// GetPods returns the pods of the sandbox by name from the informer cache, only those in the namespace of its ReplicaSet.
+func GetPods(ctx context.Context, name string) ([]*corev1.Pod, error) {
+    rs, err := GetReplicaSet(ctx, name)
+    if err != nil {
+        return nil, err
+    }
+    selector := labels.SelectorFromSet(labels.Set{"owner": "agent-sandbox", "sandbox": name})
+    return Pods(ctx).Lister().Pods(rs.Namespace).List(selector)
+}
+
+// ReplicaSets returns the informer of the ReplicaSets selected by SandboxSelector.
+func ReplicaSets(ctx context.Context) appsinformers.ReplicaSetInformer {
+    return rsfiltered.Get(ctx, SandboxSelector)
+}
+
+// Pods returns the informer of the pods selected by SandboxSelector.
+func Pods(ctx context.Context) coreinformers.PodInformer {
+    return podfiltered.Get(ctx, SandboxSelector)
+}
+
+// SandboxNamespace returns the namespace of the sandbox by name, config.Cfg.SandboxNamespace if it is not cached.
+func SandboxNamespace(ctx context.Context, name string) string {
+    if rs, err := GetReplicaSet(ctx, name); err == nil {
+        return rs.Namespace
+    }
+    return config.Cfg.SandboxNamespace
+}
 
-    return 0
+func eventKey(eventType string, name string) string {
+    return eventType + "/" + name
 }
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package auth
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+)
+
+// TenantLabel is the tenant owning a sandbox, set on its ReplicaSet, pods, volume claims and snapshots.
+const TenantLabel = "sandbox-tenant"
+
+// ErrInvalidToken is returned for a missing, unknown or expired credential.
+var ErrInvalidToken = mcpauth.ErrInvalidToken
+
+// Identity is the authenticated caller, the sandboxes it creates are owned by its tenant.
+type Identity struct {
+    Subject string   `json:"subject"`
+    Tenant  string   `json:"tenant"`
+    Roles   []string `json:"roles,omitempty"`
+
+    // expiration of a JWT, zero for an API key
+    ExpiresAt time.Time `json:"-"`
+}
+
+// Verifier authenticates a credential, it returns an error wrapping ErrInvalidToken if the credential is not its own.
+type Verifier interface {
+    Verify(ctx context.Context, token string) (*Identity, error)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+    return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity of the caller, nil when authentication is disabled.
+func FromContext(ctx context.Context) *Identity {
+    id, _ := ctx.Value(identityKey{}).(*Identity)
+    return id
+}
+
+// TenantFromContext returns the tenant of the caller which owns the objects it creates, empty when authentication is
+// disabled. The tenants the caller may see are given by ScopeFromContext.
+func TenantFromContext(ctx context.Context) string {
+    if id := FromContext(ctx); id != nil {
+        return id.Tenant
+    }
+    return ""
+}
+
+// Allowed reports whether the tenant may access an object of the owner tenant, the empty tenant of a disabled
+// authentication may access everything.
+func Allowed(tenant string, owner string) bool {
+    return tenant == "" || tenant == owner
+}
+
+// Authenticator tries the verifiers in order, the first one accepting the credential wins.
+type Authenticator struct {
+    verifiers []Verifier
+}
+
+// Authenticate returns the identity of the credential, an error wrapping ErrInvalidToken if no verifier accepts it.
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (*Identity, error) {
+    if token == "" {
+        return nil, ErrInvalidToken
+    }
+    err := ErrInvalidToken
+    for _, v := range a.verifiers {
+        id, verr := v.Verify(ctx, token)
+        if verr == nil {
+            return id, nil
+        }
+        if !errors.Is(verr, ErrInvalidToken) {
+            return nil, verr
+        }
+        // keep the reason of the verifier which recognized the credential, e.g. an expired JWT
+        if verr != ErrInvalidToken {
+            err = verr
+        }
+    }
+    return nil, err
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package auth
+
+import (
+    "context"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rsa"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math/big"
+    "net/http"
+    "os"
+    "sync/atomic"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    "k8s.io/apimachinery/pkg/util/wait"
+    "k8s.io/klog/v2"
+)
+
+// jsonWebKey is a public key of a JWKS, RFC 7517.
+type jsonWebKey struct {
+    Kty string `json:"kty"`
+    Kid string `json:"kid"`
+    Use string `json:"use"`
+    Alg string `json:"alg"`
+    // RSA
+    N string `json:"n"`
+    E string `json:"e"`
+    // EC
+    Crv string `json:"crv"`
+    X   string `json:"x"`
+    Y   string `json:"y"`
+}
+
+// SigningKey is a public key of the JWKS, Alg restricts the algorithm of the tokens signed by it, e.g. RS256.
+type SigningKey struct {
+    Key crypto.PublicKey
+    Alg string
+}
+
+// KeySet holds the signing keys of the identity provider by key id, reloaded periodically to follow key rotation.
+type KeySet struct {
+    keys atomic.Pointer[map[string]*SigningKey]
+}
+
+// NewKeySet loads the JWKS of config.Cfg.AuthJwksFile or config.Cfg.AuthJwksURL.
+func NewKeySet(ctx context.Context) (*KeySet, error) {
+    ks := &KeySet{}
+    if err := ks.load(ctx); err != nil {
+        return nil, err
+    }
+    if config.Cfg.AuthReloadInterval > 0 {
+        go wait.Until(func() {
+            if err := ks.load(ctx); err != nil {
+                klog.Errorf("Failed to reload jwks, keep the loaded keys: %v", err)
+            }
+        }, config.Cfg.AuthReloadInterval, ctx.Done())
+    }
+    return ks, nil
+}
+
+// Get returns the key of the key id, the only key when kid is empty.
+func (ks *KeySet) Get(kid string) (*SigningKey, bool) {
+    keys := *ks.keys.Load()
+    if kid == "" && len(keys) == 1 {
+        for _, key := range keys {
+            return key, true
+        }
+    }
+    key, ok := keys[kid]
+    return key, ok
+}
+
+func (ks *KeySet) load(ctx context.Context) error {
+    var raw []byte
+    source := config.Cfg.AuthJwksFile
+    if source != "" {
+        val, err := os.ReadFile(source)
+        if err != nil {
+            return err
+        }
+        raw = val
+    } else {
+        source = config.Cfg.AuthJwksURL
+        val, err := fetchJWKS(ctx, source)
+        if err != nil {
+            return err
+        }
+        raw = val
+    }
+
+    set := struct {
+        Keys []jsonWebKey `json:"keys"`
+    }{}
+    if err := json.Unmarshal(raw, &set); err != nil {
+        return fmt.Errorf("failed to parse jwks of %s: %v", source, err)
+    }
+    keys := make(map[string]*SigningKey, len(set.Keys))
+    for _, jwk := range set.Keys {
+        if jwk.Use != "" && jwk.Use != "sig" {
+            continue
+        }
+        key, err := jwk.publicKey()
+        if err != nil {
+            // a key of an unsupported type does not invalidate the others
+            klog.Warningf("Skip key %s of jwks of %s: %v", jwk.Kid, source, err)
+            continue
+        }
+        keys[jwk.Kid] = &SigningKey{Key: key, Alg: jwk.Alg}
+    }
+    if len(keys) == 0 {
+        return fmt.Errorf("jwks of %s has no signing keys", source)
+    }
+    ks.keys.Store(&keys)
+    klog.V(2).Infof("Loaded %d signing keys from %s", len(keys), source)
+    return nil
+}
+
+func fetchJWKS(ctx context.Context, url string) ([]byte, error) {
+    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil {
+        return nil, err
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("fetch jwks fail: %v", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("fetch jwks fail: %s", resp.Status)
+    }
+    return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+    switch k.Kty {
+    case "RSA":
+        n, err := base64.RawURLEncoding.DecodeString(k.N)
+        if err != nil {
+            return nil, fmt.Errorf("invalid n: %v", err)
+        }
+        e, err := base64.RawURLEncoding.DecodeString(k.E)
+        if err != nil || len(e) == 0 || len(e) > 4 {
+            return nil, fmt.Errorf("invalid e")
+        }
+        return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+    case "EC":
+        var curve elliptic.Curve
+        switch k.Crv {
+        case "P-256":
+            curve = elliptic.P256()
+        case "P-384":
+            curve = elliptic.P384()
+        case "P-521":
+            curve = elliptic.P521()
+        default:
+            return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+        }
+        x, err := base64.RawURLEncoding.DecodeString(k.X)
+        if err != nil {
+            return nil, fmt.Errorf("invalid x: %v", err)
+        }
+        y, err := base64.RawURLEncoding.DecodeString(k.Y)
+        if err != nil {
+            return nil, fmt.Errorf("invalid y: %v", err)
+        }
+        key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+        if !curve.IsOnCurve(key.X, key.Y) {
+            return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+        }
+        return key, nil
+    }
+    return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
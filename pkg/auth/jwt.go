@@ -0,0 +1,244 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package auth
+
+import (
+    "bytes"
+    "context"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/rsa"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "math/big"
+    "strings"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    "k8s.io/apimachinery/pkg/util/validation"
+)
+
+// clockSkew is tolerated between the identity provider and agent-sandbox on exp and nbf.
+const clockSkew = time.Minute
+
+// JWTVerifier verifies the JWT bearer tokens signed by the keys of the JWKS, the tenant and roles are mapped from
+// the claims config.Cfg.AuthJwtTenantClaim and config.Cfg.AuthJwtRolesClaim.
+type JWTVerifier struct {
+    keys     *KeySet
+    issuer   string
+    audience string
+}
+
+// NewJWTVerifier requires the iss and aud of the tokens, otherwise the tokens the identity provider issued to any
+// other client would be accepted. The audience defaults to config.Cfg.AuthResourceURL, the resource of the MCP clients.
+func NewJWTVerifier(ctx context.Context) (*JWTVerifier, error) {
+    audience := config.Cfg.AuthJwtAudience
+    if audience == "" {
+        audience = config.Cfg.AuthResourceURL
+    }
+    if config.Cfg.AuthJwtIssuer == "" || audience == "" {
+        return nil, fmt.Errorf("AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE or AUTH_RESOURCE_URL are required with AUTH_JWKS_FILE or AUTH_JWKS_URL")
+    }
+    keys, err := NewKeySet(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return &JWTVerifier{keys: keys, issuer: config.Cfg.AuthJwtIssuer, audience: audience}, nil
+}
+
+// Verify checks the signature, exp, nbf, iss and aud of the token and returns the identity of its claims.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        // not a JWT, e.g. an API key
+        return nil, ErrInvalidToken
+    }
+    header := struct {
+        Alg string `json:"alg"`
+        Kid string `json:"kid"`
+    }{}
+    if err := decodeSegment(parts[0], &header); err != nil {
+        return nil, fmt.Errorf("%w: invalid jwt header: %v", ErrInvalidToken, err)
+    }
+    key, ok := v.keys.Get(header.Kid)
+    if !ok {
+        return nil, fmt.Errorf("%w: unknown jwt key %q", ErrInvalidToken, header.Kid)
+    }
+    sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return nil, fmt.Errorf("%w: invalid jwt signature: %v", ErrInvalidToken, err)
+    }
+    if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+    }
+
+    claims := map[string]interface{}{}
+    if err := decodeSegment(parts[1], &claims); err != nil {
+        return nil, fmt.Errorf("%w: invalid jwt claims: %v", ErrInvalidToken, err)
+    }
+    expiresAt, err := v.checkClaims(claims, time.Now())
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+    }
+
+    tenant, _ := claim(claims, config.Cfg.AuthJwtTenantClaim).(string)
+    if tenant == "" {
+        return nil, fmt.Errorf("%w: missing tenant claim %s", ErrInvalidToken, config.Cfg.AuthJwtTenantClaim)
+    }
+    // the tenant labels the sandboxes, an OIDC subject like auth0|123 or a@b.com is mapped to a label value
+    if len(validation.IsValidLabelValue(tenant)) > 0 {
+        tenant = config.DNSLabel(tenant, validation.LabelValueMaxLength)
+    }
+    subject, _ := claims["sub"].(string)
+    return &Identity{
+        Subject:   subject,
+        Tenant:    tenant,
+        Roles:     stringList(claim(claims, config.Cfg.AuthJwtRolesClaim)),
+        ExpiresAt: expiresAt,
+    }, nil
+}
+
+// checkClaims checks the registered claims at now and returns the expiration of the token.
+func (v *JWTVerifier) checkClaims(claims map[string]interface{}, now time.Time) (time.Time, error) {
+    exp, ok := numericDate(claims["exp"])
+    if !ok {
+        return time.Time{}, fmt.Errorf("jwt has no exp")
+    }
+    if now.After(exp.Add(clockSkew)) {
+        return time.Time{}, fmt.Errorf("jwt expired at %s", exp.UTC().Format(time.RFC3339))
+    }
+    if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(clockSkew).Before(nbf) {
+        return time.Time{}, fmt.Errorf("jwt not valid before %s", nbf.UTC().Format(time.RFC3339))
+    }
+    if claims["iss"] != v.issuer {
+        return time.Time{}, fmt.Errorf("jwt issuer %v is not %s", claims["iss"], v.issuer)
+    }
+    found := false
+    for _, aud := range stringList(claims["aud"]) {
+        found = found || aud == v.audience
+    }
+    if !found {
+        return time.Time{}, fmt.Errorf("jwt audience %v does not include %s", claims["aud"], v.audience)
+    }
+    return exp, nil
+}
+
+// curveAlgs are the algorithms of the ECDSA keys by curve.
+var curveAlgs = map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}
+
+// verifySignature verifies the signature of alg by the key, alg must be the alg of the key if it has one and fit
+// the curve of an ECDSA key.
+func verifySignature(alg string, key *SigningKey, signed []byte, sig []byte) error {
+    if key.Alg != "" && key.Alg != alg {
+        return fmt.Errorf("jwt alg %s does not match the alg %s of the key", alg, key.Alg)
+    }
+    var hash crypto.Hash
+    if len(alg) == 5 {
+        switch alg[2:] {
+        case "256":
+            hash = crypto.SHA256
+        case "384":
+            hash = crypto.SHA384
+        case "512":
+            hash = crypto.SHA512
+        }
+    }
+    if hash == 0 {
+        return fmt.Errorf("unsupported jwt alg %q", alg)
+    }
+    h := hash.New()
+    h.Write(signed)
+    digest := h.Sum(nil)
+
+    switch k := key.Key.(type) {
+    case *rsa.PublicKey:
+        switch alg[:2] {
+        case "RS":
+            return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+        case "PS":
+            return rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+        }
+    case *ecdsa.PublicKey:
+        // the curve fits the hash, ES512 signs with P-521
+        if curveAlgs[k.Curve.Params().Name] != alg {
+            break
+        }
+        size := (k.Curve.Params().BitSize + 7) / 8
+        if len(sig) != 2*size {
+            return fmt.Errorf("invalid jwt signature length %d", len(sig))
+        }
+        r := new(big.Int).SetBytes(sig[:size])
+        s := new(big.Int).SetBytes(sig[size:])
+        if ecdsa.Verify(k, digest, r, s) {
+            return nil
+        }
+        return fmt.Errorf("invalid jwt signature")
+    }
+    return fmt.Errorf("jwt alg %s does not match the key", alg)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+    raw, err := base64.RawURLEncoding.DecodeString(segment)
+    if err != nil {
+        return err
+    }
+    decoder := json.NewDecoder(bytes.NewReader(raw))
+    decoder.UseNumber()
+    return decoder.Decode(v)
+}
+
+// claim returns the claim of the dot separated path, nil if there is none.
+func claim(claims map[string]interface{}, path string) interface{} {
+    var value interface{} = claims
+    for _, key := range strings.Split(path, ".") {
+        m, ok := value.(map[string]interface{})
+        if !ok {
+            return nil
+        }
+        value = m[key]
+    }
+    return value
+}
+
+// stringList reads a claim of a string list or a space separated string, e.g. aud or scope.
+func stringList(value interface{}) []string {
+    switch v := value.(type) {
+    case string:
+        return strings.Fields(v)
+    case []interface{}:
+        list := make([]string, 0, len(v))
+        for _, item := range v {
+            if s, ok := item.(string); ok {
+                list = append(list, s)
+            }
+        }
+        return list
+    }
+    return nil
+}
+
+func numericDate(value interface{}) (time.Time, bool) {
+    n, ok := value.(json.Number)
+    if !ok {
+        return time.Time{}, false
+    }
+    f, err := n.Float64()
+    if err != nil {
+        return time.Time{}, false
+    }
+    return time.Unix(int64(f), 0), true
+}
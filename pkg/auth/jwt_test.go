@@ -0,0 +1,378 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+    "context"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "math/big"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    "k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+    testIssuer   = "https://idp.example.com"
+    testAudience = "https://sandbox.example.com/mcp"
+)
+
+// withConfig restores config.Cfg after the test.
+func withConfig(t *testing.T) {
+    saved := *config.Cfg
+    t.Cleanup(func() { *config.Cfg = saved })
+}
+
+type testKeys struct {
+    rsa   *rsa.PrivateKey
+    ec256 *ecdsa.PrivateKey
+    ec384 *ecdsa.PrivateKey
+}
+
+// newTestVerifier writes a JWKS of an RS256 key "rsa" and the ECDSA keys "ec256" and "ec384" without alg.
+func newTestVerifier(t *testing.T) (*JWTVerifier, *testKeys) {
+    withConfig(t)
+    rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    ec256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    ec384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+    enc := base64.RawURLEncoding.EncodeToString
+    ecJWK := func(kid string, crv string, k *ecdsa.PrivateKey) jsonWebKey {
+        size := (k.Curve.Params().BitSize + 7) / 8
+        return jsonWebKey{Kty: "EC", Kid: kid, Crv: crv, X: enc(k.X.FillBytes(make([]byte, size))), Y: enc(k.Y.FillBytes(make([]byte, size)))}
+    }
+    jwks := map[string][]jsonWebKey{"keys": {
+        {Kty: "RSA", Kid: "rsa", Alg: "RS256", N: enc(rsaKey.N.Bytes()), E: enc(big.NewInt(int64(rsaKey.E)).Bytes())},
+        ecJWK("ec256", "P-256", ec256),
+        ecJWK("ec384", "P-384", ec384),
+        // skipped, not a signing key
+        {Kty: "RSA", Kid: "enc", Use: "enc", N: enc(rsaKey.N.Bytes()), E: "AQAB"},
+    }}
+    raw, _ := json.Marshal(jwks)
+    file := filepath.Join(t.TempDir(), "jwks.json")
+    if err := os.WriteFile(file, raw, 0o600); err != nil {
+        t.Fatal(err)
+    }
+    config.Cfg.AuthJwksFile = file
+    config.Cfg.AuthReloadInterval = 0
+    config.Cfg.AuthJwtIssuer = testIssuer
+    config.Cfg.AuthJwtAudience = testAudience
+    v, err := NewJWTVerifier(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := v.keys.Get("enc"); ok {
+        t.Fatal("encryption key loaded as signing key")
+    }
+    return v, &testKeys{rsa: rsaKey, ec256: ec256, ec384: ec384}
+}
+
+// signToken signs the token by sign, sign returns the signature of the digest of hash.
+func signToken(t *testing.T, alg string, kid string, claims map[string]interface{}, hash crypto.Hash, sign func(digest []byte) []byte) string {
+    enc := base64.RawURLEncoding.EncodeToString
+    header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+    payload, _ := json.Marshal(claims)
+    signed := enc(header) + "." + enc(payload)
+    if sign == nil {
+        return signed + "."
+    }
+    var digest []byte
+    if hash != 0 {
+        h := hash.New()
+        h.Write([]byte(signed))
+        digest = h.Sum(nil)
+    } else {
+        digest = []byte(signed)
+    }
+    return signed + "." + enc(sign(digest))
+}
+
+func rsaSigner(t *testing.T, key *rsa.PrivateKey, hash crypto.Hash, pss bool) func([]byte) []byte {
+    return func(digest []byte) []byte {
+        var sig []byte
+        var err error
+        if pss {
+            sig, err = rsa.SignPSS(rand.Reader, key, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+        } else {
+            sig, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        return sig
+    }
+}
+
+// ecSigner signs with the fixed size r||s of the curve, resize cuts or pads the signature by its bytes.
+func ecSigner(t *testing.T, key *ecdsa.PrivateKey, resize int) func([]byte) []byte {
+    size := (key.Curve.Params().BitSize + 7) / 8
+    return func(digest []byte) []byte {
+        r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+        if err != nil {
+            t.Fatal(err)
+        }
+        sig := append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+        if resize < 0 {
+            return sig[:len(sig)+resize]
+        }
+        return append(sig, make([]byte, resize)...)
+    }
+}
+
+func validClaims() map[string]interface{} {
+    now := time.Now()
+    return map[string]interface{}{
+        "iss":    testIssuer,
+        "aud":    []string{"other", testAudience},
+        "sub":    "agent-1",
+        "exp":    now.Add(time.Hour).Unix(),
+        "nbf":    now.Add(-time.Minute).Unix(),
+        "tenant": "team-a",
+        "roles":  []string{RoleViewer},
+    }
+}
+
+func TestJWTVerify(t *testing.T) {
+    v, keys := newTestVerifier(t)
+    pubDER, _ := json.Marshal(keys.rsa.PublicKey)
+    other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    with := func(key string, value interface{}) map[string]interface{} {
+        claims := validClaims()
+        claims[key] = value
+        return claims
+    }
+
+    tests := []struct {
+        name  string
+        token string
+        err   bool
+    }{
+        {"rs256", signToken(t, "RS256", "rsa", validClaims(), crypto.SHA256, rsaSigner(t, keys.rsa, crypto.SHA256, false)), false},
+        {"es256", signToken(t, "ES256", "ec256", validClaims(), crypto.SHA256, ecSigner(t, keys.ec256, 0)), false},
+        {"es384", signToken(t, "ES384", "ec384", validClaims(), crypto.SHA384, ecSigner(t, keys.ec384, 0)), false},
+        {"not a jwt", "an-api-key-of-32-characters-long", true},
+        {"alg none", signToken(t, "none", "rsa", validClaims(), 0, nil), true},
+        {"alg none with signature", signToken(t, "none", "rsa", validClaims(), 0, func(d []byte) []byte { return []byte("x") }), true},
+        {"hs256 keyed by the public key", signToken(t, "HS256", "rsa", validClaims(), 0, func(d []byte) []byte {
+            mac := hmac.New(sha256.New, pubDER)
+            mac.Write(d)
+            return mac.Sum(nil)
+        }), true},
+        {"alg none on key without alg", signToken(t, "none", "ec256", validClaims(), 0, nil), true},
+        {"hs256 on key without alg", signToken(t, "HS256", "ec256", validClaims(), 0, func(d []byte) []byte {
+            mac := hmac.New(sha256.New, []byte(keys.ec256.X.String()))
+            mac.Write(d)
+            return mac.Sum(nil)
+        }), true},
+        {"unknown kid", signToken(t, "RS256", "rotated", validClaims(), crypto.SHA256, rsaSigner(t, keys.rsa, crypto.SHA256, false)), true},
+        {"empty kid of many keys", signToken(t, "RS256", "", validClaims(), crypto.SHA256, rsaSigner(t, keys.rsa, crypto.SHA256, false)), true},
+        {"ps256 on rs256 key", signToken(t, "PS256", "rsa", validClaims(), crypto.SHA256, rsaSigner(t, keys.rsa, crypto.SHA256, true)), true},
+        {"rs384 on rs256 key", signToken(t, "RS384", "rsa", validClaims(), crypto.SHA384, rsaSigner(t, keys.rsa, crypto.SHA384, false)), true},
+        {"es256 on p-384 key", signToken(t, "ES256", "ec384", validClaims(), crypto.SHA256, ecSigner(t, keys.ec384, 0)), true},
+        {"es384 on p-256 key", signToken(t, "ES384", "ec256", validClaims(), crypto.SHA384, ecSigner(t, keys.ec256, 0)), true},
+        {"rs256 on ec key", signToken(t, "RS256", "ec256", validClaims(), crypto.SHA256, rsaSigner(t, keys.rsa, crypto.SHA256, false)), true},
+        {"short ecdsa signature", signToken(t, "ES256", "ec256", validClaims(), crypto.SHA256, ecSigner(t, keys.ec256, -1)), true},
+        {"long ecdsa signature", signToken(t, "ES256", "ec256", validClaims(), crypto.SHA256, ecSigner(t, keys.ec256, 2)), true},
+        {"signed by another key", signToken(t, "ES256", "ec256", validClaims(), crypto.SHA256, ecSigner(t, other, 0)), true},
+        {"other issuer", signToken(t, "ES256", "ec256", with("iss", "https://other.example.com"), crypto.SHA256, ecSigner(t, keys.ec256, 0)), true},
+        {"other audience", signToken(t, "ES256", "ec256", with("aud", "other"), crypto.SHA256, ecSigner(t, keys.ec256, 0)), true},
+        {"expired", signToken(t, "ES256", "ec256", with("exp", time.Now().Add(-time.Hour).Unix()), crypto.SHA256, ecSigner(t, keys.ec256, 0)), true},
+        {"no tenant", signToken(t, "ES256", "ec256", with("tenant", nil), crypto.SHA256, ecSigner(t, keys.ec256, 0)), true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            id, err := v.Verify(context.Background(), tt.token)
+            if tt.err {
+                if !errors.Is(err, ErrInvalidToken) {
+                    t.Fatalf("expected ErrInvalidToken, got identity %v err %v", id, err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if id.Subject != "agent-1" || id.Tenant != "team-a" || !slices.Equal(id.Roles, []string{RoleViewer}) || id.ExpiresAt.IsZero() {
+                t.Fatalf("unexpected identity %+v", id)
+            }
+        })
+    }
+}
+
+func TestJWTCheckClaims(t *testing.T) {
+    v := &JWTVerifier{issuer: testIssuer, audience: testAudience}
+    now := time.Unix(1700000000, 0)
+    claims := func(exp int64, nbf int64) map[string]interface{} {
+        c := map[string]interface{}{"iss": testIssuer, "aud": testAudience, "exp": json.Number(big.NewInt(exp).String())}
+        if nbf != 0 {
+            c["nbf"] = json.Number(big.NewInt(nbf).String())
+        }
+        return c
+    }
+    skew := int64(clockSkew / time.Second)
+
+    tests := []struct {
+        name   string
+        claims map[string]interface{}
+        err    bool
+    }{
+        {"valid", claims(now.Unix()+60, now.Unix()-60), false},
+        {"expired within clock skew", claims(now.Unix()-skew, 0), false},
+        {"expired beyond clock skew", claims(now.Unix()-skew-1, 0), true},
+        {"not yet valid within clock skew", claims(now.Unix()+60, now.Unix()+skew), false},
+        {"not yet valid beyond clock skew", claims(now.Unix()+60, now.Unix()+skew+1), true},
+        {"no exp", map[string]interface{}{"iss": testIssuer, "aud": testAudience}, true},
+        {"exp not a number", map[string]interface{}{"iss": testIssuer, "aud": testAudience, "exp": "tomorrow"}, true},
+        {"no issuer", map[string]interface{}{"aud": testAudience, "exp": json.Number("1700000060")}, true},
+        {"no audience", map[string]interface{}{"iss": testIssuer, "exp": json.Number("1700000060")}, true},
+        {"audience list", map[string]interface{}{"iss": testIssuer, "aud": []interface{}{"other", testAudience}, "exp": json.Number("1700000060")}, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := v.checkClaims(tt.claims, now)
+            if (err != nil) != tt.err {
+                t.Fatalf("expected error %v, got %v", tt.err, err)
+            }
+        })
+    }
+}
+
+func TestJWTNestedClaims(t *testing.T) {
+    v, keys := newTestVerifier(t)
+    config.Cfg.AuthJwtTenantClaim = "org.id"
+    config.Cfg.AuthJwtRolesClaim = "realm_access.roles"
+
+    tests := []struct {
+        name   string
+        claims map[string]interface{}
+        tenant string
+        roles  []string
+        err    bool
+    }{
+        {"nested", map[string]interface{}{"org": map[string]interface{}{"id": "team-b"}, "realm_access": map[string]interface{}{"roles": []string{RoleAdmin, RoleViewer}}}, "team-b", []string{RoleAdmin, RoleViewer}, false},
+        {"space separated roles", map[string]interface{}{"org": map[string]interface{}{"id": "team-b"}, "realm_access": map[string]interface{}{"roles": "admin viewer"}}, "team-b", []string{RoleAdmin, RoleViewer}, false},
+        {"no roles", map[string]interface{}{"org": map[string]interface{}{"id": "team-b"}}, "team-b", nil, false},
+        {"top level tenant", map[string]interface{}{"tenant": "team-b"}, "", nil, true},
+        {"tenant not an object", map[string]interface{}{"org": "team-b"}, "", nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            claims := validClaims()
+            delete(claims, "tenant")
+            delete(claims, "roles")
+            for k, val := range tt.claims {
+                claims[k] = val
+            }
+            id, err := v.Verify(context.Background(), signToken(t, "ES256", "ec256", claims, crypto.SHA256, ecSigner(t, keys.ec256, 0)))
+            if tt.err {
+                if err == nil {
+                    t.Fatalf("expected error, got identity %+v", id)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if id.Tenant != tt.tenant || !slices.Equal(id.Roles, tt.roles) {
+                t.Fatalf("expected tenant %s roles %v, got %+v", tt.tenant, tt.roles, id)
+            }
+        })
+    }
+}
+
+func TestJWTTenantLabel(t *testing.T) {
+    v, keys := newTestVerifier(t)
+    tenantOf := func(tenant string) string {
+        claims := validClaims()
+        claims["tenant"] = tenant
+        id, err := v.Verify(context.Background(), signToken(t, "ES256", "ec256", claims, crypto.SHA256, ecSigner(t, keys.ec256, 0)))
+        if err != nil {
+            t.Fatalf("tenant %q: %v", tenant, err)
+        }
+        return id.Tenant
+    }
+
+    if got := tenantOf("Team_A.1"); got != "Team_A.1" {
+        t.Fatalf("expected a label value tenant to be kept, got %s", got)
+    }
+    seen := map[string]string{}
+    for _, tenant := range []string{"auth0|123", "a@b.com", "a-b.com", "Team A", strings.Repeat("x", 100)} {
+        got := tenantOf(tenant)
+        if errs := validation.IsValidLabelValue(got); len(errs) > 0 {
+            t.Fatalf("tenant %q mapped to invalid label value %s: %v", tenant, got, errs)
+        }
+        if got != tenantOf(tenant) {
+            t.Fatalf("tenant %q mapped to different tenants", tenant)
+        }
+        if other, ok := seen[got]; ok {
+            t.Fatalf("tenants %q and %q mapped to the same tenant %s", other, tenant, got)
+        }
+        seen[got] = tenant
+    }
+}
+
+func TestNewJWTVerifierRequiresIssuerAndAudience(t *testing.T) {
+    newTestVerifier(t)
+
+    tests := []struct {
+        name     string
+        issuer   string
+        audience string
+        resource string
+        expected string
+    }{
+        {"audience", testIssuer, testAudience, "https://sandbox.example.com", testAudience},
+        {"audience defaults to the resource url", testIssuer, "", "https://sandbox.example.com", "https://sandbox.example.com"},
+        {"no audience", testIssuer, "", "", ""},
+        {"no issuer", "", testAudience, "", ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            config.Cfg.AuthJwtIssuer = tt.issuer
+            config.Cfg.AuthJwtAudience = tt.audience
+            config.Cfg.AuthResourceURL = tt.resource
+            v, err := NewJWTVerifier(context.Background())
+            if tt.expected == "" {
+                if err == nil {
+                    t.Fatal("expected error")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if v.audience != tt.expected {
+                t.Fatalf("expected audience %s, got %s", tt.expected, v.audience)
+            }
+        })
+    }
+}
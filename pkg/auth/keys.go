@@ -0,0 +1,119 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package auth
+
+import (
+    "context"
+    "crypto/sha256"
+    "fmt"
+    "os"
+    "sync/atomic"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/util/validation"
+    "k8s.io/apimachinery/pkg/util/wait"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/klog/v2"
+    "sigs.k8s.io/yaml"
+)
+
+// SecretKeysKey is the key of the API keys in the Secret named by config.Cfg.AuthKeysSecret.
+const SecretKeysKey = "keys.yaml"
+
+// APIKey is a static key of a tenant, loaded from config.Cfg.AuthKeysFile or config.Cfg.AuthKeysSecret.
+type APIKey struct {
+    // Name of the key, e.g. the agent or team it was given to, it is logged instead of the key.
+    Name   string   `json:"name"`
+    Key    string   `json:"key"`
+    Tenant string   `json:"tenant"`
+    Roles  []string `json:"roles,omitempty"`
+}
+
+// KeyStore verifies API keys, it reloads them periodically so keys are rotated without a restart.
+type KeyStore struct {
+    client kubernetes.Interface
+    keys   atomic.Pointer[map[[sha256.Size]byte]*APIKey]
+}
+
+// NewKeyStore loads the API keys, client is only used with config.Cfg.AuthKeysSecret.
+func NewKeyStore(ctx context.Context, client kubernetes.Interface) (*KeyStore, error) {
+    ks := &KeyStore{client: client}
+    if err := ks.load(ctx); err != nil {
+        return nil, err
+    }
+    if config.Cfg.AuthReloadInterval > 0 {
+        go wait.Until(func() {
+            if err := ks.load(ctx); err != nil {
+                klog.Errorf("Failed to reload api keys, keep the loaded keys: %v", err)
+            }
+        }, config.Cfg.AuthReloadInterval, ctx.Done())
+    }
+    return ks, nil
+}
+
+// Verify returns the identity of the API key.
+func (ks *KeyStore) Verify(ctx context.Context, token string) (*Identity, error) {
+    key, ok := (*ks.keys.Load())[sha256.Sum256([]byte(token))]
+    if !ok {
+        return nil, ErrInvalidToken
+    }
+    return &Identity{Subject: key.Name, Tenant: key.Tenant, Roles: key.Roles}, nil
+}
+
+func (ks *KeyStore) load(ctx context.Context) error {
+    var raw []byte
+    var source string
+    switch {
+    case config.Cfg.AuthKeysFile != "":
+        source = config.Cfg.AuthKeysFile
+        val, err := os.ReadFile(config.Cfg.AuthKeysFile)
+        if err != nil {
+            return err
+        }
+        raw = val
+    default:
+        source = "secret " + config.Cfg.AuthKeysSecret
+        secret, err := ks.client.CoreV1().Secrets(config.Cfg.SandboxNamespace).Get(ctx, config.Cfg.AuthKeysSecret, v1meta.GetOptions{})
+        if err != nil {
+            return err
+        }
+        val, ok := secret.Data[SecretKeysKey]
+        if !ok {
+            return fmt.Errorf("secret %s has no %s", config.Cfg.AuthKeysSecret, SecretKeysKey)
+        }
+        raw = val
+    }
+
+    var list []*APIKey
+    if err := yaml.Unmarshal(raw, &list); err != nil {
+        return fmt.Errorf("failed to parse api keys of %s: %v", source, err)
+    }
+    keys := make(map[[sha256.Size]byte]*APIKey, len(list))
+    for _, k := range list {
+        if len(k.Key) < 16 {
+            return fmt.Errorf("api key %s of %s is too short, min length 16", k.Name, source)
+        }
+        if errs := validation.IsDNS1123Label(k.Tenant); len(errs) > 0 {
+            return fmt.Errorf("invalid tenant %q of api key %s of %s: %v", k.Tenant, k.Name, source, errs)
+        }
+        // only the hash is kept, a lookup does not leak the key by timing
+        keys[sha256.Sum256([]byte(k.Key))] = &APIKey{Name: k.Name, Tenant: k.Tenant, Roles: k.Roles}
+    }
+    ks.keys.Store(&keys)
+    klog.V(2).Infof("Loaded %d api keys from %s", len(keys), source)
+    return nil
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+    "context"
+    "crypto/sha256"
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+)
+
+func TestKeyStore(t *testing.T) {
+    withConfig(t)
+    config.Cfg.AuthReloadInterval = 0
+    config.Cfg.AuthKeysFile = filepath.Join(t.TempDir(), "keys.yaml")
+    keys := `
+- name: agent-1
+  key: key-of-agent-1-0123456789
+  tenant: team-a
+  roles: [viewer]
+- name: agent-2
+  key: key-of-agent-2-0123456789
+  tenant: team-b
+`
+    if err := os.WriteFile(config.Cfg.AuthKeysFile, []byte(keys), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    ks, err := NewKeyStore(context.Background(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for hash, key := range *ks.keys.Load() {
+        if key.Key != "" {
+            t.Fatalf("key %s is kept in plain text", key.Name)
+        }
+        if hash == sha256.Sum256([]byte(key.Name)) {
+            t.Fatalf("key %s is hashed by its name", key.Name)
+        }
+    }
+
+    tests := []struct {
+        name   string
+        token  string
+        tenant string
+    }{
+        {"agent-1", "key-of-agent-1-0123456789", "team-a"},
+        {"agent-2", "key-of-agent-2-0123456789", "team-b"},
+        {"unknown", "key-of-agent-3-0123456789", ""},
+        {"prefix", "key-of-agent-1", ""},
+        {"empty", "", ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            id, err := ks.Verify(context.Background(), tt.token)
+            if tt.tenant == "" {
+                if !errors.Is(err, ErrInvalidToken) {
+                    t.Fatalf("expected ErrInvalidToken, got %v", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if id.Subject != tt.name || id.Tenant != tt.tenant {
+                t.Fatalf("unexpected identity %+v", id)
+            }
+        })
+    }
+}
+
+func TestKeyStoreInvalidKeys(t *testing.T) {
+    withConfig(t)
+    tests := []struct {
+        name string
+        keys string
+    }{
+        {"short key", "- {name: a, key: too-short, tenant: team-a}\n"},
+        {"invalid tenant", "- {name: a, key: key-of-agent-1-0123456789, tenant: Team_A}\n"},
+        {"no tenant", "- {name: a, key: key-of-agent-1-0123456789}\n"},
+        {"not a list", "name: a\n"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            config.Cfg.AuthKeysFile = filepath.Join(t.TempDir(), "keys.yaml")
+            if err := os.WriteFile(config.Cfg.AuthKeysFile, []byte(tt.keys), 0o600); err != nil {
+                t.Fatal(err)
+            }
+            if _, err := NewKeyStore(context.Background(), nil); err == nil {
+                t.Fatal("expected error")
+            }
+        })
+    }
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package auth
+
+import (
+    "net/http"
+    "strings"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+    "github.com/modelcontextprotocol/go-sdk/oauthex"
+)
+
+// ProtectedResourceMetadataPath is the well-known path of the OAuth protected resource metadata, RFC 9728. The
+// metadata of the MCP endpoint is at ProtectedResourceMetadataPath + MCPPath.
+const ProtectedResourceMetadataPath = "/.well-known/oauth-protected-resource"
+
+// ServeMetadata serves the protected resource metadata of agent-sandbox or of its MCP endpoint, the MCP clients
+// discover the authorization server of config.Cfg.AuthJwtIssuer by it.
+func ServeMetadata(w http.ResponseWriter, r *http.Request) {
+    path := strings.TrimPrefix(r.URL.Path, ProtectedResourceMetadataPath)
+    if path != "" && path != MCPPath {
+        http.NotFound(w, r)
+        return
+    }
+    metadata := &oauthex.ProtectedResourceMetadata{
+        Resource:               resourceURL(r) + path,
+        BearerMethodsSupported: []string{"header"},
+        ResourceName:           "agent-sandbox",
+    }
+    if config.Cfg.AuthJwtIssuer != "" {
+        metadata.AuthorizationServers = []string{config.Cfg.AuthJwtIssuer}
+    }
+    mcpauth.ProtectedResourceMetadataHandler(metadata).ServeHTTP(w, r)
+}
+
+// metadataURL returns the URL of the protected resource metadata of the request, sent in WWW-Authenticate.
+func metadataURL(r *http.Request) string {
+    if r.URL.Path == MCPPath {
+        return resourceURL(r) + ProtectedResourceMetadataPath + MCPPath
+    }
+    return resourceURL(r) + ProtectedResourceMetadataPath
+}
+
+// resourceURL returns the public URL of agent-sandbox, config.Cfg.AuthResourceURL or the URL the request was sent to.
+func resourceURL(r *http.Request) string {
+    if config.Cfg.AuthResourceURL != "" {
+        return strings.TrimSuffix(config.Cfg.AuthResourceURL, "/")
+    }
+    scheme := "http"
+    if r.TLS != nil {
+        scheme = "https"
+    }
+    if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+        scheme = proto
+    }
+    return scheme + "://" + r.Host
+}
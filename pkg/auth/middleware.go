@@ -0,0 +1,159 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package auth
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+    "github.com/modelcontextprotocol/go-sdk/mcp"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/klog/v2"
+)
+
+const (
+    // MCPPath is the path of the streamable MCP handler, its requests are authenticated by bearer tokens only.
+    MCPPath = "/mcp"
+
+    // APIKeyHeader carries an API key, as an alternative to Authorization: Bearer.
+    APIKeyHeader = "X-API-Key"
+)
+
+// publicPaths are served without credentials.
+var publicPaths = []string{"/healthz", ProtectedResourceMetadataPath}
+
+// NewAuthenticator returns the authenticator of the configured API keys and JWKS, nil when authentication is disabled.
+func NewAuthenticator(ctx context.Context, client kubernetes.Interface) (*Authenticator, error) {
+    if !config.Cfg.AuthEnabled {
+        return nil, nil
+    }
+    p, err := LoadPolicy()
+    if err != nil {
+        return nil, err
+    }
+    policy = p
+
+    a := &Authenticator{}
+    if config.Cfg.AuthKeysFile != "" || config.Cfg.AuthKeysSecret != "" {
+        keys, err := NewKeyStore(ctx, client)
+        if err != nil {
+            return nil, err
+        }
+        a.verifiers = append(a.verifiers, keys)
+    }
+    if config.Cfg.AuthJwksFile != "" || config.Cfg.AuthJwksURL != "" {
+        jwt, err := NewJWTVerifier(ctx)
+        if err != nil {
+            return nil, err
+        }
+        a.verifiers = append(a.verifiers, jwt)
+    }
+    if len(a.verifiers) == 0 {
+        return nil, fmt.Errorf("authentication is enabled, but none of AUTH_KEYS_FILE, AUTH_KEYS_SECRET, AUTH_JWKS_FILE or AUTH_JWKS_URL is set")
+    }
+    return a, nil
+}
+
+// Middleware authenticates every request but the public paths and stores the identity of the caller in the request
+// context, the MCP handler gets it by MCPMiddleware.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        for _, p := range publicPaths {
+            if r.URL.Path == p || strings.HasPrefix(r.URL.Path, p+"/") {
+                next.ServeHTTP(w, r)
+                return
+            }
+        }
+        if r.URL.Path == MCPPath {
+            opts := &mcpauth.RequireBearerTokenOptions{ResourceMetadataURL: metadataURL(r)}
+            mcpauth.RequireBearerToken(a.verifyToken, opts)(next).ServeHTTP(w, r)
+            return
+        }
+
+        id, err := a.Authenticate(r.Context(), credential(r))
+        if err != nil {
+            if errors.Is(err, ErrInvalidToken) {
+                klog.V(2).Infof("Unauthorized request %s: %v", r.URL.Path, err)
+                unauthorized(w, r, "invalid or missing credentials")
+                return
+            }
+            klog.Errorf("Failed to authenticate request %s: %v", r.URL.Path, err)
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+    })
+}
+
+// verifyToken is the MCP TokenVerifier, the identity is passed on in the TokenInfo.
+func (a *Authenticator) verifyToken(ctx context.Context, token string, req *http.Request) (*mcpauth.TokenInfo, error) {
+    id, err := a.Authenticate(ctx, token)
+    if err != nil {
+        return nil, err
+    }
+    expiration := id.ExpiresAt
+    if expiration.IsZero() {
+        // API keys do not expire, the token info only lives for the request
+        expiration = time.Now().Add(time.Hour)
+    }
+    return &mcpauth.TokenInfo{
+        UserID:     id.Tenant + "/" + id.Subject,
+        Expiration: expiration,
+        Extra:      map[string]any{identityExtra: id},
+    }, nil
+}
+
+const identityExtra = "identity"
+
+// MCPMiddleware moves the identity of the MCP request to the context of the method handlers.
+func MCPMiddleware() mcp.Middleware {
+    return func(next mcp.MethodHandler) mcp.MethodHandler {
+        return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+            if extra := req.GetExtra(); extra != nil && extra.TokenInfo != nil {
+                if id, ok := extra.TokenInfo.Extra[identityExtra].(*Identity); ok {
+                    ctx = WithIdentity(ctx, id)
+                }
+            }
+            return next(ctx, method, req)
+        }
+    }
+}
+
+// credential returns the bearer token or the API key of the request.
+func credential(r *http.Request) string {
+    if key := r.Header.Get(APIKeyHeader); key != "" {
+        return key
+    }
+    fields := strings.Fields(r.Header.Get("Authorization"))
+    if len(fields) == 2 && strings.EqualFold(fields[0], "bearer") {
+        return fields[1]
+    }
+    return ""
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, msg string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer resource_metadata=%q", metadataURL(r)))
+    w.WriteHeader(http.StatusUnauthorized)
+    json.NewEncoder(w).Encode(map[string]string{"code": "401", "error": msg})
+}
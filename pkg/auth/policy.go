@@ -0,0 +1,139 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package auth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "slices"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    "sigs.k8s.io/yaml"
+)
+
+const (
+    // ActionRead lists, gets and watches the sandboxes, snapshots, groups and operations.
+    ActionRead = "read"
+    // ActionCreate creates, forks and restores sandboxes, takes snapshots and creates groups.
+    ActionCreate = "create"
+    // ActionUpdate updates, pauses, resumes, restarts and extends sandboxes and groups.
+    ActionUpdate = "update"
+    // ActionDelete deletes sandboxes, snapshots and groups.
+    ActionDelete = "delete"
+    // ActionExec enters a sandbox, by the sandbox proxy or the sandboxExecutor tool.
+    ActionExec = "exec"
+    // ActionAllTenants sees and touches the sandboxes of all tenants.
+    ActionAllTenants = "all_tenants"
+    // ActionManageEnvironments manages the environments.
+    ActionManageEnvironments = "manage_environments"
+
+    // ActionAll is every action.
+    ActionAll = "*"
+)
+
+var actions = []string{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionExec, ActionAllTenants, ActionManageEnvironments, ActionAll}
+
+const (
+    RoleViewer   = "viewer"
+    RoleOperator = "operator"
+    RoleAdmin    = "admin"
+)
+
+// ErrForbidden is returned by Authorize when no role of the caller permits the action.
+var ErrForbidden = errors.New("forbidden")
+
+// Policy grants actions to roles, loaded from config.Cfg.AuthPolicyFile.
+type Policy struct {
+    // Actions of each role.
+    Roles map[string][]string `json:"roles"`
+
+    // Role of a caller without roles, e.g. an API key without roles. No action is permitted when empty.
+    DefaultRole string `json:"default_role,omitempty"`
+}
+
+// DefaultPolicy is used without config.Cfg.AuthPolicyFile, the callers without roles are operators.
+var DefaultPolicy = &Policy{
+    Roles: map[string][]string{
+        RoleViewer:   {ActionRead},
+        RoleOperator: {ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionExec},
+        RoleAdmin:    {ActionAll},
+    },
+    DefaultRole: RoleOperator,
+}
+
+var policy = DefaultPolicy
+
+// LoadPolicy reads the policy of config.Cfg.AuthPolicyFile, the DefaultPolicy when it is not set.
+func LoadPolicy() (*Policy, error) {
+    if config.Cfg.AuthPolicyFile == "" {
+        return DefaultPolicy, nil
+    }
+    raw, err := os.ReadFile(config.Cfg.AuthPolicyFile)
+    if err != nil {
+        return nil, err
+    }
+    p := &Policy{}
+    if err := yaml.UnmarshalStrict(raw, p); err != nil {
+        return nil, fmt.Errorf("failed to parse policy %s: %v", config.Cfg.AuthPolicyFile, err)
+    }
+    for role, granted := range p.Roles {
+        for _, action := range granted {
+            if !slices.Contains(actions, action) {
+                return nil, fmt.Errorf("invalid action %q of role %s of policy %s, options are %v", action, role, config.Cfg.AuthPolicyFile, actions)
+            }
+        }
+    }
+    if _, ok := p.Roles[p.DefaultRole]; p.DefaultRole != "" && !ok {
+        return nil, fmt.Errorf("default_role %s of policy %s is not a role", p.DefaultRole, config.Cfg.AuthPolicyFile)
+    }
+    return p, nil
+}
+
+// Allows reports whether one of the roles is granted the action.
+func (p *Policy) Allows(roles []string, action string) bool {
+    if len(roles) == 0 && p.DefaultRole != "" {
+        roles = []string{p.DefaultRole}
+    }
+    for _, role := range roles {
+        granted := p.Roles[role]
+        if slices.Contains(granted, action) || slices.Contains(granted, ActionAll) {
+            return true
+        }
+    }
+    return false
+}
+
+// Authorize returns an error wrapping ErrForbidden if the caller may not perform the action, everything is
+// permitted when authentication is disabled.
+func Authorize(ctx context.Context, action string) error {
+    id := FromContext(ctx)
+    if id == nil || policy.Allows(id.Roles, action) {
+        return nil
+    }
+    return fmt.Errorf("%w: %s of tenant %s with roles %v may not %s", ErrForbidden, id.Subject, id.Tenant, id.Roles, action)
+}
+
+// ScopeFromContext returns the tenant whose sandboxes the caller may see, empty for all tenants when authentication
+// is disabled or the caller is granted ActionAllTenants.
+func ScopeFromContext(ctx context.Context) string {
+    id := FromContext(ctx)
+    if id == nil || policy.Allows(id.Roles, ActionAllTenants) {
+        return ""
+    }
+    return id.Tenant
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+)
+
+func TestPolicyAllows(t *testing.T) {
+    noDefault := &Policy{Roles: DefaultPolicy.Roles}
+    tests := []struct {
+        name    string
+        policy  *Policy
+        roles   []string
+        action  string
+        allowed bool
+    }{
+        {"default role without roles", DefaultPolicy, nil, ActionCreate, true},
+        {"default role is not admin", DefaultPolicy, nil, ActionAllTenants, false},
+        {"roles replace the default role", DefaultPolicy, []string{RoleViewer}, ActionCreate, false},
+        {"viewer reads", DefaultPolicy, []string{RoleViewer}, ActionRead, true},
+        {"admin has all actions", DefaultPolicy, []string{RoleAdmin}, ActionManageEnvironments, true},
+        {"any role grants", DefaultPolicy, []string{"unknown", RoleOperator}, ActionExec, true},
+        {"unknown role", DefaultPolicy, []string{"unknown"}, ActionRead, false},
+        {"no default role", noDefault, nil, ActionRead, false},
+        {"no default role with roles", noDefault, []string{RoleViewer}, ActionRead, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if allowed := tt.policy.Allows(tt.roles, tt.action); allowed != tt.allowed {
+                t.Fatalf("expected %v, got %v", tt.allowed, allowed)
+            }
+        })
+    }
+}
+
+func TestLoadPolicy(t *testing.T) {
+    withConfig(t)
+    tests := []struct {
+        name   string
+        policy string
+        err    bool
+    }{
+        {"valid", "roles:\n  dev: [read, exec]\ndefault_role: dev\n", false},
+        {"unknown action", "roles:\n  dev: [read, shell]\n", true},
+        {"unknown default role", "roles:\n  dev: [read]\ndefault_role: admin\n", true},
+        {"unknown field", "roles:\n  dev: [read]\ndefault: dev\n", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            config.Cfg.AuthPolicyFile = filepath.Join(t.TempDir(), "policy.yaml")
+            if err := os.WriteFile(config.Cfg.AuthPolicyFile, []byte(tt.policy), 0o600); err != nil {
+                t.Fatal(err)
+            }
+            p, err := LoadPolicy()
+            if (err != nil) != tt.err {
+                t.Fatalf("expected error %v, got %v", tt.err, err)
+            }
+            if err == nil && !p.Allows(nil, ActionExec) {
+                t.Fatal("default role dev may not exec")
+            }
+        })
+    }
+}
@@ -37,10 +37,11 @@ func CreateRecorderEventImpl(ctx context.Context, agentName string) record.Event
         klog.V(2).Infof("Creating event broadcaster")
         eventBroadcaster := record.NewBroadcaster()
 
+        // the events are recorded in the namespace of their sandbox, any namespace with SandboxNamespacePerTenant
         watches := []watch.Interface{
             eventBroadcaster.StartLogging(klog.Infof),
             eventBroadcaster.StartRecordingToSink(
-                &v1.EventSinkImpl{Interface: kubeclient.Get(ctx).CoreV1().Events(config.Cfg.SandboxNamespace)}),
+                &v1.EventSinkImpl{Interface: kubeclient.Get(ctx).CoreV1().Events(config.SandboxNamespaces())}),
         }
         recorder = eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: agentName})
         go func() {
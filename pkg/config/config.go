@@ -17,10 +17,17 @@
 package config
 
 import (
+    "crypto/sha256"
+    "encoding/hex"
     "encoding/json"
+    "fmt"
     "os"
+    "strings"
+    "time"
 
     "github.com/kelseyhightower/envconfig"
+    v1core "k8s.io/api/core/v1"
+    "k8s.io/apimachinery/pkg/util/validation"
     "k8s.io/klog/v2"
 )
 
@@ -28,6 +35,23 @@ type Environment struct {
     Name        string `json:"name" required:"false"`
     Image       string `json:"image" required:"false"`
     Description string `json:"description" required:"false"`
+
+    // number of ready sandbox pods kept unassigned, a create claims one instead of waiting for a cold start
+    WarmPoolSize int `json:"warm_pool_size,omitempty" required:"false"`
+
+    // optional pod customization of the sandboxes of this environment, applied on top of SandboxTemplateFile
+    NodeSelector      map[string]string    `json:"node_selector,omitempty" required:"false"`
+    Tolerations       []v1core.Toleration  `json:"tolerations,omitempty" required:"false"`
+    Affinity          *v1core.Affinity     `json:"affinity,omitempty" required:"false"`
+    RuntimeClassName  string               `json:"runtime_class_name,omitempty" required:"false"`
+    PriorityClassName string               `json:"priority_class_name,omitempty" required:"false"`
+    ImagePullSecrets  []string             `json:"image_pull_secrets,omitempty" required:"false"`
+    Volumes           []v1core.Volume      `json:"volumes,omitempty" required:"false"`
+    VolumeMounts      []v1core.VolumeMount `json:"volume_mounts,omitempty" required:"false"`
+
+    // patch of the sandbox ReplicaSet like SandboxTemplateFile, a file path or an inline strategic merge or json patch
+    TemplateFile  string          `json:"template_file,omitempty" required:"false"`
+    TemplatePatch json.RawMessage `json:"template_patch,omitempty" required:"false"`
 }
 
 var Cfg *Config
@@ -40,12 +64,50 @@ type Config struct {
 
     // witch Kubernetes namespace to create sandboxes Replicaset&Pod in
     SandboxNamespace string `split_words:"true" default:"default" required:"false"`
+    // create the sandboxes of each tenant in its own namespace SandboxTenantNamespacePrefix + tenant, created on demand
+    SandboxNamespacePerTenant    bool   `split_words:"true" default:"false" required:"false"`
+    SandboxTenantNamespacePrefix string `split_words:"true" default:"sandbox-" required:"false"`
 
     SandboxTemplateFile string `split_words:"true" default:"" required:"false"`
 
     SandboxEnvironmentConfigFile string `split_words:"true" default:"default-environments.json" required:"false"`
     SandboxDefaultImage          string `split_words:"true" default:"ghcr.io/agent-infra/sandbox:latest" required:"false"`
     SandboxDefaultEnvironment    string `split_words:"true" default:"aio" required:"false"`
+
+    // how long a request holds while a scaled down sandbox is activated, keep it below the api server WriteTimeout
+    SandboxActivationTimeout time.Duration `split_words:"true" default:"25s" required:"false"`
+
+    // manage sandboxes by the agents.sandbox.io Sandbox CustomResourceDefinition, it must be installed first
+    SandboxCRDEnabled bool `split_words:"true" default:"false" required:"false"`
+
+    // bounded worker pool running the sandbox creations, a full queue rejects new creations
+    SandboxOperationWorkers   int           `split_words:"true" default:"10" required:"false"`
+    SandboxOperationQueueSize int           `split_words:"true" default:"100" required:"false"`
+    SandboxOperationTTL       time.Duration `split_words:"true" default:"1h" required:"false"`
+
+    // quotas of the sandbox count, resources and timeout per tenant and environment, unlimited when empty
+    SandboxQuotaFile string `split_words:"true" default:"" required:"false"`
+
+    // authenticate the api, proxy and mcp requests by API keys, each key is bound to a tenant owning its sandboxes
+    AuthEnabled bool `split_words:"true" default:"false" required:"false"`
+    // API keys file, or the name of a Secret in SandboxNamespace, reloaded every AuthReloadInterval
+    AuthKeysFile       string        `split_words:"true" default:"" required:"false"`
+    AuthKeysSecret     string        `split_words:"true" default:"" required:"false"`
+    AuthReloadInterval time.Duration `split_words:"true" default:"1m" required:"false"`
+
+    // accept the JWT bearer tokens of an OIDC identity provider, verified by the JWKS of a file or URL
+    AuthJwksFile string `split_words:"true" default:"" required:"false"`
+    AuthJwksURL  string `split_words:"true" default:"" required:"false"`
+    // required iss and aud of the tokens, the issuer is advertised as authorization server of the resource
+    AuthJwtIssuer   string `split_words:"true" default:"" required:"false"`
+    AuthJwtAudience string `split_words:"true" default:"" required:"false"`
+    // claims of the tenant and roles of the caller, a dot separated path for nested claims e.g. realm_access.roles
+    AuthJwtTenantClaim string `split_words:"true" default:"tenant" required:"false"`
+    AuthJwtRolesClaim  string `split_words:"true" default:"roles" required:"false"`
+    // public URL of agent-sandbox in the protected resource metadata, taken from the request when empty
+    AuthResourceURL string `split_words:"true" default:"" required:"false"`
+    // actions granted to the roles of the callers, the built-in viewer, operator and admin roles when empty
+    AuthPolicyFile string `split_words:"true" default:"" required:"false"`
 }
 
 func init() {
@@ -57,38 +119,114 @@ func init() {
     cfg.APIBaseURL = "/api/" + cfg.APIVersion
     Cfg = &cfg
 
-    LoadEnvironments()
+    // a missing environment config fails the server at startup, not every importer of the package
+    Environments = &[]*Environment{}
+    if err := LoadEnvironments(); err != nil {
+        klog.Error(err)
+    }
 }
 
-func LoadEnvironments() {
+// LoadEnvironments loads the environments from Cfg.SandboxEnvironmentConfigFile.
+func LoadEnvironments() error {
     //load environments config, read file from cfg.SandboxEnvironmentConfigFile by os.ReadFile
     envFile := Cfg.SandboxEnvironmentConfigFile
     klog.Infof("Loading environment config from file %s", envFile)
 
     environments, err := os.ReadFile(envFile)
     if err != nil {
-        klog.Fatalf("Failed to read environment config file %s error: %v", envFile, err)
+        return fmt.Errorf("failed to read environment config file %s error: %v", envFile, err)
     }
 
     var envs []*Environment
     err = json.Unmarshal(environments, &envs)
     if err != nil {
-        klog.Fatalf("Failed to unmarshal environment config file %s error: %v", envFile, err)
+        return fmt.Errorf("failed to unmarshal environment config file %s error: %v", envFile, err)
     }
 
     //check envs not empty
     if len(envs) == 0 {
-        klog.Fatalf("No environments found in config file %s", envFile)
+        return fmt.Errorf("no environments found in config file %s", envFile)
     }
 
     //varify each env has name  image and description
     for _, env := range envs {
         if env.Name == "" || env.Image == "" || env.Description == "" {
-            klog.Fatalf("Invalid environment config in file %s: %+v, name image and desc must not dempty", envFile, env)
+            return fmt.Errorf("invalid environment config in file %s: %+v, name image and desc must not dempty", envFile, env)
+        }
+        if env.TemplateFile != "" && len(env.TemplatePatch) > 0 {
+            return fmt.Errorf("invalid environment config in file %s: %s, only one of template_file and template_patch can be set", envFile, env.Name)
+        }
+        for _, m := range env.VolumeMounts {
+            if !hasVolume(env.Volumes, m.Name) {
+                return fmt.Errorf("invalid environment config in file %s: %s, volume mount %s has no volume", envFile, env.Name, m.Name)
+            }
         }
     }
 
     Environments = &envs
+    return nil
+}
+
+func hasVolume(volumes []v1core.Volume, name string) bool {
+    for _, v := range volumes {
+        if v.Name == name {
+            return true
+        }
+    }
+    return false
+}
+
+// TenantNamespace returns the namespace of the sandboxes of the tenant, SandboxNamespace unless SandboxNamespacePerTenant.
+// A tenant which is no valid namespace name, e.g. Team_A, is mapped by DNSLabel.
+func TenantNamespace(tenant string) string {
+    if !Cfg.SandboxNamespacePerTenant || tenant == "" {
+        return Cfg.SandboxNamespace
+    }
+    namespace := Cfg.SandboxTenantNamespacePrefix + tenant
+    if len(validation.IsDNS1123Label(namespace)) == 0 {
+        return namespace
+    }
+    return Cfg.SandboxTenantNamespacePrefix + DNSLabel(tenant, validation.DNS1123LabelMaxLength-len(Cfg.SandboxTenantNamespacePrefix))
+}
+
+// DNSLabel maps s to a DNS-1123 label of at most maxLen characters, the lower cased s with the other characters replaced
+// by '-' and a hash of s appended, so values sanitized alike, e.g. a@b.com and a-b.com, stay apart.
+func DNSLabel(s string, maxLen int) string {
+    sum := sha256.Sum256([]byte(s))
+    hash := hex.EncodeToString(sum[:])[:8]
+
+    name := []byte(strings.ToLower(s))
+    for i, c := range name {
+        if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+            name[i] = '-'
+        }
+    }
+    if n := maxLen - len(hash) - 1; len(name) > n {
+        name = name[:max(n, 0)]
+    }
+    if trimmed := strings.Trim(string(name), "-"); trimmed != "" {
+        return trimmed + "-" + hash
+    }
+    return hash
+}
+
+// SandboxNamespaces returns the namespace of all the sandboxes, the informers are scoped to. All namespaces ("") with
+// SandboxNamespacePerTenant.
+func SandboxNamespaces() string {
+    if Cfg.SandboxNamespacePerTenant {
+        return ""
+    }
+    return Cfg.SandboxNamespace
+}
+
+// LookupEnvironment returns the configured environment by name, false if there is none, e.g. for custom images.
+func LookupEnvironment(name string) (*Environment, bool) {
+    for _, env := range *Environments {
+        if env.Name == name {
+            return env, true
+        }
+    }
+    return nil, false
 }
 
 func GetEnvironmentByName(name string) *Environment {
@@ -96,12 +234,10 @@ func GetEnvironmentByName(name string) *Environment {
         Name:  Cfg.SandboxDefaultEnvironment,
         Image: Cfg.SandboxDefaultImage,
     }
-    for _, env := range *Environments {
-        if env.Name == name {
-            return env
-        }
+    if env, ok := LookupEnvironment(name); ok {
+        return env
     }
-    klog.Fatalf("Environment %s not found, use default Environment %v", name, defaultEnvironment)
+    klog.Warningf("Environment %s not found, use default Environment %v", name, defaultEnvironment)
     return defaultEnvironment
 }
 
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+    "strings"
+    "testing"
+
+    "k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestTenantNamespace(t *testing.T) {
+    saved := *Cfg
+    t.Cleanup(func() { *Cfg = saved })
+    Cfg.SandboxNamespace = "agent-sandbox"
+    Cfg.SandboxTenantNamespacePrefix = "sandbox-"
+
+    Cfg.SandboxNamespacePerTenant = false
+    if ns := TenantNamespace("team-a"); ns != "agent-sandbox" {
+        t.Fatalf("expected SandboxNamespace without namespace per tenant, got %s", ns)
+    }
+
+    Cfg.SandboxNamespacePerTenant = true
+    tests := []struct {
+        tenant   string
+        expected string
+    }{
+        {"", "agent-sandbox"},
+        {"team-a", "sandbox-team-a"},
+        {"Team_A", "sandbox-team-a-e61a5482"},
+        {"auth0|123", "sandbox-auth0-123-4043445e"},
+    }
+    for _, tt := range tests {
+        if ns := TenantNamespace(tt.tenant); ns != tt.expected {
+            t.Errorf("expected namespace %s of tenant %q, got %s", tt.expected, tt.tenant, ns)
+        }
+    }
+
+    seen := map[string]string{}
+    for _, tenant := range []string{"a@b.com", "a-b.com", "A-B.COM", "-", strings.Repeat("team", 30)} {
+        ns := TenantNamespace(tenant)
+        if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+            t.Errorf("tenant %q mapped to invalid namespace %s: %v", tenant, ns, errs)
+        }
+        if other, ok := seen[ns]; ok {
+            t.Errorf("tenants %q and %q mapped to the same namespace %s", other, tenant, ns)
+        }
+        seen[ns] = tenant
+    }
+}
@@ -23,29 +23,42 @@ import (
     "time"
 
     "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
     "github.com/agent-sandbox/agent-sandbox/pkg/config"
     "github.com/agent-sandbox/agent-sandbox/pkg/router"
     "github.com/agent-sandbox/agent-sandbox/pkg/sandbox"
     "k8s.io/klog/v2"
+    kubeclient "knative.dev/pkg/client/injection/kube/client"
 )
 
 type ApiHttpHandler struct {
-    mux     *http.ServeMux
-    rootCtx context.Context
+    mux       *http.ServeMux
+    rootCtx   context.Context
+    activator *activator.Activator
 }
 
-func New(rootCtx context.Context) *http.Server {
+func New(rootCtx context.Context, a *activator.Activator) *http.Server {
     mux := http.DefaultServeMux
 
     ah := &ApiHttpHandler{
-        rootCtx: rootCtx,
-        mux:     mux,
+        rootCtx:   rootCtx,
+        mux:       mux,
+        activator: a,
     }
     ah.regHandlers()
 
+    var handler http.Handler = mux
+    authenticator, err := auth.NewAuthenticator(rootCtx, kubeclient.Get(rootCtx))
+    if err != nil {
+        klog.Fatalf("Failed to load the api keys: %v", err)
+    }
+    if authenticator != nil {
+        handler = authenticator.Middleware(mux)
+    }
+
     server := &http.Server{
         Addr:         config.Cfg.ServerAddr,
-        Handler:      mux,
+        Handler:      handler,
         ReadTimeout:  5 * time.Minute,
         WriteTimeout: 30 * time.Second,
     }
@@ -55,20 +68,90 @@ func New(rootCtx context.Context) *http.Server {
 }
 
 func (ahh *ApiHttpHandler) regHandlers() {
-    a := activator.NewActivator(ahh.rootCtx)
+    a := ahh.activator
 
     // Rest API for Sandbox management
     sbHeader := sandbox.NewHandler(ahh.rootCtx, a)
-    ahh.mux.HandleFunc(fmt.Sprintf("POST %s/sandbox", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) { wrapperHandler(w, r, sbHeader.CreateSandbox) })
-    ahh.mux.HandleFunc(fmt.Sprintf("GET %s/sandbox", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) { wrapperHandler(w, r, sbHeader.ListSandbox) })
-    ahh.mux.HandleFunc(fmt.Sprintf("DELETE %s/sandbox/{name}", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) { wrapperHandler(w, r, sbHeader.DelSandbox) })
-    ahh.mux.HandleFunc(fmt.Sprintf("GET %s/sandbox/{name}", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) { wrapperHandler(w, r, sbHeader.GetSandbox) })
+    ahh.mux.HandleFunc(fmt.Sprintf("POST %s/sandbox", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionCreate, sbHeader.CreateSandbox)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("GET %s/sandbox", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionRead, sbHeader.ListSandbox)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("DELETE %s/sandbox", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionDelete, sbHeader.DelSandboxes)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("PATCH %s/sandbox/{name}", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionUpdate, sbHeader.UpdateSandbox)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("DELETE %s/sandbox/{name}", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionDelete, sbHeader.DelSandbox)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("GET %s/sandbox/events", config.Cfg.APIBaseURL), authorized(auth.ActionRead, sbHeader.StreamEvents))
+    ahh.mux.HandleFunc(fmt.Sprintf("GET %s/sandbox/{name}", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionRead, sbHeader.GetSandbox)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("GET %s/operations/{id}", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionRead, sbHeader.GetOperation)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("POST %s/sandbox/{name}/pause", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionUpdate, sbHeader.PauseSandbox)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("POST %s/sandbox/{name}/resume", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionUpdate, sbHeader.ResumeSandbox)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("POST %s/sandbox/{name}/restart", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionUpdate, sbHeader.RestartSandbox)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("POST %s/sandbox/{name}/fork", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionCreate, sbHeader.ForkSandbox)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("POST %s/sandbox/{name}/snapshots", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionCreate, sbHeader.CreateSnapshot)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("GET %s/sandbox/{name}/snapshots", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionRead, sbHeader.ListSnapshots)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("DELETE %s/sandbox/{name}/snapshots/{snapshot}", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionDelete, sbHeader.DeleteSnapshot)
+    })
+
+    // Rest API for Sandbox groups, the lifecycle operations cascade to the members
+    ahh.mux.HandleFunc(fmt.Sprintf("POST %s/group", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionCreate, sbHeader.CreateGroup)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("GET %s/group", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionRead, sbHeader.ListGroups)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("GET %s/group/{name}", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) { wrapperHandler(w, r, auth.ActionRead, sbHeader.GetGroup) })
+    ahh.mux.HandleFunc(fmt.Sprintf("DELETE %s/group/{name}", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionDelete, sbHeader.DelGroup)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("POST %s/group/{name}/pause", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionUpdate, sbHeader.PauseGroup)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("POST %s/group/{name}/resume", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionUpdate, sbHeader.ResumeGroup)
+    })
+    ahh.mux.HandleFunc(fmt.Sprintf("POST %s/group/{name}/extend", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionUpdate, sbHeader.ExtendGroup)
+    })
+
+    ahh.mux.HandleFunc(fmt.Sprintf("GET %s/quota", config.Cfg.APIBaseURL), func(w http.ResponseWriter, r *http.Request) {
+        wrapperHandler(w, r, auth.ActionRead, sbHeader.GetQuota)
+    })
 
     // SandboxHandler router, route calls to Sandbox container
     srHandler := router.NewSandboxRouter(ahh.rootCtx, a)
-    ahh.mux.HandleFunc("/sandbox/{name}/{subpath...}", srHandler.ServeHTTP)
+    ahh.mux.HandleFunc("/sandbox/{name}/{subpath...}", authorized(auth.ActionExec, srHandler.ServeHTTP))
+
+    ahh.mux.Handle(auth.MCPPath, sbHeader.McpSseHandler())
 
-    ahh.mux.Handle("/mcp", sbHeader.McpSseHandler())
+    // OAuth protected resource metadata, the MCP clients discover the authorization server by it
+    if config.Cfg.AuthEnabled {
+        ahh.mux.HandleFunc(auth.ProtectedResourceMetadataPath, auth.ServeMetadata)
+        ahh.mux.HandleFunc(auth.ProtectedResourceMetadataPath+"/", auth.ServeMetadata)
+    }
 
     ahh.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
         fmt.Fprintf(w, "OK")
@@ -76,12 +159,33 @@ func (ahh *ApiHttpHandler) regHandlers() {
     })
 }
 
-func wrapperHandler(w http.ResponseWriter, r *http.Request, f func(*http.Request) (interface{}, error)) {
+// authorized rejects the callers whose roles do not grant the action with 403 Forbidden.
+func authorized(action string, h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if err := auth.Authorize(r.Context(), action); err != nil {
+            Forbidden(w, err.Error())
+            return
+        }
+        h(w, r)
+    }
+}
+
+func wrapperHandler(w http.ResponseWriter, r *http.Request, action string, f func(*http.Request) (interface{}, error)) {
+    if err := auth.Authorize(r.Context(), action); err != nil {
+        Forbidden(w, err.Error())
+        return
+    }
+
     result, err := f(r)
     if err != nil {
         Err(w, err.Error())
         return
     }
+    // e.g. 202 Accepted for the operations going on in background
+    if sr, ok := result.(interface{ HTTPStatus() int }); ok {
+        OkWithStatus(w, sr.HTTPStatus(), result)
+        return
+    }
     Ok(w, result)
     return
 }
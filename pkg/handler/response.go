@@ -28,11 +28,16 @@ type response struct {
 }
 
 func Ok(w http.ResponseWriter, s interface{}) {
+    OkWithStatus(w, http.StatusOK, s)
+}
+
+func OkWithStatus(w http.ResponseWriter, status int, s interface{}) {
     data := &response{
         Code: "0",
         Data: s,
     }
     w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
     json.NewEncoder(w).Encode(data)
 }
 
@@ -44,3 +49,13 @@ func Err(w http.ResponseWriter, s string) {
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(data)
 }
+
+func Forbidden(w http.ResponseWriter, s string) {
+    data := &response{
+        Code:  "403",
+        Error: s,
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusForbidden)
+    json.NewEncoder(w).Encode(data)
+}
@@ -23,37 +23,34 @@ import (
     "net/url"
     "time"
 
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
     v1 "k8s.io/api/core/v1"
-    "k8s.io/apimachinery/pkg/labels"
     "k8s.io/apimachinery/pkg/util/wait"
-    podclient "knative.dev/pkg/client/injection/kube/informers/core/v1/pod"
 )
 
+// AcquireDest picks a Ready pod of the sandbox, call Activator.Activate first to wake up a scaled down sandbox.
 func AcquireDest(rootCtx context.Context, name string) (*url.URL, error) {
-    selector, _ := labels.Parse(fmt.Sprintf("sandbox=" + name))
-
     var pods []*v1.Pod
-    var err error
 
     // Wait for pods to be ready avoid faster than rs creation and caching issue
     if perr := wait.PollUntilContextTimeout(context.TODO(), 300*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
-        pods, err = podclient.Get(rootCtx).Lister().List(selector)
+        all, err := activator.GetPods(rootCtx, name)
         if err != nil {
             return false, err
         }
-        if len(pods) == 0 {
-            return false, nil
+        pods = pods[:0]
+        for _, pod := range all {
+            if activator.IsPodReady(pod) {
+                pods = append(pods, pod)
+            }
         }
-        return true, nil
+        return len(pods) > 0, nil
     }); perr != nil {
-        return nil, fmt.Errorf("timeout waiting for get pods for sandbox %v error: %v", name, perr)
+        return nil, fmt.Errorf("timeout waiting for get ready pods for sandbox %v error: %v", name, perr)
     }
 
     pod := pods[rand.Intn(len(pods))]
     ip := pod.Status.PodIP
-    if ip == "" {
-        return nil, fmt.Errorf("sandbox pod IP not found")
-    }
 
     targetURL, _ := url.Parse(fmt.Sprintf("http://%s:%s", ip, "8080"))
 
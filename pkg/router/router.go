@@ -25,6 +25,7 @@ import (
     "time"
 
     "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
     "k8s.io/client-go/kubernetes"
     kubeclient "knative.dev/pkg/client/injection/kube/client"
 )
@@ -52,9 +53,22 @@ func NewSandboxRouter(ctx context.Context, a *activator.Activator) *SandboxRoute
 func (s *SandboxRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
     name := r.PathValue("name")
     fmt.Printf("DynamicProxyRouter ServeHTTP name=%s\n", name)
+    prefixToStrip := "/sandbox/" + name
+
+    // a sandbox of another tenant is not found
+    rs, err := activator.GetReplicaSet(s.rootCtx, name)
+    if err != nil || rs.Labels["owner"] != "agent-sandbox" || !auth.Allowed(auth.ScopeFromContext(r.Context()), rs.Labels[auth.TenantLabel]) {
+        http.Error(w, fmt.Sprintf("sandbox %s not found", name), http.StatusNotFound)
+        return
+    }
+    // only the requests let through count as activity, a denied one must not keep a foreign sandbox alive
     s.activator.RecordLastEvent(activator.EventTypeLastRequest, name)
 
-    prefixToStrip := "/sandbox/" + name
+    // hold the request while a scaled down sandbox is woken up
+    if err := s.activator.Activate(r.Context(), name); err != nil {
+        http.Error(w, fmt.Sprintf("failed to activate sandbox %s: %v", name, err), http.StatusServiceUnavailable)
+        return
+    }
 
     targetURL, err := AcquireDest(s.rootCtx, name)
     if err != nil {
@@ -71,6 +85,11 @@ func (s *SandboxRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
         req.Host = targetURL.Host
 
         req.URL.Path = strings.TrimPrefix(req.URL.Path, prefixToStrip)
+        // the credentials of agent-sandbox are not passed on to the code running in the sandbox
+        if auth.FromContext(req.Context()) != nil {
+            req.Header.Del("Authorization")
+            req.Header.Del(auth.APIKeyHeader)
+        }
         req.Header.Set("X-Request-ID", fmt.Sprintf("%d", time.Now().UnixNano()))
     }
 
@@ -17,68 +17,126 @@
 package sandbox
 
 import (
-    "bytes"
     "encoding/json"
     "fmt"
     "time"
 
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
     "github.com/agent-sandbox/agent-sandbox/pkg/config"
-    "k8s.io/apimachinery/pkg/labels"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    "k8s.io/apimachinery/pkg/types"
     "k8s.io/apimachinery/pkg/util/wait"
     "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/util/retry"
     "k8s.io/klog/v2"
     kubeclient "knative.dev/pkg/client/injection/kube/client"
-    rsclient "knative.dev/pkg/client/injection/kube/informers/apps/v1/replicaset"
-    podclient "knative.dev/pkg/client/injection/kube/informers/core/v1/pod"
 
     "context"
-    "text/template"
 
-    "sigs.k8s.io/yaml"
-
-    v1 "k8s.io/api/apps/v1"
     v1core "k8s.io/api/core/v1"
     v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type Controller struct {
-    client  kubernetes.Interface
-    rootCtx context.Context
+    client    kubernetes.Interface
+    rootCtx   context.Context
+    activator *activator.Activator
+    pool      *WarmPool
 }
 
-func NewController(ctx context.Context) *Controller {
+func NewController(ctx context.Context, a *activator.Activator) *Controller {
     sh := &Controller{
-        rootCtx: ctx,
+        rootCtx:   ctx,
+        activator: a,
     }
     sh.client = kubeclient.Get(ctx)
     return sh
 }
 
+// namespace returns the namespace of the sandbox by name, see activator.SandboxNamespace.
+func (s *Controller) namespace(name string) string {
+    return activator.SandboxNamespace(s.rootCtx, name)
+}
+
 func (s *Controller) Get(name string) *Sandbox {
-    rs, err := s.client.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Get(context.TODO(), name, v1meta.GetOptions{})
+    rs, err := s.client.AppsV1().ReplicaSets(s.namespace(name)).Get(context.TODO(), name, v1meta.GetOptions{})
     if err != nil {
         return nil
     }
-    raw := rs.Annotations["sandbox-data"]
-    sb := &Sandbox{}
-    json.Unmarshal([]byte(raw), sb)
+    sb, err := s.sandboxOf(rs)
+    if err != nil {
+        klog.Errorf("Failed to parse sandbox %s: %v", name, err)
+        return nil
+    }
+    s.fillStatus(sb, rs)
     return sb
 }
 
-func (s *Controller) List() []*Sandbox {
-    selector, _ := labels.Parse("owner=agent-sandbox")
-    rss, err := rsclient.Get(s.rootCtx).Lister().List(selector)
+// List returns the sandboxes selected by the options, sorted and paged.
+func (s *Controller) List(opts ListOptions) ([]*Sandbox, error) {
+    selector, err := opts.selector()
     if err != nil {
-        return nil
+        return nil, err
     }
-    var sandboxes []*Sandbox
+    rss, err := activator.ReplicaSets(s.rootCtx).Lister().List(selector)
+    if err != nil {
+        return nil, err
+    }
+    sandboxes := []*Sandbox{}
     for _, rs := range rss {
-        raw := rs.Annotations["sandbox-data"]
-        sb := &Sandbox{}
-        json.Unmarshal([]byte(raw), sb)
-        sandboxes = append(sandboxes, sb)
+        sb, err := s.sandboxOf(rs)
+        if err != nil {
+            klog.Errorf("Failed to parse sandbox %s: %v", rs.Name, err)
+            continue
+        }
+        s.fillStatus(sb, rs)
+        if opts.match(sb) {
+            sandboxes = append(sandboxes, sb)
+        }
+    }
+    return opts.page(sandboxes), nil
+}
+
+// BulkResult lists the sandboxes a bulk operation succeeded on and the errors of the others.
+type BulkResult struct {
+    Succeeded []string          `json:"succeeded"`
+    Failed    map[string]string `json:"failed,omitempty"`
+}
+
+// forEach runs op on each sandbox and collects the results.
+func forEach(sandboxes []*Sandbox, op func(sb *Sandbox) error) *BulkResult {
+    result := &BulkResult{Succeeded: []string{}}
+    for _, sb := range sandboxes {
+        if err := op(sb); err != nil {
+            if result.Failed == nil {
+                result.Failed = make(map[string]string)
+            }
+            result.Failed[sb.Name] = err.Error()
+            continue
+        }
+        result.Succeeded = append(result.Succeeded, sb.Name)
+    }
+    return result
+}
+
+// DeleteAll deletes the sandboxes selected by the filters of the options, at least one filter is required.
+func (s *Controller) DeleteAll(opts ListOptions) (*BulkResult, error) {
+    if !opts.filtered() {
+        return nil, fmt.Errorf("a labelSelector, environment, app or status filter is required to delete sandboxes")
     }
-    return sandboxes
+    opts.Limit, opts.Offset = 0, 0
+    sandboxes, err := s.List(opts)
+    if err != nil {
+        return nil, err
+    }
+    result := forEach(sandboxes, func(sb *Sandbox) error {
+        if err := s.Delete(sb.Name); err != nil && !apierrors.IsNotFound(err) {
+            return err
+        }
+        return nil
+    })
+    klog.Infof("Bulk deleted %d sandboxes, %d failed", len(result.Succeeded), len(result.Failed))
+    return result, nil
 }
 
 func (s *Controller) Create(sb *Sandbox) error {
@@ -87,35 +145,74 @@ func (s *Controller) Create(sb *Sandbox) error {
         return fmt.Errorf("failed to get kube client, kubeClient is nil")
     }
 
+    if err := s.snapshotEnvironment(sb); err != nil {
+        return err
+    }
     sb.Make()
-    raw, _ := json.Marshal(sb)
-    tplData := SandboxKube{
-        Sandbox:   sb,
-        RawData:   string(raw),
-        Namespace: config.Cfg.SandboxNamespace,
+    if err := s.restoreFromSnapshot(sb); err != nil {
+        return err
+    }
+    if err := sb.Validate(); err != nil {
+        return err
     }
-    tmpl, err := template.New("rs").Parse(SandboxDeployTemplate)
+    release, err := s.reserveQuota(sb)
     if err != nil {
-        return fmt.Errorf("parse template fail: %v", err)
+        return err
     }
+    defer release()
+    // the live fields are computed on read, only the lifecycle status is stored
+    sb.clearLiveFields()
+    sb.Status = StatusCreating
 
-    var buf bytes.Buffer
-    err = tmpl.Execute(&buf, tplData)
+    namespace := config.TenantNamespace(sb.Tenant)
+    if err := s.ensureNamespace(sb.Tenant); err != nil {
+        return err
+    }
+    if err := s.claimName(sb); err != nil {
+        return err
+    }
+    snapshots, err := s.takeForkSnapshots(sb)
     if err != nil {
-        return fmt.Errorf("execute template fail: %v", err)
+        s.releaseUnusedName(sb.Name, namespace)
+        return err
     }
 
-    rsObj := &v1.ReplicaSet{}
-    if err = yaml.Unmarshal(buf.Bytes(), rsObj); err != nil {
-        return fmt.Errorf("unmarshal template fail: %v", err)
+    // a claimed warm pod is adopted by the ReplicaSet, its selector matches the pod, the pool is in SandboxNamespace
+    var claimed string
+    if s.pool != nil && namespace == config.Cfg.SandboxNamespace {
+        claimed = s.pool.Claim(sb)
     }
 
-    if _, err := s.client.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Create(context.TODO(), rsObj, v1meta.CreateOptions{}); err != nil {
-        return fmt.Errorf("create replicaset fail: %v", err)
+    // with the CRD the reconciler creates the ReplicaSet owned by the Sandbox resource
+    if config.Cfg.SandboxCRDEnabled {
+        err = s.createResource(sb)
+    } else {
+        err = s.createReplicaSet(sb, nil)
+    }
+    if err != nil {
+        if claimed != "" {
+            s.pool.Release(claimed)
+        }
+        s.deleteForkSnapshots(sb, snapshots)
+        s.releaseUnusedName(sb.Name, namespace)
+        return err
     }
 
+    var failure error
     if perr := wait.PollUntilContextTimeout(context.TODO(), 500*time.Millisecond, 5*time.Minute, true, func(ctx context.Context) (bool, error) {
-        rsCreated, err := s.client.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Get(context.TODO(), sb.Name, v1meta.GetOptions{})
+        // fail fast on the pod states which never get ready, e.g. a bad image or a crashing entrypoint
+        for _, pod := range s.GetInstances(sb.Name) {
+            if reason, message, failed := podFailure(pod); failed {
+                failure = fmt.Errorf("sandbox pod %s failed: %s: %s", pod.Name, reason, message)
+                return true, nil
+            }
+        }
+
+        rsCreated, err := s.client.AppsV1().ReplicaSets(namespace).Get(context.TODO(), sb.Name, v1meta.GetOptions{})
+        if apierrors.IsNotFound(err) && config.Cfg.SandboxCRDEnabled {
+            // not yet created by the reconciler
+            return false, nil
+        }
         if err != nil {
             return false, err
         }
@@ -123,30 +220,241 @@ func (s *Controller) Create(sb *Sandbox) error {
         replicas := *rsCreated.Spec.Replicas
         if replicas == rsCreated.Status.ReadyReplicas {
             klog.Infof("ReplicaSet %s in namespace %s is ready. Desired: %d, Ready: %d",
-                sb.Name, config.Cfg.SandboxNamespace, replicas, rsCreated.Status.ReadyReplicas)
+                sb.Name, namespace, replicas, rsCreated.Status.ReadyReplicas)
             return true, nil
         } else {
             klog.V(2).Infof("ReplicaSet %s in namespace %s is NOT ready. Desired: %d, Ready: %d\n",
-                sb.Name, config.Cfg.SandboxNamespace, replicas, rsCreated.Status.ReadyReplicas)
+                sb.Name, namespace, replicas, rsCreated.Status.ReadyReplicas)
             return false, nil
         }
     }); perr != nil {
         return fmt.Errorf("timeout waiting for replicaset to be ready: %v", perr)
     }
 
+    if failure != nil {
+        if sb.KeepOnFailure {
+            klog.Warningf("Keep failed sandbox %s for debugging: %v", sb.Name, failure)
+            return fmt.Errorf("%v, the sandbox is kept for debugging, delete it when done", failure)
+        }
+        klog.Warningf("Roll back failed sandbox %s: %v", sb.Name, failure)
+        if err := s.Delete(sb.Name); err != nil && !apierrors.IsNotFound(err) {
+            return fmt.Errorf("%v, roll back failed: %v", failure, err)
+        }
+        s.deleteForkSnapshots(sb, snapshots)
+        return failure
+    }
+
+    return nil
+}
+
+// createReplicaSet creates the sandbox ReplicaSet, owner is set when it is owned by a Sandbox resource.
+func (s *Controller) createReplicaSet(sb *Sandbox, owner *v1meta.OwnerReference) error {
+    rsObj, err := buildReplicaSet(sb)
+    if err != nil {
+        return err
+    }
+    if owner != nil {
+        rsObj.OwnerReferences = append(rsObj.OwnerReferences, *owner)
+    }
+
+    created, err := s.client.AppsV1().ReplicaSets(rsObj.Namespace).Create(context.TODO(), rsObj, v1meta.CreateOptions{})
+    if err != nil {
+        return fmt.Errorf("create replicaset fail: %v", err)
+    }
+
+    if len(sb.Volumes) > 0 {
+        volumeOwner := replicaSetOwnerReference(created)
+        if owner != nil {
+            volumeOwner = *owner
+        }
+        if err := s.ensureVolumes(sb, volumeOwner); err != nil {
+            // the pod can never start without its volumes
+            uid := created.UID
+            if derr := s.client.AppsV1().ReplicaSets(created.Namespace).Delete(context.TODO(), created.Name, v1meta.DeleteOptions{
+                Preconditions: &v1meta.Preconditions{UID: &uid},
+            }); derr != nil {
+                klog.Errorf("Failed to delete replicaset %s without volumes: %v", created.Name, derr)
+            }
+            return err
+        }
+    }
     return nil
 }
 
+// GetInstances returns the pods of the sandbox in the namespace of its ReplicaSet.
 func (s *Controller) GetInstances(name string) []*v1core.Pod {
-    selector, _ := labels.Parse(fmt.Sprintf("sandbox=%s", name))
-    pods, err := podclient.Get(context.TODO()).Lister().List(selector)
+    pods, err := activator.GetPods(s.rootCtx, name)
     if err != nil {
         return nil
     }
     return pods
 }
 
+// updateData applies mutate to the Sandbox stored in the sandbox-data annotation, retrying on conflicts.
+func (s *Controller) updateData(name string, mutate func(sb *Sandbox)) error {
+    namespace := s.namespace(name)
+    return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+        rs, err := s.client.AppsV1().ReplicaSets(namespace).Get(context.TODO(), name, v1meta.GetOptions{})
+        if err != nil {
+            return err
+        }
+        sb, err := ParseSandboxData(rs)
+        if err != nil {
+            return err
+        }
+        mutate(sb)
+        raw, err := json.Marshal(sb)
+        if err != nil {
+            return err
+        }
+        rs.Annotations[SandboxDataAnnotation] = string(raw)
+        _, err = s.client.AppsV1().ReplicaSets(namespace).Update(context.TODO(), rs, v1meta.UpdateOptions{})
+        return err
+    })
+}
+
+// SetStatus records the lifecycle status of the sandbox in its sandbox-data annotation.
+func (s *Controller) SetStatus(name string, status string) error {
+    return s.updateData(name, func(sb *Sandbox) {
+        sb.Status = status
+    })
+}
+
+// Pause scales the sandbox to zero, it is not woken up by requests until resumed. With the CRD it sets spec.paused
+// of the Sandbox resource and waits until the reconciler paused the sandbox.
+func (s *Controller) Pause(name string) error {
+    if config.Cfg.SandboxCRDEnabled {
+        if err := s.setResourcePaused(name, true); err != nil {
+            return err
+        }
+        return s.waitApplied(name, func(sb *Sandbox) bool {
+            return sb.Status == StatusPaused
+        })
+    }
+    return s.transition(name, StatusPaused, 0)
+}
+
+// Resume scales a paused or idle sandbox back to one replica and waits up to timeout for a Ready pod. With the CRD a
+// paused sandbox is resumed by unsetting spec.paused of the Sandbox resource.
+func (s *Controller) Resume(name string, timeout time.Duration) error {
+    if config.Cfg.SandboxCRDEnabled {
+        if err := s.setResourcePaused(name, false); err != nil {
+            return err
+        }
+        err := s.waitApplied(name, func(sb *Sandbox) bool {
+            return sb.Status != StatusPaused
+        })
+        if err != nil {
+            return err
+        }
+    }
+    if err := s.transition(name, StatusRunning, 1); err != nil {
+        return err
+    }
+    // a pod which is not ready in time keeps starting, the sandbox stays running
+    return s.waitReady(name, timeout, nil)
+}
+
+// transition records the status of the sandbox and scales it to replicas, the previous status is restored when the
+// scale fails so the status always matches the replicas.
+func (s *Controller) transition(name string, status string, replicas int32) error {
+    var previous string
+    err := s.updateData(name, func(sb *Sandbox) {
+        previous, sb.Status = sb.Status, status
+    })
+    if err != nil {
+        return err
+    }
+    if err := s.Scale(name, replicas); err != nil {
+        s.restoreStatus(name, previous)
+        return err
+    }
+    return nil
+}
+
+func (s *Controller) restoreStatus(name string, previous string) {
+    if err := s.SetStatus(name, previous); err != nil {
+        klog.Errorf("Failed to restore status %s of sandbox %s: %v", previous, name, err)
+    }
+}
+
+// Restart recycles the sandbox pods and waits up to timeout for a new Ready pod, the ReplicaSet and its data are kept.
+// A paused or idle sandbox has no pods to restart, it is refused. The previous status is restored if the restart fails.
+func (s *Controller) Restart(name string, timeout time.Duration) error {
+    rs, err := s.client.AppsV1().ReplicaSets(s.namespace(name)).Get(context.TODO(), name, v1meta.GetOptions{})
+    if err != nil {
+        return err
+    }
+    sb, err := ParseSandboxData(rs)
+    if err != nil {
+        return err
+    }
+    switch {
+    case sb.Status == StatusPaused:
+        return fmt.Errorf("sandbox %s is paused, resume it instead", name)
+    case sb.Status == StatusIdle || rs.Spec.Replicas == nil || *rs.Spec.Replicas == 0:
+        return fmt.Errorf("sandbox %s is idle, it is started by the next request", name)
+    }
+    previous := sb.Status
+    if previous == StatusRestarting {
+        // left by a restart which was interrupted
+        previous = StatusRunning
+    }
+
+    if err := s.SetStatus(name, StatusRestarting); err != nil {
+        return err
+    }
+    old := make(map[types.UID]bool)
+    for _, pod := range s.GetInstances(name) {
+        old[pod.UID] = true
+        err := s.client.CoreV1().Pods(pod.Namespace).Delete(context.TODO(), pod.Name, v1meta.DeleteOptions{})
+        if err != nil && !apierrors.IsNotFound(err) {
+            s.restoreStatus(name, previous)
+            return fmt.Errorf("failed to delete pod %s: %v", pod.Name, err)
+        }
+    }
+
+    if err := s.waitReady(name, timeout, old); err != nil {
+        s.restoreStatus(name, previous)
+        return err
+    }
+    return s.SetStatus(name, StatusRunning)
+}
+
+// waitReady polls the pod informer until the sandbox has a Ready pod which is not in exclude.
+func (s *Controller) waitReady(name string, timeout time.Duration, exclude map[types.UID]bool) error {
+    if perr := wait.PollUntilContextTimeout(context.TODO(), 500*time.Millisecond, timeout, true, func(ctx context.Context) (bool, error) {
+        for _, pod := range s.GetInstances(name) {
+            if !exclude[pod.UID] && activator.IsPodReady(pod) {
+                return true, nil
+            }
+        }
+        return false, nil
+    }); perr != nil {
+        return fmt.Errorf("sandbox %s is not ready after %s, it is still starting in background: %v", name, timeout, perr)
+    }
+    return nil
+}
+
+// Scale sets the replicas of the sandbox ReplicaSet, 0 scales the sandbox down but keeps it for later resume.
+func (s *Controller) Scale(name string, replicas int32) error {
+    patch := fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas)
+    _, err := s.client.AppsV1().ReplicaSets(s.namespace(name)).Patch(context.TODO(), name, types.MergePatchType, []byte(patch), v1meta.PatchOptions{})
+    return err
+}
+
 func (s *Controller) Delete(name string) error {
-    err := s.client.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Delete(context.TODO(), name, v1meta.DeleteOptions{})
+    namespace := s.namespace(name)
+    var err error
+    // the ReplicaSet is garbage collected with its owning Sandbox resource
+    if config.Cfg.SandboxCRDEnabled {
+        err = s.deleteResource(name)
+    }
+    if !config.Cfg.SandboxCRDEnabled || apierrors.IsNotFound(err) {
+        err = s.client.AppsV1().ReplicaSets(namespace).Delete(context.TODO(), name, v1meta.DeleteOptions{})
+    }
+    if err == nil || apierrors.IsNotFound(err) {
+        s.releaseName(name, namespace)
+    }
     return err
 }
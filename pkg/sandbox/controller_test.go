@@ -0,0 +1,150 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1 "k8s.io/api/apps/v1"
+    v1core "k8s.io/api/core/v1"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/runtime"
+    kubefake "k8s.io/client-go/kubernetes/fake"
+    k8stesting "k8s.io/client-go/testing"
+)
+
+// testSandboxReplicaSet is the ReplicaSet of a made sandbox with the status.
+func testSandboxReplicaSet(t *testing.T, name string, status string) *v1.ReplicaSet {
+    sb := &Sandbox{Status: status}
+    sb.Name = name
+    sb.Make()
+    rs, err := buildReplicaSet(sb)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return rs
+}
+
+// testReadyPod is a Ready pod of the sandbox.
+func testReadyPod(name string) *v1core.Pod {
+    return &v1core.Pod{
+        ObjectMeta: v1meta.ObjectMeta{
+            Name:      name + "-pod",
+            Namespace: config.Cfg.SandboxNamespace,
+            Labels:    map[string]string{"owner": "agent-sandbox", "sandbox": name},
+        },
+        Status: v1core.PodStatus{
+            PodIP:      "10.0.0.1",
+            Conditions: []v1core.PodCondition{{Type: v1core.PodReady, Status: v1core.ConditionTrue}},
+        },
+    }
+}
+
+// getSandbox reads the replicas and the sandbox-data of the sandbox from the api server.
+func getSandbox(t *testing.T, kube *kubefake.Clientset, name string) (int32, *Sandbox) {
+    rs, err := kube.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Get(context.TODO(), name, v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    sb, err := ParseSandboxData(rs)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return *rs.Spec.Replicas, sb
+}
+
+func TestPauseResume(t *testing.T) {
+    s, kube := newTestController(t, testSandboxReplicaSet(t, "sb", StatusRunning), testReadyPod("sb"))
+
+    if err := s.Pause("sb"); err != nil {
+        t.Fatal(err)
+    }
+    if replicas, sb := getSandbox(t, kube, "sb"); replicas != 0 || sb.Status != StatusPaused {
+        t.Fatalf("expected a paused sandbox of 0 replicas, got %s with %d", sb.Status, replicas)
+    }
+
+    if err := s.Resume("sb", time.Second); err != nil {
+        t.Fatal(err)
+    }
+    if replicas, sb := getSandbox(t, kube, "sb"); replicas != 1 || sb.Status != StatusRunning {
+        t.Fatalf("expected a running sandbox of 1 replica, got %s with %d", sb.Status, replicas)
+    }
+}
+
+func TestPauseRestoresStatus(t *testing.T) {
+    s, kube := newTestController(t, testSandboxReplicaSet(t, "sb", StatusRunning))
+    kube.PrependReactor("patch", "replicasets", func(k8stesting.Action) (bool, runtime.Object, error) {
+        return true, nil, fmt.Errorf("admission denied")
+    })
+
+    if err := s.Pause("sb"); err == nil {
+        t.Fatal("expected the pause to fail")
+    }
+    if replicas, sb := getSandbox(t, kube, "sb"); replicas != 1 || sb.Status != StatusRunning {
+        t.Fatalf("expected the running status to be restored, got %s with %d", sb.Status, replicas)
+    }
+}
+
+func TestRestartRefused(t *testing.T) {
+    idle := testSandboxReplicaSet(t, "idle", StatusIdle)
+    zero := int32(0)
+    idle.Spec.Replicas = &zero
+    s, _ := newTestController(t, testSandboxReplicaSet(t, "paused", StatusPaused), idle)
+
+    for name, expected := range map[string]string{"paused": "resume it", "idle": "started by the next request"} {
+        if err := s.Restart(name, time.Second); err == nil || !strings.Contains(err.Error(), expected) {
+            t.Errorf("expected the restart of the %s sandbox to be refused, got %v", name, err)
+        }
+    }
+}
+
+// cacheCreated adds the ReplicaSets created by the controller to its informer cache.
+func cacheCreated(s *Controller, kube *kubefake.Clientset) {
+    indexer := activator.ReplicaSets(s.rootCtx).(*testReplicaSetInformer).indexer
+    kube.PrependReactor("create", "replicasets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+        return false, nil, indexer.Add(action.(k8stesting.CreateAction).GetObject())
+    })
+}
+
+func TestCreateFailsFast(t *testing.T) {
+    for _, keep := range []bool{false, true} {
+        s, kube := newTestController(t, waitingPod("sb", "ImagePullBackOff"))
+        cacheCreated(s, kube)
+        sb := &Sandbox{}
+        sb.Name = "sb"
+        sb.KeepOnFailure = keep
+
+        start := time.Now()
+        err := s.Create(sb)
+        if err == nil || !strings.Contains(err.Error(), "ImagePullBackOff") {
+            t.Fatalf("expected the create to fail on the pod, got %v", err)
+        }
+        if time.Since(start) > 10*time.Second {
+            t.Fatalf("expected the create to fail fast, took %s", time.Since(start))
+        }
+        _, err = kube.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Get(context.TODO(), "sb", v1meta.GetOptions{})
+        if kept := err == nil; kept != keep {
+            t.Fatalf("expected the failed sandbox to be kept %t, got %t", keep, kept)
+        }
+    }
+}
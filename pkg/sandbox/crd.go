@@ -0,0 +1,241 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1 "k8s.io/api/apps/v1"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/apimachinery/pkg/util/wait"
+    "k8s.io/client-go/dynamic/dynamicinformer"
+    "k8s.io/client-go/tools/cache"
+    "knative.dev/pkg/injection/clients/dynamicclient"
+)
+
+const (
+    SandboxGroup   = "agents.sandbox.io"
+    SandboxVersion = "v1alpha1"
+    SandboxKind    = "Sandbox"
+)
+
+var SandboxGVR = schema.GroupVersionResource{
+    Group:    SandboxGroup,
+    Version:  SandboxVersion,
+    Resource: "sandboxes",
+}
+
+// SandboxResource is the agents.sandbox.io/v1alpha1 Sandbox, it owns the sandbox ReplicaSet.
+type SandboxResource struct {
+    v1meta.TypeMeta   `json:",inline"`
+    v1meta.ObjectMeta `json:"metadata,omitempty"`
+
+    Spec   SandboxResourceSpec   `json:"spec,omitempty"`
+    Status SandboxResourceStatus `json:"status,omitempty"`
+}
+
+// SandboxResourceSpec is the desired sandbox, it is the source of truth of the sandbox with the CRD.
+type SandboxResourceSpec struct {
+    Sandbox `json:",inline"`
+
+    // Paused scales the sandbox to zero until it is unset, like the pause and resume operations.
+    Paused bool `json:"paused,omitempty"`
+}
+
+// SandboxResourceStatus mirrors the live status of the sandbox computed from its ReplicaSet and pods.
+type SandboxResourceStatus struct {
+    Phase              string `json:"phase,omitempty"`
+    Reason             string `json:"reason,omitempty"`
+    PodIP              string `json:"podIP,omitempty"`
+    Node               string `json:"node,omitempty"`
+    ReadyReplicas      int32  `json:"readyReplicas"`
+    ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+}
+
+func newSandboxResource(sb *Sandbox) *SandboxResource {
+    spec := *sb
+    // live fields belong to the status, paused to the spec
+    spec.clearLiveFields()
+    return &SandboxResource{
+        TypeMeta: v1meta.TypeMeta{
+            APIVersion: SandboxGroup + "/" + SandboxVersion,
+            Kind:       SandboxKind,
+        },
+        ObjectMeta: v1meta.ObjectMeta{
+            Name:      sb.Name,
+            Namespace: config.TenantNamespace(sb.Tenant),
+            Labels: map[string]string{
+                "owner": "agent-sandbox",
+            },
+        },
+        Spec: SandboxResourceSpec{Sandbox: spec, Paused: sb.Status == StatusPaused},
+    }
+}
+
+func (r *SandboxResource) toUnstructured() (*unstructured.Unstructured, error) {
+    obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r)
+    if err != nil {
+        return nil, err
+    }
+    return &unstructured.Unstructured{Object: obj}, nil
+}
+
+func sandboxResourceFromUnstructured(u *unstructured.Unstructured) (*SandboxResource, error) {
+    r := &SandboxResource{}
+    if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, r); err != nil {
+        return nil, fmt.Errorf("failed to convert sandbox resource %s: %v", u.GetName(), err)
+    }
+    return r, nil
+}
+
+// ownerReference makes the Sandbox resource the controller of the ReplicaSet, deleting it deletes the ReplicaSet.
+func (r *SandboxResource) ownerReference() *v1meta.OwnerReference {
+    controller := true
+    blockOwnerDeletion := true
+    return &v1meta.OwnerReference{
+        APIVersion:         SandboxGroup + "/" + SandboxVersion,
+        Kind:               SandboxKind,
+        Name:               r.Name,
+        UID:                r.UID,
+        Controller:         &controller,
+        BlockOwnerDeletion: &blockOwnerDeletion,
+    }
+}
+
+// createResource creates the Sandbox resource, the reconciler creates its ReplicaSet.
+func (s *Controller) createResource(sb *Sandbox) error {
+    u, err := newSandboxResource(sb).toUnstructured()
+    if err != nil {
+        return fmt.Errorf("convert sandbox resource fail: %v", err)
+    }
+    _, err = dynamicclient.Get(s.rootCtx).Resource(SandboxGVR).Namespace(config.TenantNamespace(sb.Tenant)).Create(context.TODO(), u, v1meta.CreateOptions{})
+    if err != nil {
+        return fmt.Errorf("create sandbox resource fail: %v", err)
+    }
+    return nil
+}
+
+func (s *Controller) deleteResource(name string) error {
+    return dynamicclient.Get(s.rootCtx).Resource(SandboxGVR).Namespace(s.namespace(name)).Delete(context.TODO(), name, v1meta.DeleteOptions{})
+}
+
+// updateResourceSpec writes the updated sandbox back to the spec of its Sandbox resource.
+func (s *Controller) updateResourceSpec(sb *Sandbox, paused bool) error {
+    res := newSandboxResource(sb)
+    res.Spec.Paused = paused
+    spec, err := res.toUnstructured()
+    if err != nil {
+        return err
+    }
+    // replace the whole spec, a merge patch would keep the removed env and labels
+    patch, err := json.Marshal([]map[string]interface{}{{"op": "replace", "path": "/spec", "value": spec.Object["spec"]}})
+    if err != nil {
+        return err
+    }
+    _, err = dynamicclient.Get(s.rootCtx).Resource(SandboxGVR).Namespace(s.namespace(sb.Name)).Patch(context.TODO(), sb.Name, types.JSONPatchType, patch, v1meta.PatchOptions{})
+    return err
+}
+
+// setResourcePaused sets spec.paused of the Sandbox resource, the reconciler pauses or resumes the sandbox.
+func (s *Controller) setResourcePaused(name string, paused bool) error {
+    patch := fmt.Sprintf(`{"spec":{"paused":%t}}`, paused)
+    _, err := dynamicclient.Get(s.rootCtx).Resource(SandboxGVR).Namespace(s.namespace(name)).Patch(context.TODO(), name, types.MergePatchType, []byte(patch), v1meta.PatchOptions{})
+    if err != nil {
+        return fmt.Errorf("update sandbox resource fail: %v", err)
+    }
+    return nil
+}
+
+// fetchResource gets the Sandbox resource from the api server, e.g. to update it.
+func (s *Controller) fetchResource(name string) (*SandboxResource, error) {
+    u, err := dynamicclient.Get(s.rootCtx).Resource(SandboxGVR).Namespace(s.namespace(name)).Get(context.TODO(), name, v1meta.GetOptions{})
+    if err != nil {
+        return nil, err
+    }
+    return sandboxResourceFromUnstructured(u)
+}
+
+// sandboxOf returns the sandbox of the ReplicaSet. With the CRD the spec is read from its Sandbox resource, the
+// sandbox-data annotation is then a copy kept by the reconciler, it is only read for the sandboxes not adopted yet
+// and for the recorded status.
+func (s *Controller) sandboxOf(rs *v1.ReplicaSet) (*Sandbox, error) {
+    sb, err := ParseSandboxData(rs)
+    if err != nil || !config.Cfg.SandboxCRDEnabled {
+        return sb, err
+    }
+    informer := sandboxResourceInformer(s.rootCtx)
+    if !informer.HasSynced() {
+        return sb, nil
+    }
+    obj, exists, err := informer.GetIndexer().GetByKey(rs.Namespace + "/" + rs.Name)
+    if err != nil || !exists {
+        return sb, nil
+    }
+    res, err := sandboxResourceFromUnstructured(obj.(*unstructured.Unstructured))
+    if err != nil {
+        return nil, err
+    }
+    spec := res.Spec.Sandbox
+    spec.Name = rs.Name
+    spec.Status = sb.Status
+    return &spec, nil
+}
+
+// waitApplied polls the sandbox-data annotation until the reconciler applied the Sandbox resource.
+func (s *Controller) waitApplied(name string, applied func(sb *Sandbox) bool) error {
+    timeout := config.Cfg.SandboxActivationTimeout
+    if perr := wait.PollUntilContextTimeout(context.TODO(), 200*time.Millisecond, timeout, true, func(ctx context.Context) (bool, error) {
+        rs, err := activator.GetReplicaSet(s.rootCtx, name)
+        if err != nil {
+            return false, err
+        }
+        sb, err := ParseSandboxData(rs)
+        if err != nil {
+            return false, err
+        }
+        return applied(sb), nil
+    }); perr != nil {
+        return fmt.Errorf("sandbox resource %s is not applied after %s: %v", name, timeout, perr)
+    }
+    return nil
+}
+
+var (
+    resourceInformerOnce sync.Once
+    resourceInformer     cache.SharedIndexInformer
+)
+
+// sandboxResourceInformer returns the informer of the Sandbox resources shared by the reconciler and the controllers,
+// it is started on first use.
+func sandboxResourceInformer(ctx context.Context) cache.SharedIndexInformer {
+    resourceInformerOnce.Do(func() {
+        factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicclient.Get(ctx), 10*time.Minute, config.SandboxNamespaces(), nil)
+        resourceInformer = factory.ForResource(SandboxGVR).Informer()
+        go resourceInformer.Run(ctx.Done())
+    })
+    return resourceInformer
+}
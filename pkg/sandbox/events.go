@@ -0,0 +1,236 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    v1 "k8s.io/api/apps/v1"
+    v1core "k8s.io/api/core/v1"
+    "k8s.io/client-go/tools/cache"
+    "k8s.io/klog/v2"
+)
+
+const (
+    EventCreated    = "created"
+    EventReady      = "ready"
+    EventIdle       = "idle"
+    EventScaledDown = "scaled_down"
+    EventResumed    = "resumed"
+    EventDeleted    = "deleted"
+    EventErrored    = "errored"
+)
+
+// SandboxEvent is a lifecycle change of a sandbox, streamed to the subscribers as server-sent event.
+type SandboxEvent struct {
+    Type    string `json:"type"`
+    Sandbox string `json:"sandbox"`
+    Reason  string `json:"reason,omitempty"`
+    Time    string `json:"time"`
+}
+
+// eventFilter selects the events of a subscriber, an empty field matches all.
+type eventFilter struct {
+    name   string
+    tenant string
+}
+
+// EventHub turns the ReplicaSet and pod informer notifications into SandboxEvents and fans them out to the subscribers.
+type EventHub struct {
+    mu          sync.RWMutex
+    subscribers map[chan *SandboxEvent]eventFilter
+}
+
+func NewEventHub(ctx context.Context) *EventHub {
+    hub := &EventHub{
+        subscribers: make(map[chan *SandboxEvent]eventFilter),
+    }
+
+    activator.ReplicaSets(ctx).Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+        FilterFunc: isSandboxObject,
+        Handler: cache.ResourceEventHandlerDetailedFuncs{
+            AddFunc: func(obj interface{}, isInInitialList bool) {
+                // the existing sandboxes are replayed when the handler is added, they are not new
+                if !isInInitialList {
+                    rs := obj.(*v1.ReplicaSet)
+                    hub.publish(EventCreated, rs.Name, rs.Labels[auth.TenantLabel], "")
+                }
+            },
+            UpdateFunc: func(oldObj, newObj interface{}) {
+                hub.onReplicaSetUpdate(oldObj.(*v1.ReplicaSet), newObj.(*v1.ReplicaSet))
+            },
+            DeleteFunc: func(obj interface{}) {
+                if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+                    obj = tombstone.Obj
+                }
+                if rs, ok := obj.(*v1.ReplicaSet); ok {
+                    hub.publish(EventDeleted, rs.Name, rs.Labels[auth.TenantLabel], "")
+                }
+            },
+        },
+    })
+
+    activator.Pods(ctx).Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+        FilterFunc: isSandboxObject,
+        Handler: cache.ResourceEventHandlerFuncs{
+            UpdateFunc: func(oldObj, newObj interface{}) {
+                oldPod, newPod := oldObj.(*v1core.Pod), newObj.(*v1core.Pod)
+                _, _, wasFailed := podFailure(oldPod)
+                if reason, message, failed := podFailure(newPod); failed && !wasFailed {
+                    hub.publish(EventErrored, newPod.Labels["sandbox"], newPod.Labels[auth.TenantLabel], fmt.Sprintf("%s: %s", reason, message))
+                }
+            },
+        },
+    })
+
+    return hub
+}
+
+func isSandboxObject(obj interface{}) bool {
+    if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+        obj = tombstone.Obj
+    }
+    switch o := obj.(type) {
+    case *v1.ReplicaSet:
+        return o.Labels["owner"] == "agent-sandbox"
+    case *v1core.Pod:
+        return o.Labels["owner"] == "agent-sandbox" && o.Labels["sandbox"] != ""
+    }
+    return false
+}
+
+func (h *EventHub) onReplicaSetUpdate(oldRS, newRS *v1.ReplicaSet) {
+    tenant := newRS.Labels[auth.TenantLabel]
+    oldReplicas, newReplicas := replicasOf(oldRS), replicasOf(newRS)
+    if oldReplicas > 0 && newReplicas == 0 {
+        h.publish(EventScaledDown, newRS.Name, tenant, "")
+    }
+    if oldReplicas == 0 && newReplicas > 0 {
+        h.publish(EventResumed, newRS.Name, tenant, "")
+    }
+    if oldRS.Status.ReadyReplicas == 0 && newRS.Status.ReadyReplicas > 0 {
+        h.publish(EventReady, newRS.Name, tenant, "")
+    }
+
+    // the scaler records the idle status right before applying the idle policy
+    oldSb, oerr := ParseSandboxData(oldRS)
+    newSb, nerr := ParseSandboxData(newRS)
+    if oerr == nil && nerr == nil && oldSb.Status != StatusIdle && newSb.Status == StatusIdle {
+        h.publish(EventIdle, newRS.Name, tenant, fmt.Sprintf("No activity for %d minutes", newSb.IdleTimeout))
+    }
+}
+
+func replicasOf(rs *v1.ReplicaSet) int32 {
+    if rs.Spec.Replicas == nil {
+        return 1
+    }
+    return *rs.Spec.Replicas
+}
+
+func (h *EventHub) publish(eventType string, name string, tenant string, reason string) {
+    event := &SandboxEvent{
+        Type:    eventType,
+        Sandbox: name,
+        Reason:  reason,
+        Time:    time.Now().UTC().Format(time.RFC3339),
+    }
+    klog.V(2).Infof("Sandbox event %s %s %s", eventType, name, reason)
+
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    for ch, filter := range h.subscribers {
+        if (filter.name != "" && filter.name != name) || !auth.Allowed(filter.tenant, tenant) {
+            continue
+        }
+        // never block the informer on a slow subscriber
+        select {
+        case ch <- event:
+        default:
+            klog.Warningf("Drop sandbox event %s %s, subscriber is too slow", eventType, name)
+        }
+    }
+}
+
+// Subscribe returns a channel of the events of the sandbox name of the tenant, all sandboxes when name is empty and all
+// tenants when tenant is empty.
+func (h *EventHub) Subscribe(name string, tenant string) chan *SandboxEvent {
+    ch := make(chan *SandboxEvent, 64)
+    h.mu.Lock()
+    h.subscribers[ch] = eventFilter{name: name, tenant: tenant}
+    h.mu.Unlock()
+    return ch
+}
+
+func (h *EventHub) Unsubscribe(ch chan *SandboxEvent) {
+    h.mu.Lock()
+    delete(h.subscribers, ch)
+    h.mu.Unlock()
+}
+
+// StreamEvents streams the sandbox lifecycle events as server-sent events until the client goes away, ?name= filters one sandbox.
+func (a *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+    name := r.URL.Query().Get("name")
+
+    rc := http.NewResponseController(w)
+    // the stream outlives the api server WriteTimeout
+    if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+        klog.V(2).Infof("Failed to clear write deadline of event stream: %v", err)
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.WriteHeader(http.StatusOK)
+    if err := rc.Flush(); err != nil {
+        klog.Errorf("Event stream does not support flushing: %v", err)
+        return
+    }
+
+    ch := a.events.Subscribe(name, auth.ScopeFromContext(r.Context()))
+    defer a.events.Unsubscribe(ch)
+    klog.V(2).Infof("Event stream subscribed name=%s", name)
+
+    heartbeat := time.NewTicker(15 * time.Second)
+    defer heartbeat.Stop()
+    for {
+        select {
+        case event := <-ch:
+            data, _ := json.Marshal(event)
+            if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+                return
+            }
+        case <-heartbeat.C:
+            // keep the idle connection open through proxies
+            if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+                return
+            }
+        case <-r.Context().Done():
+            klog.V(2).Infof("Event stream closed name=%s", name)
+            return
+        }
+        if err := rc.Flush(); err != nil {
+            return
+        }
+    }
+}
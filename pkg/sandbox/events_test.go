@@ -0,0 +1,87 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "reflect"
+    "testing"
+)
+
+func newTestEventHub() *EventHub {
+    return &EventHub{subscribers: make(map[chan *SandboxEvent]eventFilter)}
+}
+
+// received drains the events queued on the channel.
+func received(ch chan *SandboxEvent) []string {
+    var events []string
+    for {
+        select {
+        case event := <-ch:
+            events = append(events, event.Type+" "+event.Sandbox)
+        default:
+            return events
+        }
+    }
+}
+
+func TestEventHubSubscribe(t *testing.T) {
+    hub := newTestEventHub()
+    all := hub.Subscribe("", "")
+    one := hub.Subscribe("a", "")
+    tenant := hub.Subscribe("", "team-a")
+    gone := hub.Subscribe("", "")
+    hub.Unsubscribe(gone)
+
+    hub.publish(EventCreated, "a", "team-a", "")
+    hub.publish(EventCreated, "b", "team-b", "")
+
+    for name, tt := range map[string]struct {
+        ch       chan *SandboxEvent
+        expected []string
+    }{
+        "all":    {all, []string{"created a", "created b"}},
+        "one":    {one, []string{"created a"}},
+        "tenant": {tenant, []string{"created a"}},
+        "gone":   {gone, nil},
+    } {
+        if got := received(tt.ch); !reflect.DeepEqual(got, tt.expected) {
+            t.Errorf("expected subscriber %s to receive %v, got %v", name, tt.expected, got)
+        }
+    }
+}
+
+func TestEventHubReplicaSetUpdate(t *testing.T) {
+    hub := newTestEventHub()
+    ch := hub.Subscribe("", "")
+
+    running := testSandboxReplicaSet(t, "sb", StatusRunning)
+    running.Status.ReadyReplicas = 1
+    starting := running.DeepCopy()
+    starting.Status.ReadyReplicas = 0
+    idle := testSandboxReplicaSet(t, "sb", StatusIdle)
+    zero := int32(0)
+    idle.Spec.Replicas = &zero
+
+    hub.onReplicaSetUpdate(starting, running)
+    hub.onReplicaSetUpdate(running, idle)
+    hub.onReplicaSetUpdate(idle, starting)
+
+    expected := []string{"ready sb", "scaled_down sb", "idle sb", "resumed sb"}
+    if got := received(ch); !reflect.DeepEqual(got, expected) {
+        t.Fatalf("expected the events %v, got %v", expected, got)
+    }
+}
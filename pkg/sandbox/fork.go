@@ -0,0 +1,134 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "fmt"
+    "time"
+
+    "k8s.io/klog/v2"
+)
+
+const (
+    // ForkMethodClone copies the volumes by CSI volume cloning, the storage class must support it.
+    ForkMethodClone = "clone"
+
+    // ForkMethodSnapshot copies the volumes by a snapshot of each volume, the snapshots are kept unless the fork fails.
+    ForkMethodSnapshot = "snapshot"
+)
+
+// ForkRequest is the body of a fork, all fields are optional.
+type ForkRequest struct {
+    // Name of the new sandbox. default <sandbox>-fork-<unix time>
+    Name string `json:"name,omitempty"`
+
+    // How the volumes are copied, 'clone' or 'snapshot'. default clone
+    Method string `json:"method,omitempty"`
+}
+
+// ForkSnapshots are the snapshots of the volumes of the Source sandbox a fork is provisioned from.
+type ForkSnapshots struct {
+    Source   string
+    Requests []*SnapshotRequest
+}
+
+// Fork returns the spec of a new sandbox copied from the sandbox, its volumes are provisioned from the volumes of the
+// sandbox. It is created by Create like any other sandbox, which takes the snapshots of ForkMethodSnapshot.
+func (s *Controller) Fork(name string, req *ForkRequest) (*Sandbox, error) {
+    method := req.Method
+    if method == "" {
+        method = ForkMethodClone
+    }
+    if method != ForkMethodClone && method != ForkMethodSnapshot {
+        return nil, fmt.Errorf("invalid fork method %q, options are '%s' or '%s'", method, ForkMethodClone, ForkMethodSnapshot)
+    }
+
+    source := s.Get(name)
+    if source == nil {
+        return nil, fmt.Errorf("sandbox %s not found", name)
+    }
+
+    fork := *source
+    fork.Name = req.Name
+    if fork.Name == "" {
+        fork.Name = fmt.Sprintf("%s-fork-%d", name, time.Now().Unix())
+    }
+    fork.clearLiveFields()
+    fork.FromSnapshot = ""
+    fork.Labels = copyMap(source.Labels)
+    fork.Env = copyMap(source.Env)
+    fork.Args = append([]string(nil), source.Args...)
+    fork.Ports = append([]int(nil), source.Ports...)
+
+    fork.ForkSnapshots = nil
+    if method == ForkMethodSnapshot {
+        fork.ForkSnapshots = &ForkSnapshots{Source: name}
+    }
+    fork.Volumes = make([]SandboxVolume, 0, len(source.Volumes))
+    for _, v := range source.Volumes {
+        copied := v
+        copied.ClaimName, copied.Snapshot, copied.CloneFrom = "", "", ""
+        switch method {
+        case ForkMethodClone:
+            copied.CloneFrom = v.claimName(name)
+        case ForkMethodSnapshot:
+            // taken by Create, a fork which is never created leaves no snapshots behind
+            copied.Snapshot = fmt.Sprintf("%s-%s", fork.Name, v.Name)
+            fork.ForkSnapshots.Requests = append(fork.ForkSnapshots.Requests, &SnapshotRequest{Name: copied.Snapshot, Volume: v.Name})
+        }
+        fork.Volumes = append(fork.Volumes, copied)
+    }
+    return &fork, nil
+}
+
+// takeForkSnapshots takes the snapshots a fork is provisioned from and returns their names, the ones taken are deleted
+// again if one fails.
+func (s *Controller) takeForkSnapshots(sb *Sandbox) ([]string, error) {
+    if sb.ForkSnapshots == nil {
+        return nil, nil
+    }
+    var taken []string
+    for _, req := range sb.ForkSnapshots.Requests {
+        snap, err := s.CreateSnapshot(sb.ForkSnapshots.Source, req)
+        if err != nil {
+            s.deleteForkSnapshots(sb, taken)
+            return nil, fmt.Errorf("snapshot volume %s of sandbox %s fail: %v", req.Volume, sb.ForkSnapshots.Source, err)
+        }
+        taken = append(taken, snap.Name)
+    }
+    return taken, nil
+}
+
+// deleteForkSnapshots deletes the snapshots taken for a fork which was not created.
+func (s *Controller) deleteForkSnapshots(sb *Sandbox, snapshots []string) {
+    for _, snapshot := range snapshots {
+        if err := s.DeleteSnapshot(sb.ForkSnapshots.Source, snapshot); err != nil {
+            klog.Errorf("Failed to delete snapshot %s of the failed fork %s: %v", snapshot, sb.Name, err)
+        }
+    }
+}
+
+func copyMap[V any](m map[string]V) map[string]V {
+    if m == nil {
+        return nil
+    }
+    c := make(map[string]V, len(m))
+    for k, v := range m {
+        c[k] = v
+    }
+    return c
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "fmt"
+    "testing"
+
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    dynamicfake "k8s.io/client-go/dynamic/fake"
+    k8stesting "k8s.io/client-go/testing"
+    "knative.dev/pkg/injection/clients/dynamicclient"
+)
+
+// newForkSource returns a controller holding the ReplicaSet of a sandbox with two volumes.
+func newForkSource(t *testing.T) (*Controller, *dynamicfake.FakeDynamicClient) {
+    source := &Sandbox{}
+    source.Name = "source"
+    source.Volumes = []SandboxVolume{
+        {Name: "workspace", Size: "1Gi", MountPath: "/workspace"},
+        {Name: "cache", Size: "1Gi", MountPath: "/cache"},
+    }
+    source.Make()
+    rs, err := buildReplicaSet(source)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s, kube := newTestController(t, rs)
+    // the forks are never created in these tests
+    kube.PrependReactor("create", "replicasets", func(k8stesting.Action) (bool, runtime.Object, error) {
+        return true, nil, fmt.Errorf("admission denied")
+    })
+    return s, dynamicclient.Get(s.rootCtx).(*dynamicfake.FakeDynamicClient)
+}
+
+func snapshotNames(t *testing.T, dynamic *dynamicfake.FakeDynamicClient) []string {
+    list, err := dynamic.Resource(VolumeSnapshotGVR).Namespace("").List(context.TODO(), v1meta.ListOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for _, u := range list.Items {
+        names = append(names, u.GetName())
+    }
+    return names
+}
+
+func TestForkBySnapshot(t *testing.T) {
+    s, dynamic := newForkSource(t)
+
+    fork, err := s.Fork("source", &ForkRequest{Name: "fork", Method: ForkMethodSnapshot})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if names := snapshotNames(t, dynamic); len(names) != 0 {
+        t.Fatalf("expected the snapshots to be taken by the create, got %v", names)
+    }
+    for i, expected := range []string{"fork-workspace", "fork-cache"} {
+        if v := fork.Volumes[i]; v.Snapshot != expected || v.CloneFrom != "" {
+            t.Fatalf("expected volume %s from snapshot %s, got %+v", v.Name, expected, v)
+        }
+    }
+
+    // the snapshots to take are not part of the spec of the Sandbox resource
+    u, err := newSandboxResource(fork).toUnstructured()
+    if err != nil {
+        t.Fatal(err)
+    }
+    res, err := sandboxResourceFromUnstructured(u)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res.Spec.ForkSnapshots != nil {
+        t.Fatalf("unexpected snapshots %v in the sandbox resource", res.Spec.ForkSnapshots)
+    }
+
+    // a failed create takes the snapshots and deletes them again
+    if err := s.Create(fork); err == nil {
+        t.Fatal("expected the create to fail")
+    }
+    created := 0
+    for _, action := range dynamic.Actions() {
+        if action.GetVerb() == "create" && action.GetResource() == VolumeSnapshotGVR {
+            created++
+        }
+    }
+    if created != 2 {
+        t.Fatalf("expected 2 snapshots to be taken, got %d", created)
+    }
+    if names := snapshotNames(t, dynamic); len(names) != 0 {
+        t.Fatalf("expected the snapshots of the failed fork to be deleted, got %v", names)
+    }
+}
+
+func TestForkSnapshotFailure(t *testing.T) {
+    s, dynamic := newForkSource(t)
+    dynamic.PrependReactor("create", "volumesnapshots", func(action k8stesting.Action) (bool, runtime.Object, error) {
+        if obj := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured); obj.GetName() == "fork-cache" {
+            return true, nil, fmt.Errorf("snapshot class not found")
+        }
+        return false, nil, nil
+    })
+
+    fork, err := s.Fork("source", &ForkRequest{Name: "fork", Method: ForkMethodSnapshot})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Create(fork); err == nil {
+        t.Fatal("expected the create to fail")
+    }
+    if names := snapshotNames(t, dynamic); len(names) != 0 {
+        t.Fatalf("expected the snapshot taken before the failure to be deleted, got %v", names)
+    }
+}
+
+func TestForkByClone(t *testing.T) {
+    s, dynamic := newForkSource(t)
+
+    fork, err := s.Fork("source", &ForkRequest{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fork.Name == "" || fork.Name == "source" || fork.ForkSnapshots != nil {
+        t.Fatalf("unexpected fork %s with snapshots %v", fork.Name, fork.ForkSnapshots)
+    }
+    for _, v := range fork.Volumes {
+        if v.CloneFrom != "source-"+v.Name || v.Snapshot != "" {
+            t.Fatalf("expected volume %s cloned from the claim of the source, got %+v", v.Name, v)
+        }
+    }
+    if _, err := s.Fork("source", &ForkRequest{Method: "copy"}); err == nil {
+        t.Fatal("expected an invalid method to fail")
+    }
+    if len(dynamic.Actions()) != 0 {
+        t.Fatalf("expected no snapshots, got %v", dynamic.Actions())
+    }
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1core "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/util/validation"
+    "k8s.io/klog/v2"
+)
+
+const (
+    // GroupLabel names the group stored in a ConfigMap.
+    GroupLabel = "sandbox-group"
+
+    // GroupDataKey is the ConfigMap key of the group JSON.
+    GroupDataKey = "group-data"
+)
+
+// Group is a set of cooperating sandboxes, e.g. of a multi-agent task. A sandbox joins the group by its App, it gets
+// the defaults of the group and is deleted, paused, resumed or extended with it.
+type Group struct {
+    Name        string `json:"name"`
+    Description string `json:"description,omitempty"`
+
+    // defaults of the member sandboxes, a field set on the sandbox wins
+    Environment string            `json:"environment,omitempty"`
+    Image       string            `json:"image,omitempty"`
+    Labels      map[string]string `json:"labels,omitempty"`
+    Timeout     int               `json:"timeout,omitempty"`
+    IdleTimeout int               `json:"idle_timeout,omitempty"`
+    IdlePolicy  string            `json:"idle_policy,omitempty"`
+    CPU         string            `json:"cpu,omitempty"`
+    Memory      string            `json:"memory,omitempty"`
+    CPULimit    string            `json:"cpu_limit,omitempty"`
+    MemoryLimit string            `json:"memory_limit,omitempty"`
+
+    // Tenant owning the group, only the sandboxes of the tenant join it.
+    Tenant string `json:"tenant,omitempty"`
+
+    // Names of the member sandboxes, computed on read.
+    Members []string `json:"members,omitempty"`
+
+    // Creation time of the group, RFC3339.
+    CreatedAt string `json:"created_at,omitempty"`
+}
+
+// ExtendRequest is the body of a group extension.
+type ExtendRequest struct {
+    // Minutes added to the timeout of each member.
+    Minutes int `json:"minutes"`
+}
+
+func groupConfigMapName(name string) string {
+    return "sandbox-group-" + name
+}
+
+// applyDefaults fills the fields of the sandbox left empty with the defaults of the group.
+func (g *Group) applyDefaults(sb *Sandbox) {
+    if sb.Environment == "" && sb.Image == "" {
+        sb.Environment, sb.Image = g.Environment, g.Image
+    }
+    for k, v := range g.Labels {
+        if _, ok := sb.Labels[k]; ok {
+            continue
+        }
+        if sb.Labels == nil {
+            sb.Labels = make(map[string]string)
+        }
+        sb.Labels[k] = v
+    }
+    if sb.Timeout <= 0 {
+        sb.Timeout = g.Timeout
+    }
+    if sb.IdleTimeout <= 0 {
+        sb.IdleTimeout = g.IdleTimeout
+    }
+    if sb.IdlePolicy == "" {
+        sb.IdlePolicy = g.IdlePolicy
+    }
+    if sb.CPU == "" {
+        sb.CPU = g.CPU
+    }
+    if sb.Memory == "" {
+        sb.Memory = g.Memory
+    }
+    if sb.CPULimit == "" {
+        sb.CPULimit = g.CPULimit
+    }
+    if sb.MemoryLimit == "" {
+        sb.MemoryLimit = g.MemoryLimit
+    }
+}
+
+func (g *Group) validate() error {
+    if errs := validation.IsDNS1123Label(g.Name); len(errs) > 0 {
+        return fmt.Errorf("invalid group name %q: %s", g.Name, strings.Join(errs, ", "))
+    }
+    // the defaults must make a valid sandbox
+    sb := &Sandbox{SandboxBase: SandboxBase{Name: g.Name}}
+    g.applyDefaults(sb)
+    sb.Make()
+    return sb.Validate()
+}
+
+// CreateGroup stores the group in a ConfigMap.
+func (s *Controller) CreateGroup(g *Group) error {
+    if err := g.validate(); err != nil {
+        return err
+    }
+    stored := *g
+    stored.Members, stored.CreatedAt = nil, ""
+    raw, err := json.Marshal(stored)
+    if err != nil {
+        return err
+    }
+    cm := &v1core.ConfigMap{
+        ObjectMeta: v1meta.ObjectMeta{
+            Name:      groupConfigMapName(g.Name),
+            Namespace: config.Cfg.SandboxNamespace,
+            Labels: map[string]string{
+                "owner":    "agent-sandbox",
+                GroupLabel: g.Name,
+            },
+        },
+        Data: map[string]string{GroupDataKey: string(raw)},
+    }
+    if _, err := s.client.CoreV1().ConfigMaps(config.Cfg.SandboxNamespace).Create(context.TODO(), cm, v1meta.CreateOptions{}); err != nil {
+        if apierrors.IsAlreadyExists(err) {
+            return fmt.Errorf("group %s already exists", g.Name)
+        }
+        return fmt.Errorf("create group fail: %v", err)
+    }
+    klog.Infof("Created sandbox group %s", g.Name)
+    return nil
+}
+
+// GetGroup returns the group with its members, a NotFound error if there is none.
+func (s *Controller) GetGroup(name string) (*Group, error) {
+    cm, err := s.client.CoreV1().ConfigMaps(config.Cfg.SandboxNamespace).Get(context.TODO(), groupConfigMapName(name), v1meta.GetOptions{})
+    if err != nil {
+        return nil, err
+    }
+    g, err := groupFromConfigMap(cm)
+    if err != nil {
+        return nil, err
+    }
+    members, err := s.groupMembers(g)
+    if err != nil {
+        return nil, err
+    }
+    for _, sb := range members {
+        g.Members = append(g.Members, sb.Name)
+    }
+    return g, nil
+}
+
+// ListGroups returns the groups without their members.
+func (s *Controller) ListGroups() ([]*Group, error) {
+    cms, err := s.client.CoreV1().ConfigMaps(config.Cfg.SandboxNamespace).List(context.TODO(), v1meta.ListOptions{
+        LabelSelector: "owner=agent-sandbox," + GroupLabel,
+    })
+    if err != nil {
+        return nil, err
+    }
+    groups := []*Group{}
+    for i := range cms.Items {
+        g, err := groupFromConfigMap(&cms.Items[i])
+        if err != nil {
+            klog.Errorf("Failed to parse group %s: %v", cms.Items[i].Name, err)
+            continue
+        }
+        groups = append(groups, g)
+    }
+    return groups, nil
+}
+
+// DeleteGroup deletes the members and then the group, the group is kept if a member could not be deleted.
+func (s *Controller) DeleteGroup(name string) (*BulkResult, error) {
+    g, err := s.GetGroup(name)
+    if err != nil {
+        return nil, err
+    }
+    members, err := s.groupMembers(g)
+    if err != nil {
+        return nil, err
+    }
+    result := forEach(members, func(sb *Sandbox) error {
+        if err := s.Delete(sb.Name); err != nil && !apierrors.IsNotFound(err) {
+            return err
+        }
+        return nil
+    })
+    if len(result.Failed) > 0 {
+        return result, nil
+    }
+    err = s.client.CoreV1().ConfigMaps(config.Cfg.SandboxNamespace).Delete(context.TODO(), groupConfigMapName(name), v1meta.DeleteOptions{})
+    if err != nil && !apierrors.IsNotFound(err) {
+        return nil, fmt.Errorf("delete group fail: %v", err)
+    }
+    klog.Infof("Deleted sandbox group %s and %d members", name, len(result.Succeeded))
+    return result, nil
+}
+
+func (s *Controller) PauseGroup(name string) (*BulkResult, error) {
+    return s.forEachMember(name, func(sb *Sandbox) error {
+        return s.Pause(sb.Name)
+    })
+}
+
+func (s *Controller) ResumeGroup(name string, timeout time.Duration) (*BulkResult, error) {
+    return s.forEachMember(name, func(sb *Sandbox) error {
+        return s.Resume(sb.Name, timeout)
+    })
+}
+
+// ExtendGroup adds minutes to the timeout of each member, it is applied in place.
+func (s *Controller) ExtendGroup(name string, minutes int) (*BulkResult, error) {
+    if minutes <= 0 {
+        return nil, fmt.Errorf("invalid minutes %d, must be positive", minutes)
+    }
+    return s.forEachMember(name, func(sb *Sandbox) error {
+        timeout := sb.Timeout + minutes
+        _, err := s.Update(sb.Name, &SandboxPatch{Timeout: &timeout})
+        return err
+    })
+}
+
+func (s *Controller) forEachMember(name string, op func(sb *Sandbox) error) (*BulkResult, error) {
+    g, err := s.GetGroup(name)
+    if err != nil {
+        return nil, err
+    }
+    members, err := s.groupMembers(g)
+    if err != nil {
+        return nil, err
+    }
+    return forEach(members, op), nil
+}
+
+func (s *Controller) groupMembers(g *Group) ([]*Sandbox, error) {
+    return s.List(ListOptions{App: g.Name, Tenant: g.Tenant})
+}
+
+// applyGroup fills the defaults of the group the sandbox joins by its App, an App without group of the tenant is only a
+// tag.
+func (s *Controller) applyGroup(sb *Sandbox) error {
+    if sb.App == "" {
+        return nil
+    }
+    g, err := s.GetGroup(sb.App)
+    if apierrors.IsNotFound(err) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("get group %s fail: %v", sb.App, err)
+    }
+    if g.Tenant != sb.Tenant {
+        return nil
+    }
+    g.applyDefaults(sb)
+    return nil
+}
+
+func groupFromConfigMap(cm *v1core.ConfigMap) (*Group, error) {
+    g := &Group{}
+    if err := json.Unmarshal([]byte(cm.Data[GroupDataKey]), g); err != nil {
+        return nil, fmt.Errorf("failed to parse group %s: %v", cm.Name, err)
+    }
+    g.CreatedAt = cm.CreationTimestamp.UTC().Format(time.RFC3339)
+    return g, nil
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "strings"
+    "testing"
+
+    v1 "k8s.io/api/apps/v1"
+)
+
+func TestGroupApplyDefaults(t *testing.T) {
+    g := &Group{Name: "task", Environment: "aio", Image: "sandbox", Labels: map[string]string{"team": "x", "task": "t-1"},
+        Timeout: 120, IdleTimeout: 30, IdlePolicy: IdlePolicyScaleDown, CPU: "1", Memory: "1Gi"}
+
+    sb := &Sandbox{Labels: map[string]string{"team": "y"}}
+    sb.Timeout = 60
+    sb.CPU = "2"
+    g.applyDefaults(sb)
+    // the fields set on the sandbox win
+    if sb.Environment != "aio" || sb.Image != "sandbox" || sb.Timeout != 60 || sb.IdleTimeout != 30 || sb.IdlePolicy != IdlePolicyScaleDown {
+        t.Fatalf("unexpected settings %+v", sb.SandboxBase)
+    }
+    if sb.CPU != "2" || sb.Memory != "1Gi" || sb.Labels["team"] != "y" || sb.Labels["task"] != "t-1" {
+        t.Fatalf("unexpected resources %s %s or labels %v", sb.CPU, sb.Memory, sb.Labels)
+    }
+
+    // a sandbox of its own image keeps it
+    custom := &Sandbox{}
+    custom.Image = "busybox"
+    g.applyDefaults(custom)
+    if custom.Environment != "" || custom.Image != "busybox" {
+        t.Fatalf("expected the image of the sandbox to be kept, got %q %q", custom.Environment, custom.Image)
+    }
+}
+
+func TestCreateGroup(t *testing.T) {
+    s, _ := newTestController(t)
+    if err := s.CreateGroup(&Group{Name: "Task_1"}); err == nil {
+        t.Fatal("expected an invalid group name to fail")
+    }
+    if err := s.CreateGroup(&Group{Name: "task", IdlePolicy: "hibernate"}); err == nil {
+        t.Fatal("expected invalid defaults to fail")
+    }
+    if err := s.CreateGroup(&Group{Name: "task", Tenant: "team-a", Timeout: 120}); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.CreateGroup(&Group{Name: "task"}); err == nil || !strings.Contains(err.Error(), "already exists") {
+        t.Fatalf("expected the group to exist, got %v", err)
+    }
+
+    // the sandboxes of the tenant join the group by their app
+    sb := &Sandbox{Tenant: "team-a"}
+    sb.App = "task"
+    if err := s.applyGroup(sb); err != nil || sb.Timeout != 120 {
+        t.Fatalf("expected the defaults of the group, got timeout %d: %v", sb.Timeout, err)
+    }
+    other := &Sandbox{Tenant: "team-b"}
+    other.App = "task"
+    if err := s.applyGroup(other); err != nil || other.Timeout != 0 {
+        t.Fatalf("expected no defaults of the group of another tenant, got timeout %d: %v", other.Timeout, err)
+    }
+    tag := &Sandbox{}
+    tag.App = "no-group"
+    if err := s.applyGroup(tag); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// memberSandbox is the ReplicaSet of a sandbox of the app.
+func memberSandbox(t *testing.T, name string, app string) *v1.ReplicaSet {
+    sb := &Sandbox{Status: StatusRunning}
+    sb.Name = name
+    sb.App = app
+    sb.Timeout = 60
+    sb.Make()
+    rs, err := buildReplicaSet(sb)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return rs
+}
+
+func TestGroupLifecycle(t *testing.T) {
+    s, kube := newTestController(t, memberSandbox(t, "a", "task"), memberSandbox(t, "b", "task"), memberSandbox(t, "c", "other"))
+    if err := s.CreateGroup(&Group{Name: "task"}); err != nil {
+        t.Fatal(err)
+    }
+
+    g, err := s.GetGroup("task")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Join(g.Members, ",") != "a,b" {
+        t.Fatalf("expected the members a,b, got %v", g.Members)
+    }
+
+    result, err := s.ExtendGroup("task", 30)
+    if err != nil || len(result.Failed) != 0 {
+        t.Fatalf("unexpected result %+v: %v", result, err)
+    }
+    for name, timeout := range map[string]int{"a": 90, "b": 90, "c": 60} {
+        if _, sb := getSandbox(t, kube, name); sb.Timeout != timeout {
+            t.Errorf("expected the timeout of %s to be %d, got %d", name, timeout, sb.Timeout)
+        }
+    }
+
+    result, err = s.DeleteGroup("task")
+    if err != nil || strings.Join(result.Succeeded, ",") != "a,b" {
+        t.Fatalf("unexpected result %+v: %v", result, err)
+    }
+    if _, err := s.GetGroup("task"); err == nil {
+        t.Fatal("expected the group to be deleted")
+    }
+    if s.Get("a") != nil || s.Get("c") == nil {
+        t.Fatal("expected only the members to be deleted")
+    }
+}
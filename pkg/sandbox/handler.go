@@ -20,10 +20,16 @@ import (
     "context"
     "encoding/json"
     "fmt"
+    "io"
     "net/http"
+    "strconv"
     "sync"
+    "time"
 
     "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
     "k8s.io/klog/v2"
 )
 
@@ -37,10 +43,16 @@ type Handler struct {
     controller   *Controller
     sessionCache *ClientSessionCache
     activator    *activator.Activator
+    operations   *OperationManager
+    events       *EventHub
 }
 
 func NewHandler(rootCtx context.Context, a *activator.Activator) *Handler {
-    c := NewController(rootCtx)
+    c := NewController(rootCtx, a)
+    if WarmPoolEnabled() {
+        c.pool = NewWarmPool(rootCtx, c.client)
+        go c.pool.Run()
+    }
     cache := &ClientSessionCache{
         //sessions: make(map[string]*mcp.ClientSession),
     }
@@ -50,54 +62,240 @@ func NewHandler(rootCtx context.Context, a *activator.Activator) *Handler {
         controller:   c,
         activator:    a,
         sessionCache: cache,
+        operations:   NewOperationManager(rootCtx),
+        events:       NewEventHub(rootCtx),
     }
 }
 
+// CreateSandbox creates the sandbox on the worker pool, with ?async=true it returns 202 and the operation to poll right away.
 func (a *Handler) CreateSandbox(r *http.Request) (interface{}, error) {
-    var sb = DefaultSandbox
-    err := json.NewDecoder(r.Body).Decode(sb)
+    // the fields left out are defaulted by the group of the sandbox and then by Make
+    sb := Sandbox{}
+    err := json.NewDecoder(r.Body).Decode(&sb)
     if err != nil {
         return "", fmt.Errorf("failed to decode request body: %v", err)
     }
-    //sb.Build()
+    sb.Idempotency = nil
+    sb.Tenant = auth.TenantFromContext(r.Context())
+    // hash the request as sent, Make generates a new name on each retry
+    idem, err := NewIdempotency(sb.Tenant, r.Header.Get(IdempotencyKeyHeader), &sb)
+    if err != nil {
+        return "", err
+    }
+    if err = a.controller.snapshotEnvironment(&sb); err != nil {
+        return "", err
+    }
+    if err = a.controller.applyGroup(&sb); err != nil {
+        return "", err
+    }
+    // generate the name now, the operation refers to it
+    sb.Make()
 
     klog.V(2).Infof("Create sandbox opts %v", sb)
 
-    exist := a.controller.Get(sb.Name)
-    if exist != nil {
-        return "", fmt.Errorf("sandbox %s already exists", sb.Name)
+    return a.create(r, &sb, idem)
+}
+
+// create submits the creation of the sandbox, with ?async=true it returns 202 and the operation to poll right away.
+func (a *Handler) create(r *http.Request, sb *Sandbox, idem *Idempotency) (interface{}, error) {
+    op, err := a.submitCreate(sb, idem)
+    if err != nil {
+        return "", fmt.Errorf("failed to create new sandbox, error: %v", err)
     }
+    return a.waitCreate(r, op)
+}
 
-    err = a.controller.Create(sb)
+// waitCreate waits for the create operation, with ?async=true it returns 202 and the operation right away.
+func (a *Handler) waitCreate(r *http.Request, op *Operation) (interface{}, error) {
+    if r.URL.Query().Get("async") == "true" {
+        return &acceptedOperation{op}, nil
+    }
 
-    if err != nil {
+    if err := op.Wait(r.Context()); err != nil {
         klog.Errorf("Failed to create sandbox, err: %v", err)
         return "", fmt.Errorf("failed to create new sandbox, error: %v", err)
     }
 
-    return fmt.Sprintf("Sandbox %s created successfully", sb.Name), nil
+    return fmt.Sprintf("Sandbox %s created successfully", op.Sandbox), nil
+}
+
+// submitCreate queues the creation of the sandbox. A request retried with the same idempotency key gets the operation
+// or the sandbox of the first request, sb.Name is set to its name.
+func (a *Handler) submitCreate(sb *Sandbox, idem *Idempotency) (*Operation, error) {
+    op, err := a.replay(idem)
+    if err != nil {
+        return nil, err
+    }
+    if op != nil {
+        sb.Name = op.Sandbox
+        return op, nil
+    }
+
+    if exist := a.controller.Get(sb.Name); exist != nil {
+        return nil, fmt.Errorf("sandbox %s already exists", sb.Name)
+    }
+    sb.Idempotency = idem
+    op, err = a.operations.SubmitIdempotent(OperationTypeCreate, sb, idem, func() error {
+        return a.controller.Create(sb)
+    })
+    if op != nil {
+        sb.Name = op.Sandbox
+    }
+    return op, err
+}
+
+// replay returns the create operation of the first request with the idempotency key, a succeeded one if its sandbox
+// exists. nil if there was no such request or idem is nil.
+func (a *Handler) replay(idem *Idempotency) (*Operation, error) {
+    if idem == nil {
+        return nil, nil
+    }
+    op, err := a.operations.FindByKey(idem)
+    if op != nil || err != nil {
+        return op, err
+    }
+    existing, err := a.controller.findByIdempotency(idem)
+    if existing == nil || err != nil {
+        return nil, err
+    }
+    return a.operations.Succeeded(OperationTypeCreate, existing), nil
+}
+
+// lookup returns the sandbox if it belongs to the tenant of the caller, a sandbox of another tenant is not found.
+func (a *Handler) lookup(ctx context.Context, name string) (*Sandbox, error) {
+    if name == "" {
+        return nil, fmt.Errorf("sandbox name is required")
+    }
+    sb := a.controller.Get(name)
+    if sb == nil || !auth.Allowed(auth.ScopeFromContext(ctx), sb.Tenant) {
+        return nil, fmt.Errorf("sandbox %s not found", name)
+    }
+    return sb, nil
+}
+
+// lookupSnapshot returns the snapshot and its source sandbox if it belongs to the tenant of the caller.
+func (a *Handler) lookupSnapshot(ctx context.Context, snapshot string) (*Snapshot, *Sandbox, error) {
+    snap, source, err := a.controller.GetSnapshot(snapshot)
+    if err != nil {
+        return nil, nil, err
+    }
+    if !auth.Allowed(auth.ScopeFromContext(ctx), snap.Tenant) {
+        return nil, nil, fmt.Errorf("snapshot %s not found", snapshot)
+    }
+    return snap, source, nil
+}
+
+// lookupGroup returns the group if it belongs to the tenant of the caller.
+func (a *Handler) lookupGroup(ctx context.Context, name string) (*Group, error) {
+    if name == "" {
+        return nil, fmt.Errorf("group name is required")
+    }
+    g, err := a.controller.GetGroup(name)
+    if apierrors.IsNotFound(err) || (err == nil && !auth.Allowed(auth.ScopeFromContext(ctx), g.Tenant)) {
+        return nil, fmt.Errorf("group %s not found", name)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to get group %s: %v", name, err)
+    }
+    return g, nil
+}
+
+// maxOperationWait caps the ?wait= long-poll below the api server WriteTimeout.
+const maxOperationWait = 25 * time.Second
+
+// GetOperation returns the operation by id, ?wait=10s holds until the operation is done or the wait elapsed.
+func (a *Handler) GetOperation(r *http.Request) (interface{}, error) {
+    id := r.PathValue("id")
+    if id == "" {
+        return nil, fmt.Errorf("operation id is required")
+    }
+
+    op := a.operations.Get(id, auth.ScopeFromContext(r.Context()))
+    if op == nil {
+        return "", fmt.Errorf("operation %s not found", id)
+    }
+
+    if waitParam := r.URL.Query().Get("wait"); waitParam != "" {
+        waitFor, err := time.ParseDuration(waitParam)
+        if err != nil {
+            seconds, serr := strconv.Atoi(waitParam)
+            if serr != nil {
+                return "", fmt.Errorf("invalid wait %q, e.g. 10s or 10: %v", waitParam, err)
+            }
+            waitFor = time.Duration(seconds) * time.Second
+        }
+        if waitFor > maxOperationWait {
+            waitFor = maxOperationWait
+        }
+        ctx, cancel := context.WithTimeout(r.Context(), waitFor)
+        defer cancel()
+        // a wait timeout is not an error, the operation is returned as it is
+        _ = op.Wait(ctx)
+    }
+
+    return op, nil
 }
 
+// ListSandbox lists the sandboxes, filtered by labelSelector, environment, app and status, sorted by sort and order,
+// paged by limit and offset.
 func (a *Handler) ListSandbox(r *http.Request) (interface{}, error) {
-    sbs := a.controller.List()
-    if sbs == nil {
-        return "", fmt.Errorf("no sandboxes found")
+    opts, err := ListOptionsFromQuery(r.URL.Query())
+    if err != nil {
+        return "", err
+    }
+    opts.Tenant = auth.ScopeFromContext(r.Context())
+
+    sbs, err := a.controller.List(opts)
+    if err != nil {
+        return "", fmt.Errorf("failed to list sandboxes: %v", err)
     }
 
     return sbs, nil
 }
 
+// DelSandboxes deletes all sandboxes matching the filters of ListSandbox, e.g. ?labelSelector=conversation=c-1
+func (a *Handler) DelSandboxes(r *http.Request) (interface{}, error) {
+    opts, err := ListOptionsFromQuery(r.URL.Query())
+    if err != nil {
+        return "", err
+    }
+    opts.Tenant = auth.ScopeFromContext(r.Context())
+
+    klog.V(2).Infof("Delete sandboxes %v", opts)
+
+    result, err := a.controller.DeleteAll(opts)
+    if err != nil {
+        return "", fmt.Errorf("failed to delete sandboxes: %v", err)
+    }
+
+    return result, nil
+}
+
 func (a *Handler) GetSandbox(r *http.Request) (interface{}, error) {
     name := r.PathValue("name")
-    if name == "" {
-        return nil, fmt.Errorf("sandbox name is required")
-    }
 
     klog.V(2).Infof("Get sandbox name=%s", name)
 
-    sb := a.controller.Get(name)
-    if sb == nil {
-        return "", fmt.Errorf("sandbox %s not found", name)
+    return a.lookup(r.Context(), name)
+}
+
+// UpdateSandbox applies a partial update to a running sandbox and returns it.
+func (a *Handler) UpdateSandbox(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    if _, err := a.lookup(r.Context(), name); err != nil {
+        return nil, err
+    }
+
+    patch := &SandboxPatch{}
+    if err := json.NewDecoder(r.Body).Decode(patch); err != nil {
+        return "", fmt.Errorf("failed to decode request body: %v", err)
+    }
+
+    klog.V(2).Infof("Update sandbox name=%s", name)
+
+    sb, err := a.controller.Update(name, patch)
+    if err != nil {
+        return "", fmt.Errorf("failed to update sandbox %s: %v", name, err)
     }
 
     return sb, nil
@@ -105,8 +303,8 @@ func (a *Handler) GetSandbox(r *http.Request) (interface{}, error) {
 
 func (a *Handler) DelSandbox(r *http.Request) (interface{}, error) {
     name := r.PathValue("name")
-    if name == "" {
-        return nil, fmt.Errorf("sandbox name is required")
+    if _, err := a.lookup(r.Context(), name); err != nil {
+        return nil, err
     }
 
     klog.V(2).Infof("Delete sandbox name=%s", name)
@@ -118,3 +316,275 @@ func (a *Handler) DelSandbox(r *http.Request) (interface{}, error) {
 
     return fmt.Sprintf("Sandbox %s deleted successfully", name), nil
 }
+
+func (a *Handler) PauseSandbox(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    if _, err := a.lookup(r.Context(), name); err != nil {
+        return nil, err
+    }
+
+    klog.V(2).Infof("Pause sandbox name=%s", name)
+
+    if err := a.controller.Pause(name); err != nil {
+        return "", fmt.Errorf("failed to pause sandbox %s: %v", name, err)
+    }
+
+    return fmt.Sprintf("Sandbox %s paused successfully", name), nil
+}
+
+func (a *Handler) ResumeSandbox(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    if _, err := a.lookup(r.Context(), name); err != nil {
+        return nil, err
+    }
+
+    klog.V(2).Infof("Resume sandbox name=%s", name)
+
+    if err := a.controller.Resume(name, config.Cfg.SandboxActivationTimeout); err != nil {
+        return "", fmt.Errorf("failed to resume sandbox %s: %v", name, err)
+    }
+
+    return fmt.Sprintf("Sandbox %s resumed successfully", name), nil
+}
+
+func (a *Handler) RestartSandbox(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    if _, err := a.lookup(r.Context(), name); err != nil {
+        return nil, err
+    }
+
+    klog.V(2).Infof("Restart sandbox name=%s", name)
+
+    if err := a.controller.Restart(name, config.Cfg.SandboxActivationTimeout); err != nil {
+        return "", fmt.Errorf("failed to restart sandbox %s: %v", name, err)
+    }
+
+    return fmt.Sprintf("Sandbox %s restarted successfully", name), nil
+}
+
+// ForkSandbox creates a new sandbox with the spec and a copy of the volumes of the sandbox, ?async=true as CreateSandbox.
+func (a *Handler) ForkSandbox(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    if _, err := a.lookup(r.Context(), name); err != nil {
+        return nil, err
+    }
+
+    // the body is optional
+    req := &ForkRequest{}
+    if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+        return "", fmt.Errorf("failed to decode request body: %v", err)
+    }
+    idem, err := NewIdempotency(auth.TenantFromContext(r.Context()), r.Header.Get(IdempotencyKeyHeader), map[string]interface{}{"fork": name, "request": req})
+    if err != nil {
+        return "", err
+    }
+    // a retried fork must not take the snapshots again
+    op, err := a.replay(idem)
+    if err != nil {
+        return "", err
+    }
+    if op != nil {
+        return a.waitCreate(r, op)
+    }
+    if req.Name != "" && a.controller.Get(req.Name) != nil {
+        return "", fmt.Errorf("sandbox %s already exists", req.Name)
+    }
+
+    klog.V(2).Infof("Fork sandbox name=%s", name)
+
+    sb, err := a.controller.Fork(name, req)
+    if err != nil {
+        return "", fmt.Errorf("failed to fork sandbox %s: %v", name, err)
+    }
+
+    return a.create(r, sb, idem)
+}
+
+func (a *Handler) CreateSnapshot(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    if _, err := a.lookup(r.Context(), name); err != nil {
+        return nil, err
+    }
+
+    // the body is optional
+    req := &SnapshotRequest{}
+    if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+        return "", fmt.Errorf("failed to decode request body: %v", err)
+    }
+
+    klog.V(2).Infof("Create snapshot of sandbox name=%s", name)
+
+    snap, err := a.controller.CreateSnapshot(name, req)
+    if err != nil {
+        return "", fmt.Errorf("failed to snapshot sandbox %s: %v", name, err)
+    }
+
+    return snap, nil
+}
+
+func (a *Handler) ListSnapshots(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    if name == "" {
+        return nil, fmt.Errorf("sandbox name is required")
+    }
+
+    snaps, err := a.controller.ListSnapshots(name)
+    if err != nil {
+        return "", fmt.Errorf("failed to list snapshots of sandbox %s: %v", name, err)
+    }
+
+    // the snapshots outlive the sandbox, they are filtered by their own tenant
+    tenant := auth.ScopeFromContext(r.Context())
+    owned := make([]*Snapshot, 0, len(snaps))
+    for _, snap := range snaps {
+        if auth.Allowed(tenant, snap.Tenant) {
+            owned = append(owned, snap)
+        }
+    }
+    return owned, nil
+}
+
+func (a *Handler) DeleteSnapshot(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    snapshot := r.PathValue("snapshot")
+    if name == "" || snapshot == "" {
+        return nil, fmt.Errorf("sandbox name and snapshot are required")
+    }
+
+    klog.V(2).Infof("Delete snapshot %s of sandbox name=%s", snapshot, name)
+
+    if _, _, err := a.lookupSnapshot(r.Context(), snapshot); err != nil {
+        return "", fmt.Errorf("failed to delete snapshot %s: %v", snapshot, err)
+    }
+
+    if err := a.controller.DeleteSnapshot(name, snapshot); err != nil {
+        return "", fmt.Errorf("failed to delete snapshot %s: %v", snapshot, err)
+    }
+
+    return fmt.Sprintf("Snapshot %s deleted successfully", snapshot), nil
+}
+
+func (a *Handler) CreateGroup(r *http.Request) (interface{}, error) {
+    g := &Group{}
+    if err := json.NewDecoder(r.Body).Decode(g); err != nil {
+        return "", fmt.Errorf("failed to decode request body: %v", err)
+    }
+
+    g.Tenant = auth.TenantFromContext(r.Context())
+
+    klog.V(2).Infof("Create group name=%s", g.Name)
+
+    if err := a.controller.CreateGroup(g); err != nil {
+        return "", fmt.Errorf("failed to create group: %v", err)
+    }
+
+    return fmt.Sprintf("Group %s created successfully", g.Name), nil
+}
+
+func (a *Handler) ListGroups(r *http.Request) (interface{}, error) {
+    groups, err := a.controller.ListGroups()
+    if err != nil {
+        return "", fmt.Errorf("failed to list groups: %v", err)
+    }
+
+    tenant := auth.ScopeFromContext(r.Context())
+    owned := make([]*Group, 0, len(groups))
+    for _, g := range groups {
+        if auth.Allowed(tenant, g.Tenant) {
+            owned = append(owned, g)
+        }
+    }
+    return owned, nil
+}
+
+func (a *Handler) GetGroup(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+
+    return a.lookupGroup(r.Context(), name)
+}
+
+// DelGroup deletes the group and all its member sandboxes.
+func (a *Handler) DelGroup(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    if _, err := a.lookupGroup(r.Context(), name); err != nil {
+        return nil, err
+    }
+
+    klog.V(2).Infof("Delete group name=%s", name)
+
+    result, err := a.controller.DeleteGroup(name)
+    if err != nil {
+        return "", fmt.Errorf("failed to delete group %s: %v", name, err)
+    }
+
+    return result, nil
+}
+
+func (a *Handler) PauseGroup(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    if _, err := a.lookupGroup(r.Context(), name); err != nil {
+        return nil, err
+    }
+
+    klog.V(2).Infof("Pause group name=%s", name)
+
+    result, err := a.controller.PauseGroup(name)
+    if err != nil {
+        return "", fmt.Errorf("failed to pause group %s: %v", name, err)
+    }
+
+    return result, nil
+}
+
+func (a *Handler) ResumeGroup(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    if _, err := a.lookupGroup(r.Context(), name); err != nil {
+        return nil, err
+    }
+
+    klog.V(2).Infof("Resume group name=%s", name)
+
+    result, err := a.controller.ResumeGroup(name, config.Cfg.SandboxActivationTimeout)
+    if err != nil {
+        return "", fmt.Errorf("failed to resume group %s: %v", name, err)
+    }
+
+    return result, nil
+}
+
+func (a *Handler) ExtendGroup(r *http.Request) (interface{}, error) {
+    name := r.PathValue("name")
+    if _, err := a.lookupGroup(r.Context(), name); err != nil {
+        return nil, err
+    }
+
+    req := &ExtendRequest{}
+    if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+        return "", fmt.Errorf("failed to decode request body: %v", err)
+    }
+
+    klog.V(2).Infof("Extend group name=%s by %d minutes", name, req.Minutes)
+
+    result, err := a.controller.ExtendGroup(name, req.Minutes)
+    if err != nil {
+        return "", fmt.Errorf("failed to extend group %s: %v", name, err)
+    }
+
+    return result, nil
+}
+
+// GetQuota reports the usage of the tenant of the caller against its quotas, a caller seeing all tenants may ask for
+// another one by ?tenant=.
+func (a *Handler) GetQuota(r *http.Request) (interface{}, error) {
+    tenant := auth.TenantFromContext(r.Context())
+    if t := r.URL.Query().Get("tenant"); t != "" && auth.ScopeFromContext(r.Context()) == "" {
+        tenant = t
+    }
+
+    report, err := a.controller.QuotaReport(tenant)
+    if err != nil {
+        return "", fmt.Errorf("failed to get the quota usage of tenant %s: %v", tenant, err)
+    }
+
+    return report, nil
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+    // IdempotencyKeyHeader is the header of the client-supplied idempotency key of a create.
+    IdempotencyKeyHeader = "Idempotency-Key"
+
+    // IdempotencyKeyLabel is the hashed idempotency key on the sandbox ReplicaSet, to find the sandbox of a retry.
+    IdempotencyKeyLabel = "sandbox-idempotency-key"
+)
+
+// Idempotency identifies the create request of a sandbox, a retry with the same key gets the sandbox of the first
+// request instead of a duplicate. Only hashes are kept, the key is not stored.
+type Idempotency struct {
+    Key     string `json:"key"`
+    Request string `json:"request"`
+}
+
+// NewIdempotency hashes the key of the tenant and the request, nil if there is no key. The same key of two tenants
+// identifies two different requests.
+func NewIdempotency(tenant string, key string, request any) (*Idempotency, error) {
+    if key == "" {
+        return nil, nil
+    }
+    if len(key) > 255 {
+        return nil, fmt.Errorf("invalid %s, max length 255", IdempotencyKeyHeader)
+    }
+    raw, err := json.Marshal(request)
+    if err != nil {
+        return nil, err
+    }
+    if tenant != "" {
+        key = tenant + "/" + key
+    }
+    keySum := sha256.Sum256([]byte(key))
+    requestSum := sha256.Sum256(raw)
+    return &Idempotency{
+        // a label value has at most 63 characters
+        Key:     hex.EncodeToString(keySum[:24]),
+        Request: hex.EncodeToString(requestSum[:]),
+    }, nil
+}
+
+// check fails when the key was used by a different request.
+func (i *Idempotency) check(other *Idempotency) error {
+    if other.Request != i.Request {
+        return fmt.Errorf("%s was already used by a different request", IdempotencyKeyHeader)
+    }
+    return nil
+}
+
+// findByIdempotency returns the sandbox created with the idempotency key, nil if there is none.
+func (s *Controller) findByIdempotency(idem *Idempotency) (*Sandbox, error) {
+    selector := labels.SelectorFromSet(labels.Set{"owner": "agent-sandbox", IdempotencyKeyLabel: idem.Key})
+    rss, err := activator.ReplicaSets(s.rootCtx).Lister().List(selector)
+    if err != nil || len(rss) == 0 {
+        return nil, err
+    }
+    sb, err := ParseSandboxData(rss[0])
+    if err != nil {
+        return nil, err
+    }
+    if sb.Idempotency == nil {
+        return nil, nil
+    }
+    if err := idem.check(sb.Idempotency); err != nil {
+        return nil, err
+    }
+    s.fillStatus(sb, rss[0])
+    return sb, nil
+}
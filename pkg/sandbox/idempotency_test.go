@@ -0,0 +1,127 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+
+    "k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestNewIdempotency(t *testing.T) {
+    if idem, err := NewIdempotency("team-a", "", nil); idem != nil || err != nil {
+        t.Fatalf("expected no idempotency without key, got %v: %v", idem, err)
+    }
+    if _, err := NewIdempotency("team-a", strings.Repeat("k", 256), nil); err == nil {
+        t.Fatal("expected a too long key to fail")
+    }
+
+    request := map[string]string{"image": "busybox"}
+    a, err := NewIdempotency("team-a", "key-1", request)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if errs := validation.IsValidLabelValue(a.Key); len(errs) > 0 {
+        t.Fatalf("expected the key to be a label value: %v", errs)
+    }
+    // the same key of another tenant is another request
+    b, err := NewIdempotency("team-b", "key-1", request)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if a.Key == b.Key {
+        t.Fatal("expected the same key of two tenants to differ")
+    }
+    // the same key of the tenant with another request
+    c, err := NewIdempotency("team-a", "key-1", map[string]string{"image": "alpine"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if a.Key != c.Key || a.check(c) == nil {
+        t.Fatal("expected the key to be reused by a different request")
+    }
+}
+
+func TestSubmitIdempotent(t *testing.T) {
+    withOperations(t, 1, 10)
+    m := newTestOperationManager(t)
+    idem, _ := NewIdempotency("", "key-1", "request")
+    other, _ := NewIdempotency("", "key-1", "other request")
+
+    first, err := m.SubmitIdempotent(OperationTypeCreate, testTenantSandbox("first", ""), idem, func() error {
+        return fmt.Errorf("admission denied")
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := m.SubmitIdempotent(OperationTypeCreate, testTenantSandbox("second", ""), other, func() error { return nil }); err == nil {
+        t.Fatal("expected the key of a different request to fail")
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := first.Wait(ctx); err == nil {
+        t.Fatal("expected the first operation to fail")
+    }
+    // a failed operation is retried, a succeeded one replayed
+    retry, err := m.SubmitIdempotent(OperationTypeCreate, testTenantSandbox("first", ""), idem, func() error { return nil })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if retry == first {
+        t.Fatal("expected the failed operation to be retried")
+    }
+    if err := retry.Wait(ctx); err != nil {
+        t.Fatal(err)
+    }
+    replayed, err := m.SubmitIdempotent(OperationTypeCreate, testTenantSandbox("first", ""), idem, func() error {
+        t.Error("expected the succeeded operation not to run again")
+        return nil
+    })
+    if err != nil || replayed != retry {
+        t.Fatalf("expected the succeeded operation to be replayed, got %v: %v", replayed, err)
+    }
+}
+
+func TestFindByIdempotency(t *testing.T) {
+    idem, _ := NewIdempotency("team-a", "key-1", "request")
+    sb := &Sandbox{Tenant: "team-a", Idempotency: idem}
+    sb.Name = "sb"
+    sb.Make()
+    rs, err := buildReplicaSet(sb)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s, _ := newTestController(t, rs)
+
+    found, err := s.findByIdempotency(idem)
+    if err != nil || found == nil || found.Name != "sb" {
+        t.Fatalf("expected the sandbox of the key, got %v: %v", found, err)
+    }
+    other, _ := NewIdempotency("team-a", "key-1", "other request")
+    if _, err := s.findByIdempotency(other); err == nil {
+        t.Fatal("expected the key of a different request to fail")
+    }
+    tenant, _ := NewIdempotency("team-b", "key-1", "request")
+    if found, err := s.findByIdempotency(tenant); found != nil || err != nil {
+        t.Fatalf("expected no sandbox of the key of another tenant, got %v: %v", found, err)
+    }
+}
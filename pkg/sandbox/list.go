@@ -0,0 +1,151 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "fmt"
+    "net/url"
+    "sort"
+    "strconv"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    "k8s.io/apimachinery/pkg/labels"
+    "k8s.io/apimachinery/pkg/selection"
+)
+
+const (
+    SortByName         = "name"
+    SortByCreatedAt    = "created_at"
+    SortByLastActiveAt = "last_active_at"
+)
+
+// ListOptions filters, sorts and pages the sandboxes, the zero value lists all sandboxes sorted by name.
+type ListOptions struct {
+    // Kubernetes label selector on the user-defined labels, e.g. conversation=c-1,team in (a,b)
+    LabelSelector string
+    Environment   string
+    App           string
+    Status        string
+
+    // only the sandboxes of the tenant, all tenants when empty
+    Tenant string
+
+    // name, created_at or last_active_at, descending with Desc
+    SortBy string
+    Desc   bool
+
+    // page of the sorted sandboxes, no limit when Limit is 0
+    Limit  int
+    Offset int
+}
+
+// filtered tells if the options select a part of the sandboxes, a bulk delete requires it.
+func (o *ListOptions) filtered() bool {
+    return o.LabelSelector != "" || o.Environment != "" || o.App != "" || o.Status != ""
+}
+
+// ListOptionsFromQuery reads the options from the query parameters labelSelector, environment, app, status, sort,
+// order, limit and offset.
+func ListOptionsFromQuery(q url.Values) (ListOptions, error) {
+    opts := ListOptions{
+        LabelSelector: q.Get("labelSelector"),
+        Environment:   q.Get("environment"),
+        App:           q.Get("app"),
+        Status:        q.Get("status"),
+        SortBy:        q.Get("sort"),
+    }
+    switch opts.SortBy {
+    case "", SortByName, SortByCreatedAt, SortByLastActiveAt:
+    default:
+        return opts, fmt.Errorf("invalid sort %q, options are '%s', '%s' or '%s'", opts.SortBy, SortByName, SortByCreatedAt, SortByLastActiveAt)
+    }
+    switch q.Get("order") {
+    case "", "asc":
+    case "desc":
+        opts.Desc = true
+    default:
+        return opts, fmt.Errorf("invalid order %q, options are 'asc' or 'desc'", q.Get("order"))
+    }
+    for param, value := range map[string]*int{"limit": &opts.Limit, "offset": &opts.Offset} {
+        if v := q.Get(param); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil || n < 0 {
+                return opts, fmt.Errorf("invalid %s %q, must be a non negative number", param, v)
+            }
+            *value = n
+        }
+    }
+    return opts, nil
+}
+
+// selector returns the label selector of the options restricted to the sandbox ReplicaSets.
+func (o *ListOptions) selector() (labels.Selector, error) {
+    selector, err := labels.Parse(o.LabelSelector)
+    if err != nil {
+        return nil, fmt.Errorf("invalid labelSelector %q: %v", o.LabelSelector, err)
+    }
+    owner, err := labels.NewRequirement("owner", selection.Equals, []string{"agent-sandbox"})
+    if err != nil {
+        return nil, err
+    }
+    selector = selector.Add(*owner)
+    if o.Tenant != "" {
+        tenant, err := labels.NewRequirement(auth.TenantLabel, selection.Equals, []string{o.Tenant})
+        if err != nil {
+            return nil, err
+        }
+        selector = selector.Add(*tenant)
+    }
+    return selector, nil
+}
+
+// match tells if the sandbox matches the filters which are not labels, Status must be filled.
+func (o *ListOptions) match(sb *Sandbox) bool {
+    return (o.Environment == "" || sb.Environment == o.Environment) &&
+        (o.App == "" || sb.App == o.App) &&
+        (o.Status == "" || sb.Status == o.Status)
+}
+
+// page sorts the sandboxes and returns the page selected by Offset and Limit.
+func (o *ListOptions) page(sandboxes []*Sandbox) []*Sandbox {
+    key := func(sb *Sandbox) string {
+        switch o.SortBy {
+        case SortByCreatedAt:
+            return sb.CreatedAt
+        case SortByLastActiveAt:
+            return sb.LastActiveAt
+        }
+        return sb.Name
+    }
+    // RFC3339 times in UTC sort as strings, ties are ordered by name
+    sort.SliceStable(sandboxes, func(i, j int) bool {
+        ki, kj := key(sandboxes[i]), key(sandboxes[j])
+        if ki == kj {
+            return sandboxes[i].Name < sandboxes[j].Name
+        }
+        return (ki < kj) != o.Desc
+    })
+
+    if o.Offset >= len(sandboxes) {
+        return []*Sandbox{}
+    }
+    sandboxes = sandboxes[o.Offset:]
+    if o.Limit > 0 && o.Limit < len(sandboxes) {
+        sandboxes = sandboxes[:o.Limit]
+    }
+    return sandboxes
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+
+    v1 "k8s.io/api/apps/v1"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestListOptionsFromQuery(t *testing.T) {
+    opts, err := ListOptionsFromQuery(url.Values{"labelSelector": {"team=x"}, "sort": {"created_at"}, "order": {"desc"}, "limit": {"10"}, "offset": {"20"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if opts.LabelSelector != "team=x" || opts.SortBy != SortByCreatedAt || !opts.Desc || opts.Limit != 10 || opts.Offset != 20 {
+        t.Fatalf("unexpected options %+v", opts)
+    }
+    for _, q := range []url.Values{{"sort": {"size"}}, {"order": {"up"}}, {"limit": {"-1"}}, {"offset": {"x"}}} {
+        if _, err := ListOptionsFromQuery(q); err == nil {
+            t.Errorf("expected the query %v to fail", q)
+        }
+    }
+}
+
+// listedSandbox is the ReplicaSet of a sandbox created age ago with the labels.
+func listedSandbox(t *testing.T, name string, tenant string, age time.Duration, labels map[string]string) *v1.ReplicaSet {
+    sb := &Sandbox{Tenant: tenant, Labels: labels, Status: StatusRunning}
+    sb.Name = name
+    sb.Make()
+    rs, err := buildReplicaSet(sb)
+    if err != nil {
+        t.Fatal(err)
+    }
+    rs.CreationTimestamp = v1meta.NewTime(time.Now().Add(-age))
+    return rs
+}
+
+func sandboxNames(sandboxes []*Sandbox) string {
+    var names []string
+    for _, sb := range sandboxes {
+        names = append(names, sb.Name)
+    }
+    return strings.Join(names, ",")
+}
+
+func newListController(t *testing.T) *Controller {
+    s, _ := newTestController(t,
+        listedSandbox(t, "a", "team-a", 3*time.Hour, map[string]string{"team": "x"}),
+        listedSandbox(t, "b", "team-b", 1*time.Hour, map[string]string{"team": "y"}),
+        listedSandbox(t, "c", "team-a", 2*time.Hour, map[string]string{"team": "x", "conversation": "c-1"}),
+        listedSandbox(t, "d", "team-a", 4*time.Hour, nil),
+    )
+    return s
+}
+
+func TestList(t *testing.T) {
+    s := newListController(t)
+
+    tests := []struct {
+        opts     ListOptions
+        expected string
+    }{
+        {ListOptions{}, "a,b,c,d"},
+        {ListOptions{LabelSelector: "team=x"}, "a,c"},
+        {ListOptions{LabelSelector: "team in (x,y),conversation!=c-1"}, "a,b"},
+        {ListOptions{LabelSelector: "!team"}, "d"},
+        {ListOptions{Tenant: "team-a"}, "a,c,d"},
+        {ListOptions{Status: StatusIdle}, ""},
+        {ListOptions{SortBy: SortByCreatedAt}, "d,a,c,b"},
+        {ListOptions{SortBy: SortByCreatedAt, Desc: true}, "b,c,a,d"},
+        {ListOptions{Limit: 2}, "a,b"},
+        {ListOptions{Limit: 2, Offset: 3}, "d"},
+        {ListOptions{Offset: 4}, ""},
+    }
+    for _, tt := range tests {
+        sandboxes, err := s.List(tt.opts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got := sandboxNames(sandboxes); got != tt.expected {
+            t.Errorf("expected %+v to list %q, got %q", tt.opts, tt.expected, got)
+        }
+    }
+
+    if _, err := s.List(ListOptions{LabelSelector: "team in (x"}); err == nil {
+        t.Fatal("expected an invalid selector to fail")
+    }
+}
+
+func TestDeleteAll(t *testing.T) {
+    s := newListController(t)
+
+    if _, err := s.DeleteAll(ListOptions{Tenant: "team-a"}); err == nil {
+        t.Fatal("expected a delete without filter to fail")
+    }
+    // the page is ignored, all the selected sandboxes are deleted
+    result, err := s.DeleteAll(ListOptions{LabelSelector: "team=x", Limit: 1})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Join(result.Succeeded, ",") != "a,c" || len(result.Failed) != 0 {
+        t.Fatalf("unexpected result %+v", result)
+    }
+    for name, exists := range map[string]bool{"a": false, "b": true, "c": false, "d": true} {
+        if got := s.Get(name) != nil; got != exists {
+            t.Errorf("expected sandbox %s to exist %t, got %t", name, exists, got)
+        }
+    }
+}
@@ -0,0 +1,39 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "os"
+    "testing"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    "k8s.io/klog/v2"
+)
+
+func TestMain(m *testing.M) {
+    // the environments of the repo, the package directory has no config file
+    config.Cfg.SandboxEnvironmentConfigFile = "../../default-environments.json"
+    if err := config.LoadEnvironments(); err != nil {
+        klog.Fatal(err)
+    }
+    overlays, err := loadEnvironmentOverlays(*config.Environments)
+    if err != nil {
+        klog.Fatal(err)
+    }
+    environmentOverlays = overlays
+    os.Exit(m.Run())
+}
@@ -22,6 +22,7 @@ import (
     "fmt"
     "net/http"
 
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
     "github.com/agent-sandbox/agent-sandbox/pkg/config"
     "github.com/google/jsonschema-go/jsonschema"
     "github.com/modelcontextprotocol/go-sdk/mcp"
@@ -36,36 +37,69 @@ func (a *Handler) McpSseHandler() *mcp.StreamableHTTPHandler {
 
     // Add MCP-level logging middleware.
     server.AddReceivingMiddleware(createLoggingMiddleware())
+    // the tools are scoped to the tenant of the bearer token
+    server.AddReceivingMiddleware(auth.MCPMiddleware())
 
     // Add the tools.
     environmentsDesc := config.GetEnvironmentsForMCPTools()
     inputSchema := &jsonschema.Schema{
         Type: "object",
         Properties: map[string]*jsonschema.Schema{
-            "name":        {Type: "string", Description: "The name of the Sandbox to create. You can leave it empty to auto generate or specify it yourself with contextual meaning. only contain lowercase letters numbers and '-', max length 50, add timestamp suffix to avoid name conflict, e.g. 'sandbox-execute-code-1766483780."},
-            "environment": {Type: "string", Description: "The environment to use for the Sandbox. Must be one of the predefined environments. Available environments:\n" + environmentsDesc},
+            "name":            {Type: "string", Description: "The name of the Sandbox to create. You can leave it empty to auto generate or specify it yourself with contextual meaning. only contain lowercase letters numbers and '-', max length 50, add timestamp suffix to avoid name conflict, e.g. 'sandbox-execute-code-1766483780."},
+            "environment":     {Type: "string", Description: "The environment to use for the Sandbox. Must be one of the predefined environments. Available environments:\n" + environmentsDesc},
+            "idempotency_key": {Type: "string", Description: "Optional unique key of this creation, e.g. a UUID. Retry a failed or timed out call with the same key to get the Sandbox of the first call instead of a duplicate."},
         },
     }
     mcp.AddTool(server, &mcp.Tool{
         Name:        "createSandbox",
         Description: "Create a new Sandbox for execution python or javascript code, browser use, etc. Return Tools schema of Sandbox  for further use by call sandboxExecutor Tool.",
         InputSchema: inputSchema,
-    }, a.CreateSandboxTool)
+    }, authorized(auth.ActionCreate, a.CreateSandboxTool))
 
     mcp.AddTool(server, &mcp.Tool{
         Name:        "getSandbox",
         Description: "Get the details of a Sandbox by name",
-    }, a.GetSandboxTool)
+    }, authorized(auth.ActionRead, a.GetSandboxTool))
 
     mcp.AddTool(server, &mcp.Tool{
         Name:        "deleteSandbox",
         Description: "Delete a Sandbox by name. Best practice to delete the Sandbox after all tasks are done to free resources.",
-    }, a.DelSandboxTool)
+    }, authorized(auth.ActionDelete, a.DelSandboxTool))
+
+    mcp.AddTool(server, &mcp.Tool{
+        Name:        "pauseSandbox",
+        Description: "Pause a Sandbox by name. The Sandbox stops consuming compute resources but keeps its identity, resume it to continue.",
+    }, authorized(auth.ActionUpdate, a.PauseSandboxTool))
+
+    mcp.AddTool(server, &mcp.Tool{
+        Name:        "resumeSandbox",
+        Description: "Resume a paused or idle Sandbox by name and wait until it is ready again.",
+    }, authorized(auth.ActionUpdate, a.ResumeSandboxTool))
+
+    mcp.AddTool(server, &mcp.Tool{
+        Name:        "restartSandbox",
+        Description: "Restart the Sandbox container by name, use it when the Sandbox is stuck or broken. Files outside persistent volumes are lost.",
+    }, authorized(auth.ActionUpdate, a.RestartSandboxTool))
+
+    mcp.AddTool(server, &mcp.Tool{
+        Name:        "snapshotSandbox",
+        Description: "Checkpoint the workspace volume of a Sandbox by name, restore it later by call restoreSandbox Tool with the returned snapshot name. Only Sandboxes created with volumes can be snapshotted.",
+    }, authorized(auth.ActionCreate, a.SnapshotSandboxTool))
+
+    mcp.AddTool(server, &mcp.Tool{
+        Name:        "restoreSandbox",
+        Description: "Create a new Sandbox from a snapshot, e.g. to roll back a broken Sandbox to a checkpoint or to branch from it. Delete the broken Sandbox when it is not needed anymore.",
+    }, authorized(auth.ActionCreate, a.RestoreSandboxTool))
+
+    mcp.AddTool(server, &mcp.Tool{
+        Name:        "forkSandbox",
+        Description: "Fork a Sandbox by name into a new Sandbox with the same environment and a copy of its workspace, e.g. to explore several solutions in parallel from the same prepared Sandbox.",
+    }, authorized(auth.ActionCreate, a.ForkSandboxTool))
 
     mcp.AddTool(server, &mcp.Tool{
         Name:        "sandboxExecutor",
         Description: "Execute commands or actions inside the Sandbox",
-    }, a.executorHandler)
+    }, authorized(auth.ActionExec, a.executorHandler))
 
     // Create the streamable HTTP handler.
     handler := mcp.NewStreamableHTTPHandler(func(req *http.Request) *mcp.Server {
@@ -78,24 +112,48 @@ func (a *Handler) McpSseHandler() *mcp.StreamableHTTPHandler {
     return handler
 }
 
-func (a *Handler) CreateSandboxTool(ctx context.Context, req *mcp.CallToolRequest, sandbox *SandboxBase) (*mcp.CallToolResult, any, error) {
-    klog.V(2).Infof("Create sandbox opts %v", sandbox)
+// authorized fails the tool call if the roles of the caller do not grant the action.
+func authorized[In any](action string, h mcp.ToolHandlerFor[In, any]) mcp.ToolHandlerFor[In, any] {
+    return func(ctx context.Context, req *mcp.CallToolRequest, input In) (*mcp.CallToolResult, any, error) {
+        if err := auth.Authorize(ctx, action); err != nil {
+            return nil, nil, err
+        }
+        return h(ctx, req, input)
+    }
+}
 
-    exist := a.controller.Get(sandbox.Name)
-    if exist != nil {
-        return nil, nil, fmt.Errorf("sandbox %s already exists", sandbox.Name)
+// CreateToolInput is the input of the createSandbox tool.
+type CreateToolInput struct {
+    SandboxBase
+    IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+func (a *Handler) CreateSandboxTool(ctx context.Context, req *mcp.CallToolRequest, input *CreateToolInput) (*mcp.CallToolResult, any, error) {
+    klog.V(2).Infof("Create sandbox opts %v", input.SandboxBase)
+
+    tenant := auth.TenantFromContext(ctx)
+    idem, err := NewIdempotency(tenant, input.IdempotencyKey, &input.SandboxBase)
+    if err != nil {
+        return nil, nil, err
     }
+    sb := &Sandbox{
+        SandboxBase: input.SandboxBase,
+        Tenant:      tenant,
+    }
+    // generate the name now, it is returned to the agent
+    sb.Make()
 
-    err := a.controller.Create(&Sandbox{
-        SandboxBase: *sandbox,
-    })
+    op, err := a.submitCreate(sb, idem)
+    if err == nil {
+        err = op.Wait(ctx)
+    }
 
     if err != nil {
         klog.Errorf("Failed to create sandbox, err: %v", err)
         return nil, nil, fmt.Errorf("failed to create new sandbox, error: %v", err)
     }
 
-    stools, err := a.sandboxTools(ctx, sandbox.Name)
+    stools, err := a.sandboxTools(ctx, sb.Name)
     if err != nil {
         stools = fmt.Sprintf("failed to get Sandbox tools error: %s, please retry to get Sandbox Tools by call getSandbox Tool", err.Error())
     }
@@ -103,7 +161,7 @@ func (a *Handler) CreateSandboxTool(ctx context.Context, req *mcp.CallToolReques
 
     return &mcp.CallToolResult{
         Content: []mcp.Content{
-            &mcp.TextContent{Text: fmt.Sprintf("Sandbox created, Sandbox name:%s, %s", sandbox.Name, stools)},
+            &mcp.TextContent{Text: fmt.Sprintf("Sandbox created, Sandbox name:%s, %s", sb.Name, stools)},
         },
     }, nil, nil
 }
@@ -115,9 +173,9 @@ func (a *Handler) GetSandboxTool(ctx context.Context, req *mcp.CallToolRequest,
 
     klog.V(2).Infof("Get sandbox tool by name=%s", sandbox.Name)
 
-    sb := a.controller.Get(sandbox.Name)
-    if sb == nil {
-        return nil, nil, fmt.Errorf("sandbox %s not found", sandbox.Name)
+    sb, err := a.lookup(ctx, sandbox.Name)
+    if err != nil {
+        return nil, nil, err
     }
 
     sbJson, err := json.Marshal(sb)
@@ -146,6 +204,10 @@ func (a *Handler) DelSandboxTool(ctx context.Context, req *mcp.CallToolRequest,
 
     klog.V(2).Infof("Delete sandbox tool by name=%s", sandbox.Name)
 
+    if _, err := a.lookup(ctx, sandbox.Name); err != nil {
+        return nil, nil, err
+    }
+
     err := a.controller.Delete(sandbox.Name)
     if err != nil {
         return nil, nil, fmt.Errorf("failed to delete Sandbox %s: %v", sandbox.Name, err)
@@ -157,3 +219,189 @@ func (a *Handler) DelSandboxTool(ctx context.Context, req *mcp.CallToolRequest,
         },
     }, nil, nil
 }
+
+func (a *Handler) PauseSandboxTool(ctx context.Context, req *mcp.CallToolRequest, sandbox *SandboxBase) (*mcp.CallToolResult, any, error) {
+    if sandbox.Name == "" {
+        return nil, nil, fmt.Errorf("sandbox name is required")
+    }
+
+    klog.V(2).Infof("Pause sandbox tool by name=%s", sandbox.Name)
+
+    if _, err := a.lookup(ctx, sandbox.Name); err != nil {
+        return nil, nil, err
+    }
+
+    if err := a.controller.Pause(sandbox.Name); err != nil {
+        return nil, nil, fmt.Errorf("failed to pause Sandbox %s: %v", sandbox.Name, err)
+    }
+
+    return &mcp.CallToolResult{
+        Content: []mcp.Content{
+            &mcp.TextContent{Text: fmt.Sprintf("Sandbox %s paused successfully", sandbox.Name)},
+        },
+    }, nil, nil
+}
+
+func (a *Handler) ResumeSandboxTool(ctx context.Context, req *mcp.CallToolRequest, sandbox *SandboxBase) (*mcp.CallToolResult, any, error) {
+    if sandbox.Name == "" {
+        return nil, nil, fmt.Errorf("sandbox name is required")
+    }
+
+    klog.V(2).Infof("Resume sandbox tool by name=%s", sandbox.Name)
+
+    if _, err := a.lookup(ctx, sandbox.Name); err != nil {
+        return nil, nil, err
+    }
+
+    if err := a.controller.Resume(sandbox.Name, config.Cfg.SandboxActivationTimeout); err != nil {
+        return nil, nil, fmt.Errorf("failed to resume Sandbox %s: %v", sandbox.Name, err)
+    }
+
+    return &mcp.CallToolResult{
+        Content: []mcp.Content{
+            &mcp.TextContent{Text: fmt.Sprintf("Sandbox %s resumed successfully", sandbox.Name)},
+        },
+    }, nil, nil
+}
+
+func (a *Handler) RestartSandboxTool(ctx context.Context, req *mcp.CallToolRequest, sandbox *SandboxBase) (*mcp.CallToolResult, any, error) {
+    if sandbox.Name == "" {
+        return nil, nil, fmt.Errorf("sandbox name is required")
+    }
+
+    klog.V(2).Infof("Restart sandbox tool by name=%s", sandbox.Name)
+
+    if _, err := a.lookup(ctx, sandbox.Name); err != nil {
+        return nil, nil, err
+    }
+
+    if err := a.controller.Restart(sandbox.Name, config.Cfg.SandboxActivationTimeout); err != nil {
+        return nil, nil, fmt.Errorf("failed to restart Sandbox %s: %v", sandbox.Name, err)
+    }
+
+    return &mcp.CallToolResult{
+        Content: []mcp.Content{
+            &mcp.TextContent{Text: fmt.Sprintf("Sandbox %s restarted successfully", sandbox.Name)},
+        },
+    }, nil, nil
+}
+
+type SnapshotToolInput struct {
+    Name     string `json:"name" jsonschema:"The name of the Sandbox to snapshot."`
+    Snapshot string `json:"snapshot,omitempty" jsonschema:"Optional name of the snapshot, generated when empty."`
+}
+
+func (a *Handler) SnapshotSandboxTool(ctx context.Context, req *mcp.CallToolRequest, input *SnapshotToolInput) (*mcp.CallToolResult, any, error) {
+    if input.Name == "" {
+        return nil, nil, fmt.Errorf("sandbox name is required")
+    }
+
+    klog.V(2).Infof("Snapshot sandbox tool by name=%s", input.Name)
+
+    if _, err := a.lookup(ctx, input.Name); err != nil {
+        return nil, nil, err
+    }
+
+    snap, err := a.controller.CreateSnapshot(input.Name, &SnapshotRequest{Name: input.Snapshot})
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to snapshot Sandbox %s: %v", input.Name, err)
+    }
+
+    return &mcp.CallToolResult{
+        Content: []mcp.Content{
+            &mcp.TextContent{Text: fmt.Sprintf("Snapshot %s of Sandbox %s created successfully", snap.Name, input.Name)},
+        },
+    }, nil, nil
+}
+
+type RestoreToolInput struct {
+    Snapshot string `json:"snapshot" jsonschema:"The name of the snapshot to restore."`
+    Name     string `json:"name,omitempty" jsonschema:"Optional name of the new Sandbox, generated when empty."`
+}
+
+func (a *Handler) RestoreSandboxTool(ctx context.Context, req *mcp.CallToolRequest, input *RestoreToolInput) (*mcp.CallToolResult, any, error) {
+    if input.Snapshot == "" {
+        return nil, nil, fmt.Errorf("snapshot is required")
+    }
+
+    klog.V(2).Infof("Restore sandbox tool from snapshot=%s", input.Snapshot)
+
+    snap, _, err := a.lookupSnapshot(ctx, input.Snapshot)
+    if err != nil {
+        return nil, nil, err
+    }
+    // the restored Sandbox runs the environment the snapshot was taken in
+    sb := &Sandbox{
+        SandboxBase:  SandboxBase{Name: input.Name, Environment: snap.Environment},
+        Image:        snap.Image,
+        FromSnapshot: snap.Name,
+        Tenant:       auth.TenantFromContext(ctx),
+    }
+    sb.Make()
+
+    op, err := a.submitCreate(sb, nil)
+    if err == nil {
+        err = op.Wait(ctx)
+    }
+    if err != nil {
+        klog.Errorf("Failed to restore sandbox, err: %v", err)
+        return nil, nil, fmt.Errorf("failed to restore Sandbox from snapshot %s, error: %v", input.Snapshot, err)
+    }
+
+    stools, err := a.sandboxTools(ctx, sb.Name)
+    if err != nil {
+        stools = fmt.Sprintf("failed to get Sandbox tools error: %s, please retry to get Sandbox Tools by call getSandbox Tool", err.Error())
+    }
+    stools = fmt.Sprintf("\nYou can use the following Tools to interact with the Sandbox:\n%s", stools)
+
+    return &mcp.CallToolResult{
+        Content: []mcp.Content{
+            &mcp.TextContent{Text: fmt.Sprintf("Sandbox restored from snapshot %s, Sandbox name:%s, %s", input.Snapshot, sb.Name, stools)},
+        },
+    }, nil, nil
+}
+
+type ForkToolInput struct {
+    Name string `json:"name" jsonschema:"The name of the Sandbox to fork."`
+    Fork string `json:"fork,omitempty" jsonschema:"Optional name of the new Sandbox, generated when empty."`
+}
+
+func (a *Handler) ForkSandboxTool(ctx context.Context, req *mcp.CallToolRequest, input *ForkToolInput) (*mcp.CallToolResult, any, error) {
+    if input.Name == "" {
+        return nil, nil, fmt.Errorf("sandbox name is required")
+    }
+
+    klog.V(2).Infof("Fork sandbox tool by name=%s", input.Name)
+
+    if _, err := a.lookup(ctx, input.Name); err != nil {
+        return nil, nil, err
+    }
+    if input.Fork != "" && a.controller.Get(input.Fork) != nil {
+        return nil, nil, fmt.Errorf("sandbox %s already exists", input.Fork)
+    }
+    sb, err := a.controller.Fork(input.Name, &ForkRequest{Name: input.Fork})
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to fork Sandbox %s: %v", input.Name, err)
+    }
+
+    op, err := a.submitCreate(sb, nil)
+    if err == nil {
+        err = op.Wait(ctx)
+    }
+    if err != nil {
+        klog.Errorf("Failed to fork sandbox, err: %v", err)
+        return nil, nil, fmt.Errorf("failed to fork Sandbox %s, error: %v", input.Name, err)
+    }
+
+    stools, err := a.sandboxTools(ctx, sb.Name)
+    if err != nil {
+        stools = fmt.Sprintf("failed to get Sandbox tools error: %s, please retry to get Sandbox Tools by call getSandbox Tool", err.Error())
+    }
+    stools = fmt.Sprintf("\nYou can use the following Tools to interact with the Sandbox:\n%s", stools)
+
+    return &mcp.CallToolResult{
+        Content: []mcp.Content{
+            &mcp.TextContent{Text: fmt.Sprintf("Sandbox %s forked, Sandbox name:%s, %s", input.Name, sb.Name, stools)},
+        },
+    }, nil, nil
+}
@@ -0,0 +1,316 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1core "k8s.io/api/core/v1"
+    v1net "k8s.io/api/networking/v1"
+    apiequality "k8s.io/apimachinery/pkg/api/equality"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    "k8s.io/apimachinery/pkg/api/resource"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/util/validation"
+    "k8s.io/klog/v2"
+    "knative.dev/pkg/injection/clients/dynamicclient"
+)
+
+const (
+    // TenantPolicyName is the name of the ResourceQuota, LimitRange and NetworkPolicy of a tenant namespace.
+    TenantPolicyName = "agent-sandbox"
+
+    // nameClaimPrefix prefixes the ConfigMaps in SandboxNamespace claiming the sandbox names over the tenant namespaces.
+    nameClaimPrefix = "sandbox-name-"
+    // nameClaimNamespaceKey is the ConfigMap key of the namespace holding the claimed name.
+    nameClaimNamespaceKey = "namespace"
+    // nameClaimGrace keeps a claim without its sandbox for the create in flight, it is taken over afterwards.
+    nameClaimGrace = time.Minute
+)
+
+// routerPodLabels are the pod labels of the agent-sandbox Deployment of install.yaml, the only ingress of the sandboxes.
+var routerPodLabels = map[string]string{"app": "agent-sandbox"}
+
+// ensuredNamespaces are the tenant namespaces created or found, they are set up once per process.
+var ensuredNamespaces sync.Map
+
+// ensureNamespace creates the namespace of the tenant on demand with config.Cfg.SandboxNamespacePerTenant, with a
+// ResourceQuota of the tenant quota, a LimitRange defaulting the container resources like DefaultSandbox and a
+// NetworkPolicy denying the ingress from everywhere but the agent-sandbox pods, i.e. the sandbox router. An existing
+// namespace is only used if it was created for the same tenant.
+func (s *Controller) ensureNamespace(tenant string) error {
+    namespace := config.TenantNamespace(tenant)
+    if namespace == config.Cfg.SandboxNamespace {
+        return nil
+    }
+    if _, ok := ensuredNamespaces.Load(namespace); ok {
+        return nil
+    }
+    if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+        return fmt.Errorf("invalid namespace %s of tenant %s: %s", namespace, tenant, strings.Join(errs, ", "))
+    }
+
+    meta := v1meta.ObjectMeta{
+        Name:      TenantPolicyName,
+        Namespace: namespace,
+        Labels: map[string]string{
+            "owner":          "agent-sandbox",
+            auth.TenantLabel: tenant,
+        },
+    }
+    ns := &v1core.Namespace{ObjectMeta: v1meta.ObjectMeta{Name: namespace, Labels: meta.Labels}}
+    _, err := s.client.CoreV1().Namespaces().Create(context.TODO(), ns, v1meta.CreateOptions{})
+    if apierrors.IsAlreadyExists(err) {
+        // the tenant comes from the caller, it must not take over a namespace of someone else
+        existing, gerr := s.client.CoreV1().Namespaces().Get(context.TODO(), namespace, v1meta.GetOptions{})
+        if gerr != nil {
+            return fmt.Errorf("get namespace %s fail: %v", namespace, gerr)
+        }
+        if existing.Labels["owner"] != "agent-sandbox" || existing.Labels[auth.TenantLabel] != tenant {
+            return fmt.Errorf("namespace %s of tenant %s exists and is not owned by agent-sandbox for this tenant", namespace, tenant)
+        }
+    }
+    if err = ignoreExists("namespace", namespace, err); err != nil {
+        return err
+    }
+
+    if err := s.ensureResourceQuota(meta, tenant); err != nil {
+        return err
+    }
+
+    requests, err := resourceList(DefaultSandbox.CPU, DefaultSandbox.Memory)
+    if err != nil {
+        return err
+    }
+    limits, err := resourceList(DefaultSandbox.CPULimit, DefaultSandbox.MemoryLimit)
+    if err != nil {
+        return err
+    }
+    limitRange := &v1core.LimitRange{ObjectMeta: meta, Spec: v1core.LimitRangeSpec{
+        Limits: []v1core.LimitRangeItem{{
+            Type:           v1core.LimitTypeContainer,
+            DefaultRequest: requests,
+            Default:        limits,
+        }},
+    }}
+    _, err = s.client.CoreV1().LimitRanges(namespace).Create(context.TODO(), limitRange, v1meta.CreateOptions{})
+    if err = ignoreExists("limit range", namespace, err); err != nil {
+        return err
+    }
+
+    // the egress is left open, the sandboxes browse and install packages
+    policy := &v1net.NetworkPolicy{ObjectMeta: meta, Spec: v1net.NetworkPolicySpec{
+        PodSelector: v1meta.LabelSelector{},
+        PolicyTypes: []v1net.PolicyType{v1net.PolicyTypeIngress},
+        Ingress: []v1net.NetworkPolicyIngressRule{{
+            From: []v1net.NetworkPolicyPeer{{
+                NamespaceSelector: &v1meta.LabelSelector{
+                    MatchLabels: map[string]string{v1core.LabelMetadataName: config.Cfg.SandboxNamespace},
+                },
+                // not the tenant-less sandboxes and warm pods of SandboxNamespace
+                PodSelector: &v1meta.LabelSelector{MatchLabels: routerPodLabels},
+            }},
+        }},
+    }}
+    _, err = s.client.NetworkingV1().NetworkPolicies(namespace).Create(context.TODO(), policy, v1meta.CreateOptions{})
+    if err = ignoreExists("network policy", namespace, err); err != nil {
+        return err
+    }
+
+    ensuredNamespaces.Store(namespace, true)
+    return nil
+}
+
+// ensureResourceQuota creates the ResourceQuota of the tenant namespace or updates it to the current tenant quota, it
+// is deleted when the tenant has no quota any more.
+func (s *Controller) ensureResourceQuota(meta v1meta.ObjectMeta, tenant string) error {
+    quotas := s.client.CoreV1().ResourceQuotas(meta.Namespace)
+    hard := tenantResourceQuota(tenant)
+    existing, err := quotas.Get(context.TODO(), meta.Name, v1meta.GetOptions{})
+    if apierrors.IsNotFound(err) {
+        if len(hard) == 0 {
+            return nil
+        }
+        quota := &v1core.ResourceQuota{ObjectMeta: meta, Spec: v1core.ResourceQuotaSpec{Hard: hard}}
+        _, err = quotas.Create(context.TODO(), quota, v1meta.CreateOptions{})
+        return ignoreExists("resource quota", meta.Namespace, err)
+    }
+    if err != nil {
+        return fmt.Errorf("get resource quota of namespace %s fail: %v", meta.Namespace, err)
+    }
+
+    if len(hard) == 0 {
+        if err := quotas.Delete(context.TODO(), meta.Name, v1meta.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+            return fmt.Errorf("delete resource quota of namespace %s fail: %v", meta.Namespace, err)
+        }
+        klog.Infof("Deleted resource quota of tenant namespace %s", meta.Namespace)
+        return nil
+    }
+    if apiequality.Semantic.DeepEqual(existing.Spec.Hard, hard) {
+        return nil
+    }
+    existing.Spec.Hard = hard
+    if _, err := quotas.Update(context.TODO(), existing, v1meta.UpdateOptions{}); err != nil {
+        return fmt.Errorf("update resource quota of namespace %s fail: %v", meta.Namespace, err)
+    }
+    klog.Infof("Updated resource quota of tenant namespace %s", meta.Namespace)
+    return nil
+}
+
+// claimName claims the name of the sandbox over all tenant namespaces with SandboxNamespacePerTenant, the ReplicaSet
+// names are only unique within a namespace. Creating the claim ConfigMap in SandboxNamespace is atomic, a claim of the
+// same namespace is the sandbox's own, e.g. of a Sandbox resource reconciled again.
+func (s *Controller) claimName(sb *Sandbox) error {
+    if !config.Cfg.SandboxNamespacePerTenant {
+        return nil
+    }
+    namespace := config.TenantNamespace(sb.Tenant)
+    claims := s.client.CoreV1().ConfigMaps(config.Cfg.SandboxNamespace)
+    claim := &v1core.ConfigMap{
+        ObjectMeta: v1meta.ObjectMeta{
+            Name: nameClaimPrefix + sb.Name,
+            Labels: map[string]string{
+                "owner":          "agent-sandbox",
+                auth.TenantLabel: sb.Tenant,
+            },
+        },
+        Data: map[string]string{nameClaimNamespaceKey: namespace},
+    }
+
+    // at most one take over of a stale claim
+    for attempt := 0; attempt < 2; attempt++ {
+        _, err := claims.Create(context.TODO(), claim, v1meta.CreateOptions{})
+        if err == nil {
+            return nil
+        }
+        if !apierrors.IsAlreadyExists(err) {
+            return fmt.Errorf("claim name of sandbox %s fail: %v", sb.Name, err)
+        }
+
+        existing, err := claims.Get(context.TODO(), claim.Name, v1meta.GetOptions{})
+        if apierrors.IsNotFound(err) {
+            // released meanwhile
+            continue
+        }
+        if err != nil {
+            return fmt.Errorf("get name claim of sandbox %s fail: %v", sb.Name, err)
+        }
+        holder := existing.Data[nameClaimNamespaceKey]
+        if holder == namespace {
+            return nil
+        }
+        if time.Since(existing.CreationTimestamp.Time) < nameClaimGrace || s.nameInUse(holder, sb.Name) {
+            return fmt.Errorf("sandbox %s already exists", sb.Name)
+        }
+
+        // left by a sandbox deleted out of band, the uid guards against a concurrent take over
+        klog.Infof("Taking over the stale name claim of sandbox %s from namespace %s", sb.Name, holder)
+        uid := existing.UID
+        err = claims.Delete(context.TODO(), claim.Name, v1meta.DeleteOptions{Preconditions: &v1meta.Preconditions{UID: &uid}})
+        if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+            return fmt.Errorf("delete stale name claim of sandbox %s fail: %v", sb.Name, err)
+        }
+    }
+    return fmt.Errorf("sandbox %s already exists", sb.Name)
+}
+
+// nameInUse reports whether the sandbox exists in the namespace, an error counts as in use.
+func (s *Controller) nameInUse(namespace string, name string) bool {
+    _, err := s.client.AppsV1().ReplicaSets(namespace).Get(context.TODO(), name, v1meta.GetOptions{})
+    if !apierrors.IsNotFound(err) {
+        return true
+    }
+    if config.Cfg.SandboxCRDEnabled {
+        _, err = dynamicclient.Get(s.rootCtx).Resource(SandboxGVR).Namespace(namespace).Get(context.TODO(), name, v1meta.GetOptions{})
+        return !apierrors.IsNotFound(err)
+    }
+    return false
+}
+
+// releaseName deletes the claim of the sandbox name if the namespace holds it.
+func (s *Controller) releaseName(name string, namespace string) {
+    if !config.Cfg.SandboxNamespacePerTenant {
+        return
+    }
+    claims := s.client.CoreV1().ConfigMaps(config.Cfg.SandboxNamespace)
+    existing, err := claims.Get(context.TODO(), nameClaimPrefix+name, v1meta.GetOptions{})
+    if err != nil {
+        if !apierrors.IsNotFound(err) {
+            klog.Errorf("Failed to get the name claim of sandbox %s: %v", name, err)
+        }
+        return
+    }
+    if existing.Data[nameClaimNamespaceKey] != namespace {
+        return
+    }
+    uid := existing.UID
+    err = claims.Delete(context.TODO(), existing.Name, v1meta.DeleteOptions{Preconditions: &v1meta.Preconditions{UID: &uid}})
+    if err != nil && !apierrors.IsNotFound(err) {
+        klog.Errorf("Failed to release the name claim of sandbox %s: %v", name, err)
+    }
+}
+
+// releaseUnusedName releases the name claim of a sandbox which failed to be created, not the claim of an existing
+// sandbox of the same name in the namespace.
+func (s *Controller) releaseUnusedName(name string, namespace string) {
+    if config.Cfg.SandboxNamespacePerTenant && !s.nameInUse(namespace, name) {
+        s.releaseName(name, namespace)
+    }
+}
+
+// tenantResourceQuota returns the hard limits of the quota of the tenant, empty without quota.
+func tenantResourceQuota(tenant string) v1core.ResourceList {
+    hard := v1core.ResourceList{}
+    if quotaPolicy == nil {
+        return hard
+    }
+    q := quotaPolicy.tenantQuota(tenant)
+    if q == nil {
+        return hard
+    }
+    if q.MaxSandboxes > 0 {
+        hard[v1core.ResourcePods] = *resource.NewQuantity(int64(q.MaxSandboxes), resource.DecimalSI)
+    }
+    for name, v := range map[v1core.ResourceName]*resource.Quantity{
+        v1core.ResourceRequestsCPU:    q.CPU,
+        v1core.ResourceRequestsMemory: q.Memory,
+        v1core.ResourceLimitsCPU:      q.CPULimit,
+        v1core.ResourceLimitsMemory:   q.MemoryLimit,
+    } {
+        if v != nil {
+            hard[name] = *v
+        }
+    }
+    return hard
+}
+
+func ignoreExists(kind string, namespace string, err error) error {
+    if err == nil {
+        klog.Infof("Created %s of tenant namespace %s", kind, namespace)
+        return nil
+    }
+    if apierrors.IsAlreadyExists(err) {
+        return nil
+    }
+    return fmt.Errorf("create %s of namespace %s fail: %v", kind, namespace, err)
+}
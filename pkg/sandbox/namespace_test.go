@@ -0,0 +1,297 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1 "k8s.io/api/apps/v1"
+    v1core "k8s.io/api/core/v1"
+    apiequality "k8s.io/apimachinery/pkg/api/equality"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+    dynamicfake "k8s.io/client-go/dynamic/fake"
+    coreinformers "k8s.io/client-go/informers/core/v1"
+    kubefake "k8s.io/client-go/kubernetes/fake"
+    corelisters "k8s.io/client-go/listers/core/v1"
+    "k8s.io/client-go/tools/cache"
+    kubeclient "knative.dev/pkg/client/injection/kube/client"
+    rsfiltered "knative.dev/pkg/client/injection/kube/informers/apps/v1/replicaset/filtered"
+    podfiltered "knative.dev/pkg/client/injection/kube/informers/core/v1/pod/filtered"
+    "knative.dev/pkg/injection/clients/dynamicclient"
+)
+
+// testPodInformer serves the lister of the indexer, the informer is never run.
+type testPodInformer struct {
+    indexer cache.Indexer
+}
+
+func (i *testPodInformer) Informer() cache.SharedIndexInformer {
+    return nil
+}
+
+func (i *testPodInformer) Lister() corelisters.PodLister {
+    return corelisters.NewPodLister(i.indexer)
+}
+
+var _ coreinformers.PodInformer = &testPodInformer{}
+
+// newTestController returns a controller on fake clients holding the objects, the informer cache holds the
+// ReplicaSets and pods among them.
+func newTestController(t *testing.T, objects ...runtime.Object) (*Controller, *kubefake.Clientset) {
+    indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+    pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+    for _, obj := range objects {
+        var err error
+        switch o := obj.(type) {
+        case *v1.ReplicaSet:
+            err = indexer.Add(o)
+        case *v1core.Pod:
+            err = pods.Add(o)
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    kube := kubefake.NewSimpleClientset(objects...)
+    dynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+        map[schema.GroupVersionResource]string{SandboxGVR: SandboxKind + "List", VolumeSnapshotGVR: "VolumeSnapshotList"})
+
+    ctx := context.WithValue(context.Background(), kubeclient.Key{}, kube)
+    ctx = context.WithValue(ctx, dynamicclient.Key{}, dynamic)
+    ctx = context.WithValue(ctx, rsfiltered.Key{Selector: activator.SandboxSelector}, &testReplicaSetInformer{indexer: indexer})
+    ctx = context.WithValue(ctx, podfiltered.Key{Selector: activator.SandboxSelector}, &testPodInformer{indexer: pods})
+    return &Controller{rootCtx: ctx, client: kube}, kube
+}
+
+// withNamespacePerTenant enables SandboxNamespacePerTenant for the test and forgets the namespaces set up by it.
+func withNamespacePerTenant(t *testing.T) {
+    saved := *config.Cfg
+    config.Cfg.SandboxNamespace = "agent-sandbox"
+    config.Cfg.SandboxNamespacePerTenant = true
+    config.Cfg.SandboxTenantNamespacePrefix = "sandbox-"
+    t.Cleanup(func() {
+        *config.Cfg = saved
+        ensuredNamespaces.Range(func(key, _ interface{}) bool {
+            ensuredNamespaces.Delete(key)
+            return true
+        })
+    })
+}
+
+func TestEnsureNamespace(t *testing.T) {
+    withNamespacePerTenant(t)
+    saved := quotaPolicy
+    quotaPolicy = &QuotaPolicy{Tenants: map[string]*Quota{"team-a": {MaxSandboxes: 3, CPU: quantity("2")}}}
+    t.Cleanup(func() { quotaPolicy = saved })
+
+    s, kube := newTestController(t)
+    if err := s.ensureNamespace("team-a"); err != nil {
+        t.Fatal(err)
+    }
+    ctx := context.TODO()
+
+    ns, err := kube.CoreV1().Namespaces().Get(ctx, "sandbox-team-a", v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if ns.Labels["owner"] != "agent-sandbox" || ns.Labels[auth.TenantLabel] != "team-a" {
+        t.Errorf("unexpected namespace labels %v", ns.Labels)
+    }
+
+    quota, err := kube.CoreV1().ResourceQuotas("sandbox-team-a").Get(ctx, TenantPolicyName, v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := v1core.ResourceList{v1core.ResourcePods: *quantity("3"), v1core.ResourceRequestsCPU: *quantity("2")}
+    if !apiequality.Semantic.DeepEqual(quota.Spec.Hard, expected) {
+        t.Errorf("expected resource quota %v, got %v", expected, quota.Spec.Hard)
+    }
+
+    limits, err := kube.CoreV1().LimitRanges("sandbox-team-a").Get(ctx, TenantPolicyName, v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if item := limits.Spec.Limits[0]; item.Type != v1core.LimitTypeContainer ||
+        item.DefaultRequest.Cpu().Cmp(*quantity(DefaultSandbox.CPU)) != 0 || item.Default.Memory().Cmp(*quantity(DefaultSandbox.MemoryLimit)) != 0 {
+        t.Errorf("unexpected limit range %+v", limits.Spec.Limits)
+    }
+
+    policy, err := kube.NetworkingV1().NetworkPolicies("sandbox-team-a").Get(ctx, TenantPolicyName, v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    from := policy.Spec.Ingress[0].From[0]
+    if from.NamespaceSelector.MatchLabels[v1core.LabelMetadataName] != "agent-sandbox" || from.PodSelector.MatchLabels["app"] != "agent-sandbox" {
+        t.Errorf("expected the ingress from the router pods only, got %+v", policy.Spec.Ingress)
+    }
+
+    // the tenant-less sandboxes stay in SandboxNamespace
+    if err := s.ensureNamespace(""); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := kube.CoreV1().Namespaces().Get(ctx, "agent-sandbox", v1meta.GetOptions{}); !apierrors.IsNotFound(err) {
+        t.Errorf("expected SandboxNamespace to be left alone, got %v", err)
+    }
+}
+
+func TestEnsureNamespaceOfAnotherTenant(t *testing.T) {
+    withNamespacePerTenant(t)
+    foreign := &v1core.Namespace{ObjectMeta: v1meta.ObjectMeta{
+        Name:   "sandbox-team-a",
+        Labels: map[string]string{"owner": "agent-sandbox", auth.TenantLabel: "team-b"},
+    }}
+    s, _ := newTestController(t, foreign)
+
+    if err := s.ensureNamespace("team-a"); err == nil || !strings.Contains(err.Error(), "not owned") {
+        t.Fatalf("expected the namespace of another tenant to be refused, got %v", err)
+    }
+}
+
+func TestEnsureNamespaceUpdatesResourceQuota(t *testing.T) {
+    withNamespacePerTenant(t)
+    saved := quotaPolicy
+    t.Cleanup(func() { quotaPolicy = saved })
+
+    meta := v1meta.ObjectMeta{Name: TenantPolicyName, Namespace: "sandbox-team-a"}
+    ns := &v1core.Namespace{ObjectMeta: v1meta.ObjectMeta{
+        Name:   "sandbox-team-a",
+        Labels: map[string]string{"owner": "agent-sandbox", auth.TenantLabel: "team-a"},
+    }}
+    old := &v1core.ResourceQuota{ObjectMeta: meta, Spec: v1core.ResourceQuotaSpec{Hard: v1core.ResourceList{v1core.ResourcePods: *quantity("3")}}}
+
+    // a changed quota is updated
+    quotaPolicy = &QuotaPolicy{Default: &Quota{MaxSandboxes: 5}}
+    s, kube := newTestController(t, ns, old)
+    if err := s.ensureNamespace("team-a"); err != nil {
+        t.Fatal(err)
+    }
+    quota, err := kube.CoreV1().ResourceQuotas("sandbox-team-a").Get(context.TODO(), TenantPolicyName, v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if pods := quota.Spec.Hard[v1core.ResourcePods]; pods.Cmp(*quantity("5")) != 0 || len(quota.Spec.Hard) != 1 {
+        t.Errorf("expected the resource quota to be updated to 5 pods, got %v", quota.Spec.Hard)
+    }
+
+    // a removed quota is deleted
+    quotaPolicy = nil
+    s, kube = newTestController(t, ns, old)
+    if err := s.ensureResourceQuota(meta, "team-a"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := kube.CoreV1().ResourceQuotas("sandbox-team-a").Get(context.TODO(), TenantPolicyName, v1meta.GetOptions{}); !apierrors.IsNotFound(err) {
+        t.Errorf("expected the resource quota to be deleted, got %v", err)
+    }
+}
+
+func TestEnsureNamespaceOfUnsafeTenant(t *testing.T) {
+    withNamespacePerTenant(t)
+    s, kube := newTestController(t)
+
+    // e.g. a tenant of an API key which is a label value but no namespace name
+    if err := s.ensureNamespace("Team_A"); err != nil {
+        t.Fatal(err)
+    }
+    ns, err := kube.CoreV1().Namespaces().Get(context.TODO(), config.TenantNamespace("Team_A"), v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.HasPrefix(ns.Name, "sandbox-team-a-") || ns.Labels[auth.TenantLabel] != "Team_A" {
+        t.Errorf("unexpected namespace %s of tenant %s", ns.Name, ns.Labels[auth.TenantLabel])
+    }
+}
+
+func TestClaimName(t *testing.T) {
+    withNamespacePerTenant(t)
+    named := func(name string, tenant string) *Sandbox {
+        sb := &Sandbox{Tenant: tenant}
+        sb.Name = name
+        return sb
+    }
+    claimOf := func(name string, namespace string, age time.Duration) *v1core.ConfigMap {
+        return &v1core.ConfigMap{
+            ObjectMeta: v1meta.ObjectMeta{
+                Name:              nameClaimPrefix + name,
+                Namespace:         "agent-sandbox",
+                UID:               types.UID("claim-" + name),
+                CreationTimestamp: v1meta.NewTime(time.Now().Add(-age)),
+            },
+            Data: map[string]string{nameClaimNamespaceKey: namespace},
+        }
+    }
+    running := &v1.ReplicaSet{ObjectMeta: v1meta.ObjectMeta{Name: "taken", Namespace: "sandbox-team-a"}}
+    s, kube := newTestController(t,
+        running,
+        claimOf("taken", "sandbox-team-a", time.Hour),
+        claimOf("in-flight", "sandbox-team-a", time.Second),
+        claimOf("stale", "sandbox-team-a", time.Hour),
+    )
+
+    tests := []struct {
+        name    string
+        sandbox *Sandbox
+        taken   bool
+    }{
+        {"new name", named("new", "team-a"), false},
+        {"own claim", named("taken", "team-a"), false},
+        {"name of another tenant", named("taken", "team-b"), true},
+        {"name of a create in flight", named("in-flight", "team-b"), true},
+        {"stale claim", named("stale", "team-b"), false},
+        {"tenant-less sandbox", named("taken", ""), true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := s.claimName(tt.sandbox)
+            if tt.taken {
+                if err == nil || !strings.Contains(err.Error(), "already exists") {
+                    t.Fatalf("expected the name to be taken, got %v", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            claim, err := kube.CoreV1().ConfigMaps("agent-sandbox").Get(context.TODO(), nameClaimPrefix+tt.sandbox.Name, v1meta.GetOptions{})
+            if err != nil {
+                t.Fatal(err)
+            }
+            if namespace := config.TenantNamespace(tt.sandbox.Tenant); claim.Data[nameClaimNamespaceKey] != namespace {
+                t.Fatalf("expected the name to be claimed by %s, got %v", namespace, claim.Data)
+            }
+        })
+    }
+
+    // only the holder releases the claim
+    s.releaseName("taken", "sandbox-team-b")
+    if _, err := kube.CoreV1().ConfigMaps("agent-sandbox").Get(context.TODO(), nameClaimPrefix+"taken", v1meta.GetOptions{}); err != nil {
+        t.Fatalf("expected the claim to be kept, got %v", err)
+    }
+    s.releaseName("taken", "sandbox-team-a")
+    if _, err := kube.CoreV1().ConfigMaps("agent-sandbox").Get(context.TODO(), nameClaimPrefix+"taken", v1meta.GetOptions{}); !apierrors.IsNotFound(err) {
+        t.Fatalf("expected the claim to be released, got %v", err)
+    }
+}
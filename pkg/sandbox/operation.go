@@ -0,0 +1,280 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    "k8s.io/apimachinery/pkg/util/wait"
+    "k8s.io/klog/v2"
+)
+
+const (
+    OperationTypeCreate = "create"
+)
+
+const (
+    OperationPending   = "pending"
+    OperationRunning   = "running"
+    OperationSucceeded = "succeeded"
+    OperationFailed    = "failed"
+)
+
+// Operation tracks a long-running sandbox action executed by the worker pool.
+type Operation struct {
+    ID        string `json:"id"`
+    Type      string `json:"type"`
+    Sandbox   string `json:"sandbox"`
+    Status    string `json:"status"`
+    Error     string `json:"error,omitempty"`
+    CreatedAt string `json:"created_at"`
+    UpdatedAt string `json:"updated_at"`
+
+    mu          sync.Mutex
+    run         func() error
+    done        chan struct{}
+    finishedAt  time.Time
+    idempotency *Idempotency
+    // tenant of the sandbox, the operation is not found by the other tenants
+    tenant string
+}
+
+// Done reports whether the operation succeeded or failed.
+func (o *Operation) Done() bool {
+    select {
+    case <-o.done:
+        return true
+    default:
+        return false
+    }
+}
+
+// Wait blocks until the operation is done or ctx is done, it returns the error of the operation.
+func (o *Operation) Wait(ctx context.Context) error {
+    select {
+    case <-o.done:
+        o.mu.Lock()
+        defer o.mu.Unlock()
+        if o.Status == OperationFailed {
+            return fmt.Errorf("%s", o.Error)
+        }
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// MarshalJSON takes a consistent copy, the operation is updated by the workers while it is served.
+func (o *Operation) MarshalJSON() ([]byte, error) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    type operation Operation
+    return json.Marshal(&struct {
+        *operation
+    }{(*operation)(o)})
+}
+
+func (o *Operation) setStatus(status string, err error) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    o.Status = status
+    if err != nil {
+        o.Error = err.Error()
+    }
+    o.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+}
+
+// acceptedOperation is served with 202 Accepted, the operation goes on in background.
+type acceptedOperation struct {
+    *Operation
+}
+
+func (a *acceptedOperation) HTTPStatus() int {
+    return http.StatusAccepted
+}
+
+// OperationManager runs operations on a bounded pool of workers and keeps them for polling until they expire.
+type OperationManager struct {
+    rootCtx    context.Context
+    operations sync.Map
+    queue      chan *Operation
+
+    // operations by idempotency key, a retry gets the operation of the first request
+    keysMu sync.Mutex
+    keys   map[string]*Operation
+}
+
+func NewOperationManager(ctx context.Context) *OperationManager {
+    m := &OperationManager{
+        rootCtx: ctx,
+        queue:   make(chan *Operation, config.Cfg.SandboxOperationQueueSize),
+        keys:    make(map[string]*Operation),
+    }
+    for i := 0; i < config.Cfg.SandboxOperationWorkers; i++ {
+        go m.work()
+    }
+    go wait.Until(m.expire, time.Minute, ctx.Done())
+    return m
+}
+
+func newOperation(opType string, sb *Sandbox, run func() error) *Operation {
+    now := time.Now().UTC().Format(time.RFC3339)
+    return &Operation{
+        ID:        newOperationID(),
+        Type:      opType,
+        Sandbox:   sb.Name,
+        tenant:    sb.Tenant,
+        Status:    OperationPending,
+        CreatedAt: now,
+        UpdatedAt: now,
+        run:       run,
+        done:      make(chan struct{}),
+    }
+}
+
+// Submit queues run as a new operation on the sandbox, it fails right away when the queue is full.
+func (m *OperationManager) Submit(opType string, sb *Sandbox, run func() error) (*Operation, error) {
+    return m.SubmitIdempotent(opType, sb, nil, run)
+}
+
+// SubmitIdempotent is Submit returning the pending or succeeded operation submitted with the same idempotency key,
+// a failed one is retried. idem may be nil.
+func (m *OperationManager) SubmitIdempotent(opType string, sb *Sandbox, idem *Idempotency, run func() error) (*Operation, error) {
+    if idem != nil {
+        m.keysMu.Lock()
+        defer m.keysMu.Unlock()
+        if op, err := m.findByKeyLocked(idem); op != nil || err != nil {
+            return op, err
+        }
+    }
+
+    op := newOperation(opType, sb, run)
+    op.idempotency = idem
+    select {
+    case m.queue <- op:
+    default:
+        return nil, fmt.Errorf("too many pending operations, max %d, please retry later", cap(m.queue))
+    }
+    m.operations.Store(op.ID, op)
+    if idem != nil {
+        m.keys[idem.Key] = op
+    }
+    klog.V(2).Infof("Operation %s %s sandbox %s queued", op.ID, opType, sb.Name)
+    return op, nil
+}
+
+// FindByKey returns the pending or succeeded operation submitted with the idempotency key, nil if there is none.
+func (m *OperationManager) FindByKey(idem *Idempotency) (*Operation, error) {
+    m.keysMu.Lock()
+    defer m.keysMu.Unlock()
+    return m.findByKeyLocked(idem)
+}
+
+func (m *OperationManager) findByKeyLocked(idem *Idempotency) (*Operation, error) {
+    op, ok := m.keys[idem.Key]
+    if !ok {
+        return nil, nil
+    }
+    if err := idem.check(op.idempotency); err != nil {
+        return nil, err
+    }
+    op.mu.Lock()
+    failed := op.Status == OperationFailed
+    op.mu.Unlock()
+    if failed {
+        return nil, nil
+    }
+    return op, nil
+}
+
+// Succeeded registers an operation which is already done, e.g. the create of a sandbox which exists.
+func (m *OperationManager) Succeeded(opType string, sb *Sandbox) *Operation {
+    op := newOperation(opType, sb, nil)
+    op.setStatus(OperationSucceeded, nil)
+    op.finishedAt = time.Now()
+    close(op.done)
+    m.operations.Store(op.ID, op)
+    return op
+}
+
+// Get returns the operation by id, nil if there is none or it is an operation of another tenant.
+func (m *OperationManager) Get(id string, tenant string) *Operation {
+    if val, ok := m.operations.Load(id); ok && auth.Allowed(tenant, val.(*Operation).tenant) {
+        return val.(*Operation)
+    }
+    return nil
+}
+
+func (m *OperationManager) work() {
+    for {
+        select {
+        case op := <-m.queue:
+            op.setStatus(OperationRunning, nil)
+            err := op.run()
+            if err != nil {
+                op.setStatus(OperationFailed, err)
+            } else {
+                op.setStatus(OperationSucceeded, nil)
+            }
+            op.mu.Lock()
+            op.finishedAt = time.Now()
+            op.mu.Unlock()
+            close(op.done)
+            klog.V(2).Infof("Operation %s %s sandbox %s finished, err: %v", op.ID, op.Type, op.Sandbox, err)
+        case <-m.rootCtx.Done():
+            return
+        }
+    }
+}
+
+// expire drops the operations finished longer than config.Cfg.SandboxOperationTTL ago.
+func (m *OperationManager) expire() {
+    m.operations.Range(func(key, value any) bool {
+        op := value.(*Operation)
+        op.mu.Lock()
+        finishedAt := op.finishedAt
+        op.mu.Unlock()
+        if !finishedAt.IsZero() && time.Since(finishedAt) > config.Cfg.SandboxOperationTTL {
+            m.operations.Delete(key)
+            if op.idempotency != nil {
+                m.keysMu.Lock()
+                if m.keys[op.idempotency.Key] == op {
+                    delete(m.keys, op.idempotency.Key)
+                }
+                m.keysMu.Unlock()
+            }
+        }
+        return true
+    })
+}
+
+func newOperationID() string {
+    b := make([]byte, 8)
+    if _, err := rand.Read(b); err != nil {
+        return fmt.Sprintf("op-%d", time.Now().UnixNano())
+    }
+    return "op-" + hex.EncodeToString(b)
+}
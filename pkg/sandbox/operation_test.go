@@ -0,0 +1,112 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+)
+
+// withOperations sets the workers and queue size of the operation managers created by the test.
+func withOperations(t *testing.T, workers int, queueSize int) {
+    saved := *config.Cfg
+    config.Cfg.SandboxOperationWorkers = workers
+    config.Cfg.SandboxOperationQueueSize = queueSize
+    t.Cleanup(func() { *config.Cfg = saved })
+}
+
+func newTestOperationManager(t *testing.T) *OperationManager {
+    ctx, cancel := context.WithCancel(context.Background())
+    t.Cleanup(cancel)
+    return NewOperationManager(ctx)
+}
+
+func testTenantSandbox(name string, tenant string) *Sandbox {
+    sb := &Sandbox{Tenant: tenant}
+    sb.Name = name
+    return sb
+}
+
+func TestOperationQueueFull(t *testing.T) {
+    // no workers, the queue is never drained
+    withOperations(t, 0, 1)
+    m := newTestOperationManager(t)
+    run := func() error { return nil }
+
+    if _, err := m.Submit(OperationTypeCreate, testTenantSandbox("first", ""), run); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := m.Submit(OperationTypeCreate, testTenantSandbox("second", ""), run); err == nil {
+        t.Fatal("expected the submit to a full queue to fail")
+    }
+}
+
+func TestOperationRun(t *testing.T) {
+    withOperations(t, 1, 10)
+    m := newTestOperationManager(t)
+
+    ok, err := m.Submit(OperationTypeCreate, testTenantSandbox("ok", "team-a"), func() error { return nil })
+    if err != nil {
+        t.Fatal(err)
+    }
+    failed, err := m.Submit(OperationTypeCreate, testTenantSandbox("failed", "team-a"), func() error { return fmt.Errorf("quota exceeded") })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := ok.Wait(ctx); err != nil || ok.Status != OperationSucceeded {
+        t.Fatalf("expected the operation to succeed, got %s: %v", ok.Status, err)
+    }
+    if err := failed.Wait(ctx); err == nil || failed.Status != OperationFailed || failed.Error != "quota exceeded" {
+        t.Fatalf("expected the operation to fail, got %s: %v", failed.Status, err)
+    }
+
+    // the operations are found by their tenant only
+    if m.Get(ok.ID, "team-a") != ok {
+        t.Fatal("expected the operation to be found by its tenant")
+    }
+    if m.Get(ok.ID, "team-b") != nil {
+        t.Fatal("expected the operation not to be found by another tenant")
+    }
+}
+
+func TestOperationExpire(t *testing.T) {
+    withOperations(t, 0, 10)
+    config.Cfg.SandboxOperationTTL = time.Minute
+    m := newTestOperationManager(t)
+
+    old := m.Succeeded(OperationTypeCreate, testTenantSandbox("old", ""))
+    old.finishedAt = time.Now().Add(-2 * time.Minute)
+    recent := m.Succeeded(OperationTypeCreate, testTenantSandbox("recent", ""))
+    pending, err := m.Submit(OperationTypeCreate, testTenantSandbox("pending", ""), func() error { return nil })
+    if err != nil {
+        t.Fatal(err)
+    }
+    m.expire()
+
+    for op, expected := range map[*Operation]bool{old: false, recent: true, pending: true} {
+        if got := m.Get(op.ID, "") != nil; got != expected {
+            t.Errorf("expected operation of %s to be kept %t, got %t", op.Sandbox, expected, got)
+        }
+    }
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "encoding/json"
+    "expvar"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1core "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/labels"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/klog/v2"
+)
+
+const (
+    // PoolLabel marks an unassigned warm pod with its environment name.
+    PoolLabel = "sandbox-pool"
+
+    // PoolOwner is the owner label of the warm pods, they are not sandboxes until claimed.
+    PoolOwner = "agent-sandbox-pool"
+)
+
+// pool hits and misses by environment, served at /debug/vars
+var (
+    poolHits   = expvar.NewMap("sandbox_warm_pool_hits")
+    poolMisses = expvar.NewMap("sandbox_warm_pool_misses")
+)
+
+// WarmPool keeps config.Environment.WarmPoolSize ready pods per environment. A create claims a pod by relabeling it
+// to the sandbox, the sandbox ReplicaSet then adopts it instead of starting a new pod.
+type WarmPool struct {
+    rootCtx context.Context
+    client  kubernetes.Interface
+    refill  chan struct{}
+}
+
+func NewWarmPool(ctx context.Context, client kubernetes.Interface) *WarmPool {
+    return &WarmPool{
+        rootCtx: ctx,
+        client:  client,
+        refill:  make(chan struct{}, 1),
+    }
+}
+
+// WarmPoolEnabled reports whether any environment has a warm pool.
+func WarmPoolEnabled() bool {
+    for _, env := range *config.Environments {
+        if env.WarmPoolSize > 0 {
+            return true
+        }
+    }
+    return false
+}
+
+// Run refills the pools periodically and after every claim until rootCtx is done.
+func (p *WarmPool) Run() {
+    klog.Info("Starting sandbox warm pool")
+    ticker := time.NewTicker(30 * time.Second)
+    defer ticker.Stop()
+    for {
+        p.fill()
+        select {
+        case <-ticker.C:
+        case <-p.refill:
+        case <-p.rootCtx.Done():
+            return
+        }
+    }
+}
+
+// Claim relabels a ready warm pod to the sandbox and returns its name, empty on a miss.
+// Only sandboxes which would run the same pod as the pool are served by it.
+func (p *WarmPool) Claim(sb *Sandbox) string {
+    env := poolEnvironment(sb)
+    if env == nil {
+        return ""
+    }
+    defer p.triggerRefill()
+
+    for _, pod := range p.pods(env.Name) {
+        if !activator.IsPodReady(pod) {
+            continue
+        }
+        _, err := p.client.CoreV1().Pods(pod.Namespace).Patch(context.TODO(), pod.Name, types.JSONPatchType, claimPatch(env.Name, sb), v1meta.PatchOptions{})
+        if err != nil {
+            klog.V(2).Infof("Failed to claim warm pod %s for sandbox %s: %v", pod.Name, sb.Name, err)
+            continue
+        }
+        klog.Infof("Sandbox %s claimed warm pod %s of environment %s", sb.Name, pod.Name, env.Name)
+        poolHits.Add(env.Name, 1)
+        return pod.Name
+    }
+
+    klog.V(2).Infof("Warm pool of environment %s is empty, sandbox %s cold starts", env.Name, sb.Name)
+    poolMisses.Add(env.Name, 1)
+    return ""
+}
+
+// claimPatch relabels a warm pod to the sandbox, the test op makes concurrent claims of the same pod fail but one.
+func claimPatch(envName string, sb *Sandbox) []byte {
+    ops := []map[string]interface{}{
+        {"op": "test", "path": labelPath(PoolLabel), "value": envName},
+        {"op": "remove", "path": labelPath(PoolLabel)},
+        {"op": "add", "path": labelPath("sandbox"), "value": sb.Name},
+        {"op": "replace", "path": labelPath("owner"), "value": "agent-sandbox"},
+    }
+    if sb.Tenant != "" {
+        ops = append(ops, map[string]interface{}{"op": "add", "path": labelPath(auth.TenantLabel), "value": sb.Tenant})
+    }
+    for k, v := range sb.Labels {
+        ops = append(ops, map[string]interface{}{"op": "add", "path": labelPath(k), "value": v})
+    }
+    patch, _ := json.Marshal(ops)
+    return patch
+}
+
+// labelPath escapes the label key as JSON pointer, e.g. example.com/team
+func labelPath(key string) string {
+    return "/metadata/labels/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// Release deletes a claimed pod when the sandbox creation failed, it is not put back to the pool.
+func (p *WarmPool) Release(podName string) {
+    err := p.client.CoreV1().Pods(config.Cfg.SandboxNamespace).Delete(context.TODO(), podName, v1meta.DeleteOptions{})
+    if err != nil && !apierrors.IsNotFound(err) {
+        klog.Errorf("Failed to delete claimed warm pod %s: %v", podName, err)
+    }
+}
+
+func (p *WarmPool) triggerRefill() {
+    select {
+    case p.refill <- struct{}{}:
+    default:
+    }
+}
+
+// poolEnvironment returns the environment whose pool can serve the sandbox, nil if the sandbox differs from the pool pods.
+func poolEnvironment(sb *Sandbox) *config.Environment {
+    if sb.Environment == "" || len(sb.Args) > 0 || len(sb.Env) > 0 || len(sb.Ports) > 0 || sb.Workdir != "" ||
+        len(sb.Volumes) > 0 || sb.FromSnapshot != "" {
+        return nil
+    }
+    if sb.CPU != DefaultSandbox.CPU || sb.Memory != DefaultSandbox.Memory ||
+        sb.CPULimit != DefaultSandbox.CPULimit || sb.MemoryLimit != DefaultSandbox.MemoryLimit {
+        return nil
+    }
+    for _, env := range *config.Environments {
+        if env.Name == sb.Environment && env.WarmPoolSize > 0 && env.Image == sb.Image {
+            return env
+        }
+    }
+    return nil
+}
+
+func (p *WarmPool) pods(envName string) []*v1core.Pod {
+    selector, _ := labels.Parse(fmt.Sprintf("%s=%s", PoolLabel, envName))
+    pods, err := activator.Pods(p.rootCtx).Lister().Pods(config.Cfg.SandboxNamespace).List(selector)
+    if err != nil {
+        klog.Errorf("Failed to list warm pods of environment %s: %v", envName, err)
+        return nil
+    }
+    return pods
+}
+
+func (p *WarmPool) fill() {
+    for _, env := range *config.Environments {
+        if env.WarmPoolSize <= 0 {
+            continue
+        }
+        count := 0
+        for _, pod := range p.pods(env.Name) {
+            if pod.DeletionTimestamp != nil {
+                continue
+            }
+            // a broken warm pod never gets ready, replace it
+            if reason, message, failed := podFailure(pod); failed {
+                klog.Warningf("Delete failed warm pod %s: %s: %s", pod.Name, reason, message)
+                p.Release(pod.Name)
+                continue
+            }
+            count++
+        }
+        for i := count; i < env.WarmPoolSize; i++ {
+            if err := p.createPod(env); err != nil {
+                klog.Errorf("Failed to create warm pod of environment %s: %v", env.Name, err)
+                break
+            }
+        }
+    }
+}
+
+// createPod creates an unassigned pod from the sandbox template of the environment with the default resources.
+func (p *WarmPool) createPod(env *config.Environment) error {
+    sb := *DefaultSandbox
+    sb.Environment = env.Name
+    sb.Image = env.Image
+    sb.Name = "sandbox-pool-" + env.Name
+    sb.Make()
+
+    rs, err := buildReplicaSet(&sb)
+    if err != nil {
+        return err
+    }
+    pod := &v1core.Pod{
+        ObjectMeta: v1meta.ObjectMeta{
+            GenerateName: sb.Name + "-",
+            Namespace:    config.Cfg.SandboxNamespace,
+            Labels: map[string]string{
+                PoolLabel: env.Name,
+                "owner":   PoolOwner,
+            },
+            Annotations: rs.Spec.Template.Annotations,
+        },
+        Spec: rs.Spec.Template.Spec,
+    }
+    _, err = p.client.CoreV1().Pods(config.Cfg.SandboxNamespace).Create(context.TODO(), pod, v1meta.CreateOptions{})
+    return err
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "fmt"
+    "testing"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1core "k8s.io/api/core/v1"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/runtime"
+    kubefake "k8s.io/client-go/kubernetes/fake"
+    k8stesting "k8s.io/client-go/testing"
+)
+
+// withEnvironments replaces the environments for the test.
+func withEnvironments(t *testing.T, envs ...*config.Environment) {
+    saved := config.Environments
+    config.Environments = &envs
+    t.Cleanup(func() { config.Environments = saved })
+}
+
+// poolPod is a warm pod of the environment.
+func poolPod(name string, env string) *v1core.Pod {
+    pod := testReadyPod(name)
+    pod.Name = name
+    pod.Labels = map[string]string{PoolLabel: env, "owner": PoolOwner}
+    return pod
+}
+
+// generateNames names the pods created with GenerateName, the fake client does not.
+func generateNames(kube *kubefake.Clientset) {
+    count := 0
+    kube.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+        pod := action.(k8stesting.CreateAction).GetObject().(*v1core.Pod)
+        if pod.Name == "" {
+            count++
+            pod.Name = fmt.Sprintf("%s%d", pod.GenerateName, count)
+        }
+        return false, nil, nil
+    })
+}
+
+func listPods(t *testing.T, kube *kubefake.Clientset) map[string]*v1core.Pod {
+    list, err := kube.CoreV1().Pods(config.Cfg.SandboxNamespace).List(context.TODO(), v1meta.ListOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    pods := make(map[string]*v1core.Pod)
+    for i := range list.Items {
+        pods[list.Items[i].Name] = &list.Items[i]
+    }
+    return pods
+}
+
+func TestWarmPoolFill(t *testing.T) {
+    withEnvironments(t, &config.Environment{Name: "warm", Image: "busybox", WarmPoolSize: 2}, &config.Environment{Name: "cold", Image: "busybox"})
+    failed := waitingPod("failed", "ErrImagePull")
+    failed.Name = "failed"
+    failed.Labels = poolPod("failed", "warm").Labels
+    s, kube := newTestController(t, poolPod("ready", "warm"), failed)
+    generateNames(kube)
+
+    NewWarmPool(s.rootCtx, kube).fill()
+
+    pods := listPods(t, kube)
+    if _, ok := pods["failed"]; ok {
+        t.Fatal("expected the failed warm pod to be deleted")
+    }
+    // the failed pod is replaced, the ready one kept
+    if len(pods) != 2 || pods["ready"] == nil {
+        t.Fatalf("expected 2 warm pods, got %v", pods)
+    }
+    for name, pod := range pods {
+        if name == "ready" {
+            continue
+        }
+        if pod.Labels[PoolLabel] != "warm" || pod.Labels["owner"] != PoolOwner || pod.Spec.Containers[0].Image != "busybox" {
+            t.Fatalf("unexpected warm pod %s with labels %v", name, pod.Labels)
+        }
+    }
+}
+
+func TestWarmPoolClaim(t *testing.T) {
+    withEnvironments(t, &config.Environment{Name: "warm", Image: "busybox", WarmPoolSize: 1})
+    s, kube := newTestController(t, poolPod("pod", "warm"))
+    pool := NewWarmPool(s.rootCtx, kube)
+
+    // a sandbox which differs from the pool pods cold starts
+    custom := &Sandbox{}
+    custom.Name = "custom"
+    custom.Environment = "warm"
+    custom.Args = []string{"serve"}
+    custom.Make()
+    if claimed := pool.Claim(custom); claimed != "" {
+        t.Fatalf("expected the custom sandbox not to claim a warm pod, got %s", claimed)
+    }
+
+    sb := &Sandbox{Tenant: "team-a", Labels: map[string]string{"example.com/app": "demo"}}
+    sb.Name = "sb"
+    sb.Environment = "warm"
+    sb.Make()
+    if claimed := pool.Claim(sb); claimed != "pod" {
+        t.Fatalf("expected the warm pod to be claimed, got %q", claimed)
+    }
+    labels := listPods(t, kube)["pod"].Labels
+    if _, ok := labels[PoolLabel]; ok || labels["sandbox"] != "sb" || labels["owner"] != "agent-sandbox" || labels["example.com/app"] != "demo" {
+        t.Fatalf("unexpected labels of the claimed pod %v", labels)
+    }
+
+    // the informer still lists the pod as warm, the claim of another sandbox fails on the test op
+    other := &Sandbox{}
+    other.Name = "other"
+    other.Environment = "warm"
+    other.Make()
+    if claimed := pool.Claim(other); claimed != "" {
+        t.Fatalf("expected the claimed pod not to be claimed again, got %s", claimed)
+    }
+}
@@ -0,0 +1,298 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "sync"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    "k8s.io/apimachinery/pkg/api/resource"
+    "k8s.io/klog/v2"
+    "sigs.k8s.io/yaml"
+)
+
+// ErrQuotaExceeded is returned by a creation or update which would exceed a quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the sandboxes of a tenant, the zero values are unlimited. The paused and idle sandboxes count too.
+type Quota struct {
+    // Maximum number of sandboxes.
+    MaxSandboxes int `json:"max_sandboxes,omitempty"`
+
+    // Maximum sum of the CPU and memory requests and limits of the sandboxes.
+    CPU         *resource.Quantity `json:"cpu,omitempty"`
+    Memory      *resource.Quantity `json:"memory,omitempty"`
+    CPULimit    *resource.Quantity `json:"cpu_limit,omitempty"`
+    MemoryLimit *resource.Quantity `json:"memory_limit,omitempty"`
+
+    // Maximum Timeout of a sandbox in minutes.
+    MaxTimeout int `json:"max_timeout,omitempty"`
+}
+
+// QuotaPolicy is the quotas loaded from config.Cfg.SandboxQuotaFile.
+type QuotaPolicy struct {
+    // Quota of the tenants not listed in Tenants, of all sandboxes when authentication is disabled.
+    Default *Quota `json:"default,omitempty"`
+
+    // Quota by tenant, replacing Default.
+    Tenants map[string]*Quota `json:"tenants,omitempty"`
+
+    // Quota of the sandboxes of each tenant in the environment, in addition to the tenant quota.
+    Environments map[string]*Quota `json:"environments,omitempty"`
+}
+
+// Usage is the sum of the sandboxes counted against a quota.
+type Usage struct {
+    Sandboxes   int               `json:"sandboxes"`
+    CPU         resource.Quantity `json:"cpu"`
+    Memory      resource.Quantity `json:"memory"`
+    CPULimit    resource.Quantity `json:"cpu_limit"`
+    MemoryLimit resource.Quantity `json:"memory_limit"`
+}
+
+// QuotaUsage is a quota, nil if unlimited, and its usage.
+type QuotaUsage struct {
+    Quota *Quota `json:"quota,omitempty"`
+    Usage *Usage `json:"usage"`
+}
+
+// QuotaReport is the usage of a tenant against its quota and the quotas of the environments.
+type QuotaReport struct {
+    Tenant string `json:"tenant,omitempty"`
+    QuotaUsage
+    Environments map[string]*QuotaUsage `json:"environments,omitempty"`
+}
+
+var (
+    quotaPolicy *QuotaPolicy
+
+    // the sandboxes being created, the informer cache may not have their ReplicaSets yet
+    quotaMu  sync.Mutex
+    reserved = map[string]*Sandbox{}
+)
+
+func init() {
+    if config.Cfg.SandboxQuotaFile == "" {
+        return
+    }
+    p, err := LoadQuotaPolicy(config.Cfg.SandboxQuotaFile)
+    if err != nil {
+        panic(err)
+    }
+    quotaPolicy = p
+}
+
+// LoadQuotaPolicy reads a yaml or json quota file.
+func LoadQuotaPolicy(file string) (*QuotaPolicy, error) {
+    klog.Infof("Loading quotas from file %s", file)
+
+    raw, err := os.ReadFile(file)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read quota file %s: %v", file, err)
+    }
+    p := &QuotaPolicy{}
+    if err := yaml.UnmarshalStrict(raw, p); err != nil {
+        return nil, fmt.Errorf("failed to parse quota file %s: %v", file, err)
+    }
+    quotas := map[string]*Quota{"default": p.Default}
+    for tenant, q := range p.Tenants {
+        quotas["tenant "+tenant] = q
+    }
+    for env, q := range p.Environments {
+        quotas["environment "+env] = q
+    }
+    for name, q := range quotas {
+        if q == nil {
+            continue
+        }
+        if q.MaxSandboxes < 0 || q.MaxTimeout < 0 {
+            return nil, fmt.Errorf("invalid quota %s in file %s, max_sandboxes and max_timeout must not be negative", name, file)
+        }
+        for _, v := range []*resource.Quantity{q.CPU, q.Memory, q.CPULimit, q.MemoryLimit} {
+            if v != nil && v.Sign() < 0 {
+                return nil, fmt.Errorf("invalid quota %s in file %s, resources must not be negative", name, file)
+            }
+        }
+    }
+    return p, nil
+}
+
+// tenantQuota returns the quota of the tenant, nil if unlimited.
+func (p *QuotaPolicy) tenantQuota(tenant string) *Quota {
+    if q, ok := p.Tenants[tenant]; ok {
+        return q
+    }
+    return p.Default
+}
+
+func (u *Usage) add(sb *Sandbox) {
+    u.Sandboxes++
+    for _, r := range []struct {
+        sum   *resource.Quantity
+        value string
+    }{{&u.CPU, sb.CPU}, {&u.Memory, sb.Memory}, {&u.CPULimit, sb.CPULimit}, {&u.MemoryLimit, sb.MemoryLimit}} {
+        // validated on creation
+        if q, err := resource.ParseQuantity(r.value); err == nil {
+            r.sum.Add(q)
+        }
+    }
+}
+
+// check returns what of the quota the usage exceeds, empty if none.
+func (q *Quota) check(sb *Sandbox, u *Usage) string {
+    if q == nil {
+        return ""
+    }
+    if q.MaxTimeout > 0 && sb.Timeout > q.MaxTimeout {
+        return fmt.Sprintf("timeout %dm, max %dm", sb.Timeout, q.MaxTimeout)
+    }
+    if q.MaxSandboxes > 0 && u.Sandboxes > q.MaxSandboxes {
+        return fmt.Sprintf("%d sandboxes, max %d", u.Sandboxes, q.MaxSandboxes)
+    }
+    for _, r := range []struct {
+        name string
+        max  *resource.Quantity
+        used resource.Quantity
+    }{{"cpu", q.CPU, u.CPU}, {"memory", q.Memory, u.Memory}, {"cpu_limit", q.CPULimit, u.CPULimit}, {"memory_limit", q.MemoryLimit, u.MemoryLimit}} {
+        if r.max != nil && r.used.Cmp(*r.max) > 0 {
+            return fmt.Sprintf("%s %s, max %s", r.name, r.used.String(), r.max.String())
+        }
+    }
+    return ""
+}
+
+// tenantSandboxes returns the sandboxes of the tenant by name, including the ones being created. quotaMu must be held.
+func (s *Controller) tenantSandboxes(tenant string) (map[string]*Sandbox, error) {
+    opts := ListOptions{Tenant: tenant}
+    selector, err := opts.selector()
+    if err != nil {
+        return nil, err
+    }
+    rss, err := activator.ReplicaSets(s.rootCtx).Lister().List(selector)
+    if err != nil {
+        return nil, err
+    }
+    sandboxes := make(map[string]*Sandbox, len(rss))
+    for _, rs := range rss {
+        sb, err := ParseSandboxData(rs)
+        if err != nil {
+            klog.Errorf("Failed to parse sandbox %s: %v", rs.Name, err)
+            continue
+        }
+        sandboxes[sb.Name] = sb
+    }
+    for name, sb := range reserved {
+        if tenant == "" || sb.Tenant == tenant {
+            sandboxes[name] = sb
+        }
+    }
+    return sandboxes, nil
+}
+
+// checkQuota returns an error wrapping ErrQuotaExceeded if the sandbox, added or replacing the one of its name,
+// exceeds the quota of its tenant or environment. quotaMu must be held.
+func (s *Controller) checkQuota(sb *Sandbox) error {
+    tenantQuota, envQuota := quotaPolicy.tenantQuota(sb.Tenant), quotaPolicy.Environments[sb.Environment]
+    if tenantQuota == nil && envQuota == nil {
+        return nil
+    }
+    sandboxes, err := s.tenantSandboxes(sb.Tenant)
+    if err != nil {
+        return fmt.Errorf("failed to count the sandboxes of tenant %s: %v", sb.Tenant, err)
+    }
+    sandboxes[sb.Name] = sb
+
+    var total, env Usage
+    for _, o := range sandboxes {
+        total.add(o)
+        if o.Environment == sb.Environment {
+            env.add(o)
+        }
+    }
+    if exceeded := tenantQuota.check(sb, &total); exceeded != "" {
+        return fmt.Errorf("%w of tenant %s: %s", ErrQuotaExceeded, sb.Tenant, exceeded)
+    }
+    if exceeded := envQuota.check(sb, &env); exceeded != "" {
+        return fmt.Errorf("%w of environment %s of tenant %s: %s", ErrQuotaExceeded, sb.Environment, sb.Tenant, exceeded)
+    }
+    return nil
+}
+
+// reserveQuota checks the quota of a new sandbox and counts it until release is called, i.e. until its creation is
+// done or rolled back.
+func (s *Controller) reserveQuota(sb *Sandbox) (release func(), err error) {
+    if quotaPolicy == nil {
+        return func() {}, nil
+    }
+    quotaMu.Lock()
+    defer quotaMu.Unlock()
+    if err := s.checkQuota(sb); err != nil {
+        return nil, err
+    }
+    reserved[sb.Name] = sb
+    return func() {
+        quotaMu.Lock()
+        defer quotaMu.Unlock()
+        delete(reserved, sb.Name)
+    }, nil
+}
+
+// updateQuota checks the quota of the updated sandbox.
+func (s *Controller) updateQuota(sb *Sandbox) error {
+    if quotaPolicy == nil {
+        return nil
+    }
+    quotaMu.Lock()
+    defer quotaMu.Unlock()
+    return s.checkQuota(sb)
+}
+
+// QuotaReport returns the usage of the tenant against its quota and against the quotas of the environments.
+func (s *Controller) QuotaReport(tenant string) (*QuotaReport, error) {
+    report := &QuotaReport{
+        Tenant:       tenant,
+        QuotaUsage:   QuotaUsage{Usage: &Usage{}},
+        Environments: map[string]*QuotaUsage{},
+    }
+    if quotaPolicy != nil {
+        report.Quota = quotaPolicy.tenantQuota(tenant)
+        for name, q := range quotaPolicy.Environments {
+            report.Environments[name] = &QuotaUsage{Quota: q, Usage: &Usage{}}
+        }
+    }
+
+    quotaMu.Lock()
+    sandboxes, err := s.tenantSandboxes(tenant)
+    quotaMu.Unlock()
+    if err != nil {
+        return nil, err
+    }
+    for _, sb := range sandboxes {
+        report.Usage.add(sb)
+        env, ok := report.Environments[sb.Environment]
+        if !ok {
+            env = &QuotaUsage{Usage: &Usage{}}
+            report.Environments[sb.Environment] = env
+        }
+        env.Usage.add(sb)
+    }
+    return report, nil
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "testing"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    v1 "k8s.io/api/apps/v1"
+    "k8s.io/apimachinery/pkg/api/resource"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    appsinformers "k8s.io/client-go/informers/apps/v1"
+    appslisters "k8s.io/client-go/listers/apps/v1"
+    "k8s.io/client-go/tools/cache"
+    rsfiltered "knative.dev/pkg/client/injection/kube/informers/apps/v1/replicaset/filtered"
+)
+
+// testReplicaSetInformer serves the lister of the indexer, the informer is never run.
+type testReplicaSetInformer struct {
+    indexer cache.Indexer
+}
+
+func (i *testReplicaSetInformer) Informer() cache.SharedIndexInformer {
+    return nil
+}
+
+func (i *testReplicaSetInformer) Lister() appslisters.ReplicaSetLister {
+    return appslisters.NewReplicaSetLister(i.indexer)
+}
+
+var _ appsinformers.ReplicaSetInformer = &testReplicaSetInformer{}
+
+// newQuotaController returns a controller whose ReplicaSet informer holds the sandboxes, the quota policy is
+// restored after the test.
+func newQuotaController(t *testing.T, policy *QuotaPolicy, sandboxes ...*Sandbox) *Controller {
+    saved := quotaPolicy
+    quotaPolicy = policy
+    t.Cleanup(func() { quotaPolicy = saved })
+
+    indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+    for _, sb := range sandboxes {
+        raw, _ := json.Marshal(sb)
+        rs := &v1.ReplicaSet{
+            ObjectMeta: v1meta.ObjectMeta{
+                Name:        sb.Name,
+                Namespace:   "agent-sandbox",
+                Labels:      map[string]string{"owner": "agent-sandbox", "sandbox": sb.Name, auth.TenantLabel: sb.Tenant},
+                Annotations: map[string]string{SandboxDataAnnotation: string(raw)},
+            },
+        }
+        if err := indexer.Add(rs); err != nil {
+            t.Fatal(err)
+        }
+    }
+    ctx := context.WithValue(context.Background(), rsfiltered.Key{Selector: activator.SandboxSelector}, &testReplicaSetInformer{indexer: indexer})
+    return &Controller{rootCtx: ctx}
+}
+
+func quantity(s string) *resource.Quantity {
+    q := resource.MustParse(s)
+    return &q
+}
+
+func testSandbox(name string, tenant string, env string, cpu string) *Sandbox {
+    sb := &Sandbox{Tenant: tenant}
+    sb.Name, sb.Environment, sb.CPU, sb.Memory, sb.Timeout = name, env, cpu, "1Gi", 60
+    return sb
+}
+
+func resources(cpu string, memory string, cpuLimit string, memoryLimit string) *Sandbox {
+    sb := &Sandbox{}
+    sb.CPU, sb.Memory, sb.CPULimit, sb.MemoryLimit = cpu, memory, cpuLimit, memoryLimit
+    return sb
+}
+
+func TestUsageAdd(t *testing.T) {
+    var u Usage
+    u.add(resources("500m", "512Mi", "1", "1Gi"))
+    u.add(resources("1", "1Gi", "2", ""))
+    // an invalid value is not counted
+    u.add(resources("lots", "", "", ""))
+
+    if u.Sandboxes != 3 {
+        t.Fatalf("expected 3 sandboxes, got %d", u.Sandboxes)
+    }
+    for _, tt := range []struct {
+        name     string
+        sum      resource.Quantity
+        expected string
+    }{
+        {"cpu", u.CPU, "1500m"},
+        {"memory", u.Memory, "1536Mi"},
+        {"cpu_limit", u.CPULimit, "3"},
+        {"memory_limit", u.MemoryLimit, "1Gi"},
+    } {
+        if tt.sum.Cmp(resource.MustParse(tt.expected)) != 0 {
+            t.Errorf("expected %s %s, got %s", tt.name, tt.expected, tt.sum.String())
+        }
+    }
+}
+
+func TestQuotaCheck(t *testing.T) {
+    q := &Quota{MaxSandboxes: 2, CPU: quantity("2"), MaxTimeout: 120}
+    tests := []struct {
+        name     string
+        quota    *Quota
+        timeout  int
+        usage    Usage
+        exceeded bool
+    }{
+        {"unlimited", nil, 1000, Usage{Sandboxes: 100, CPU: resource.MustParse("100")}, false},
+        {"within", q, 60, Usage{Sandboxes: 2, CPU: resource.MustParse("2")}, false},
+        {"sandboxes", q, 60, Usage{Sandboxes: 3}, true},
+        {"cpu", q, 60, Usage{Sandboxes: 1, CPU: resource.MustParse("2001m")}, true},
+        {"timeout", q, 121, Usage{Sandboxes: 1}, true},
+        {"zero values are unlimited", &Quota{}, 1000, Usage{Sandboxes: 100}, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            sb := &Sandbox{}
+            sb.Timeout = tt.timeout
+            exceeded := tt.quota.check(sb, &tt.usage)
+            if (exceeded != "") != tt.exceeded {
+                t.Fatalf("expected exceeded %v, got %q", tt.exceeded, exceeded)
+            }
+        })
+    }
+}
+
+func TestCheckQuota(t *testing.T) {
+    policy := &QuotaPolicy{
+        Default: &Quota{MaxSandboxes: 1},
+        Tenants: map[string]*Quota{
+            "team-a": {MaxSandboxes: 3, CPU: quantity("3")},
+            "team-b": nil,
+        },
+        Environments: map[string]*Quota{"python": {MaxSandboxes: 1}},
+    }
+    s := newQuotaController(t, policy,
+        testSandbox("a-1", "team-a", "aio", "1"),
+        testSandbox("a-2", "team-a", "python", "1"),
+        testSandbox("c-1", "team-c", "aio", "1"),
+    )
+
+    tests := []struct {
+        name     string
+        sandbox  *Sandbox
+        exceeded bool
+    }{
+        {"within the tenant quota", testSandbox("a-3", "team-a", "aio", "1"), false},
+        {"tenant cpu", testSandbox("a-3", "team-a", "aio", "1500m"), true},
+        {"environment quota", testSandbox("a-3", "team-a", "python", "500m"), true},
+        {"replace by name is counted once", testSandbox("a-2", "team-a", "python", "1"), false},
+        {"replace by name with more cpu", testSandbox("a-2", "team-a", "python", "2"), false},
+        {"replace by name exceeding cpu", testSandbox("a-2", "team-a", "python", "2500m"), true},
+        {"replace by name into the environment", testSandbox("a-1", "team-a", "python", "1"), true},
+        {"default quota", testSandbox("c-2", "team-c", "aio", "1"), true},
+        {"default quota of a new tenant", testSandbox("d-1", "team-d", "aio", "1"), false},
+        {"unlimited tenant", testSandbox("b-1", "team-b", "aio", "100"), false},
+        {"environment quota of an unlimited tenant", testSandbox("b-1", "team-b", "python", "1"), false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := s.checkQuota(tt.sandbox)
+            if tt.exceeded != errors.Is(err, ErrQuotaExceeded) {
+                t.Fatalf("expected exceeded %v, got %v", tt.exceeded, err)
+            }
+        })
+    }
+}
+
+func TestReserveQuota(t *testing.T) {
+    s := newQuotaController(t, &QuotaPolicy{Default: &Quota{MaxSandboxes: 5}}, testSandbox("a-1", "team-a", "aio", "1"))
+
+    var wg sync.WaitGroup
+    var mu sync.Mutex
+    var releases []func()
+    for i := 0; i < 20; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            release, err := s.reserveQuota(testSandbox(fmt.Sprintf("new-%d", i), "team-a", "aio", "1"))
+            if err != nil {
+                if !errors.Is(err, ErrQuotaExceeded) {
+                    t.Errorf("unexpected error %v", err)
+                }
+                return
+            }
+            mu.Lock()
+            releases = append(releases, release)
+            mu.Unlock()
+        }(i)
+    }
+    wg.Wait()
+    if len(releases) != 4 {
+        t.Fatalf("expected 4 reservations next to the existing sandbox, got %d", len(releases))
+    }
+    // the reservations of another tenant do not count
+    if _, err := s.reserveQuota(testSandbox("b-1", "team-b", "aio", "1")); err != nil {
+        t.Fatalf("reservation of another tenant failed: %v", err)
+    }
+
+    for _, release := range releases {
+        release()
+    }
+    release, err := s.reserveQuota(testSandbox("new-again", "team-a", "aio", "1"))
+    if err != nil {
+        t.Fatalf("reservation after release failed: %v", err)
+    }
+    release()
+    quotaMu.Lock()
+    delete(reserved, "b-1")
+    quotaMu.Unlock()
+}
+
+func TestLoadQuotaPolicy(t *testing.T) {
+    tests := []struct {
+        name  string
+        quota string
+        err   bool
+    }{
+        {"valid", "default:\n  max_sandboxes: 10\n  cpu: 4\n  memory: 8Gi\ntenants:\n  team-a:\n    max_timeout: 60\n  team-b: null\nenvironments:\n  python:\n    max_sandboxes: 2\n", false},
+        {"json", `{"default":{"max_sandboxes":1,"memory_limit":"1Gi"}}`, false},
+        {"negative max_sandboxes", "default:\n  max_sandboxes: -1\n", true},
+        {"negative max_timeout", "tenants:\n  team-a:\n    max_timeout: -1\n", true},
+        {"negative cpu", "environments:\n  python:\n    cpu: -1\n", true},
+        {"invalid quantity", "default:\n  memory: lots\n", true},
+        {"unknown field", "default:\n  max_sandbox: 1\n", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            file := filepath.Join(t.TempDir(), "quota.yaml")
+            if err := os.WriteFile(file, []byte(tt.quota), 0o600); err != nil {
+                t.Fatal(err)
+            }
+            _, err := LoadQuotaPolicy(file)
+            if (err != nil) != tt.err {
+                t.Fatalf("expected error %v, got %v", tt.err, err)
+            }
+        })
+    }
+    if _, err := LoadQuotaPolicy(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+        t.Fatal("expected error of a missing file")
+    }
+}
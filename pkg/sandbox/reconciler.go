@@ -0,0 +1,313 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1 "k8s.io/api/apps/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/labels"
+    "k8s.io/apimachinery/pkg/util/wait"
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/tools/cache"
+    "k8s.io/client-go/util/workqueue"
+    "k8s.io/klog/v2"
+    "knative.dev/pkg/injection/clients/dynamicclient"
+)
+
+// Reconciler drives the ReplicaSet of every agents.sandbox.io Sandbox resource and mirrors the live status back.
+// It creates the missing ReplicaSets, applies the spec changes to them and adopts the annotated ReplicaSets created
+// before the CRD. The sandbox-data annotation is a copy of the spec written by the reconciler only.
+type Reconciler struct {
+    rootCtx    context.Context
+    controller *Controller
+    client     dynamic.Interface
+    informer   cache.SharedIndexInformer
+    queue      workqueue.RateLimitingInterface
+}
+
+func NewReconciler(ctx context.Context, c *Controller) *Reconciler {
+    r := &Reconciler{
+        rootCtx:    ctx,
+        controller: c,
+        client:     dynamicclient.Get(ctx),
+        informer:   sandboxResourceInformer(ctx),
+        queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "sandboxes"),
+    }
+
+    enqueue := func(obj interface{}) {
+        if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
+            r.queue.Add(key)
+        }
+    }
+    r.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+        AddFunc:    enqueue,
+        UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+    })
+
+    // refresh the status of the owning Sandbox on every ReplicaSet change
+    activator.ReplicaSets(ctx).Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+        FilterFunc: isSandboxObject,
+        Handler: cache.ResourceEventHandlerFuncs{
+            UpdateFunc: func(_, obj interface{}) {
+                rs := obj.(*v1.ReplicaSet)
+                if owner := v1meta.GetControllerOf(rs); owner != nil && owner.Kind == SandboxKind {
+                    r.queue.Add(rs.Namespace + "/" + owner.Name)
+                }
+            },
+        },
+    })
+    return r
+}
+
+// Run starts the Sandbox informer, adopts the existing sandboxes and reconciles until rootCtx is done.
+func (r *Reconciler) Run() {
+    defer r.queue.ShutDown()
+
+    if !cache.WaitForCacheSync(r.rootCtx.Done(), r.informer.HasSynced) {
+        klog.Error("Failed to sync the sandbox resource informer, is the agents.sandbox.io CRD installed?")
+        return
+    }
+
+    if err := r.adoptReplicaSets(); err != nil {
+        klog.Errorf("Failed to adopt the existing sandboxes: %v", err)
+    }
+
+    klog.Info("Starting sandbox reconciler")
+    go wait.Until(func() {
+        for r.processNextItem() {
+        }
+    }, time.Second, r.rootCtx.Done())
+    <-r.rootCtx.Done()
+}
+
+func (r *Reconciler) processNextItem() bool {
+    key, quit := r.queue.Get()
+    if quit {
+        return false
+    }
+    defer r.queue.Done(key)
+
+    if err := r.reconcile(key.(string)); err != nil {
+        klog.Errorf("Failed to reconcile sandbox %s: %v", key, err)
+        r.queue.AddRateLimited(key)
+        return true
+    }
+    r.queue.Forget(key)
+    return true
+}
+
+func (r *Reconciler) reconcile(key string) error {
+    obj, exists, err := r.informer.GetIndexer().GetByKey(key)
+    if err != nil {
+        return err
+    }
+    // a deleted Sandbox takes its ReplicaSet with it by the owner reference
+    if !exists {
+        return nil
+    }
+    res, err := sandboxResourceFromUnstructured(obj.(*unstructured.Unstructured))
+    if err != nil {
+        return err
+    }
+    if res.DeletionTimestamp != nil {
+        return nil
+    }
+
+    rs, err := activator.ReplicaSets(r.rootCtx).Lister().ReplicaSets(res.Namespace).Get(res.Name)
+    if apierrors.IsNotFound(err) {
+        return r.createReplicaSet(res)
+    }
+    if err != nil {
+        return err
+    }
+
+    if owner := v1meta.GetControllerOf(rs); owner == nil {
+        return r.adopt(rs, res)
+    } else if owner.UID != res.UID {
+        return fmt.Errorf("replicaset %s is controlled by %s %s", rs.Name, owner.Kind, owner.Name)
+    }
+
+    if applied, err := r.applySpec(res, rs); err != nil {
+        if serr := r.setStatus(res, SandboxResourceStatus{Phase: StatusError, Reason: err.Error(), ObservedGeneration: res.Generation}); serr != nil {
+            klog.Errorf("Failed to set the status of sandbox resource %s: %v", res.Name, serr)
+        }
+        return err
+    } else if applied {
+        // the ReplicaSet update enqueues the sandbox again
+        return nil
+    }
+
+    return r.updateStatus(res, rs)
+}
+
+// applySpec applies the changes of the spec, e.g. by kubectl or GitOps, to the ReplicaSet, applied reports whether
+// there were any.
+func (r *Reconciler) applySpec(res *SandboxResource, rs *v1.ReplicaSet) (applied bool, err error) {
+    current, err := ParseSandboxData(rs)
+    if err != nil {
+        return false, err
+    }
+    // the desired sandbox is defaulted like at creation, so the fields left out do not differ
+    desired := res.Spec.Sandbox
+    desired.Name = res.Name
+    if err := r.controller.applyGroup(&desired); err != nil {
+        return false, err
+    }
+    desired.Make()
+
+    if patch := patchOf(current, &desired); patch != nil {
+        klog.Infof("Applying the spec of sandbox resource %s", res.Name)
+        restart, err := r.controller.applyPatch(res.Name, patch)
+        if err != nil {
+            return false, fmt.Errorf("failed to apply the spec: %v", err)
+        }
+        if restart {
+            go func() {
+                if err := r.controller.Restart(res.Name, config.Cfg.SandboxActivationTimeout); err != nil {
+                    klog.Errorf("Failed to restart sandbox %s after its spec changed: %v", res.Name, err)
+                }
+            }()
+        }
+        applied = true
+    }
+
+    switch paused := current.Status == StatusPaused; {
+    case res.Spec.Paused && !paused:
+        klog.Infof("Pausing sandbox %s by its resource", res.Name)
+        return true, r.controller.transition(res.Name, StatusPaused, 0)
+    case !res.Spec.Paused && paused:
+        klog.Infof("Resuming sandbox %s by its resource", res.Name)
+        return true, r.controller.transition(res.Name, StatusRunning, 1)
+    }
+    return applied, nil
+}
+
+// createReplicaSet creates the ReplicaSet of a Sandbox declared by kubectl or GitOps.
+func (r *Reconciler) createReplicaSet(res *SandboxResource) error {
+    sb := res.Spec.Sandbox
+    sb.Name = res.Name
+    err := r.controller.snapshotEnvironment(&sb)
+    if err == nil {
+        err = r.controller.applyGroup(&sb)
+    }
+    sb.Make()
+    if err == nil {
+        err = r.controller.restoreFromSnapshot(&sb)
+    }
+    if err == nil {
+        err = sb.Validate()
+    }
+    // the ReplicaSet is created next to the Sandbox resource owning it
+    if namespace := config.TenantNamespace(sb.Tenant); err == nil && res.Namespace != namespace {
+        err = fmt.Errorf("the sandbox of tenant %s must be declared in namespace %s", sb.Tenant, namespace)
+    }
+    if err == nil {
+        err = r.controller.ensureNamespace(sb.Tenant)
+    }
+    if err == nil {
+        err = r.controller.claimName(&sb)
+    }
+    if err != nil {
+        return r.setStatus(res, SandboxResourceStatus{Phase: StatusError, Reason: err.Error(), ObservedGeneration: res.Generation})
+    }
+    sb.Status = StatusCreating
+
+    klog.Infof("Creating replicaset of sandbox resource %s", res.Name)
+    if err := r.controller.createReplicaSet(&sb, res.ownerReference()); err != nil && !apierrors.IsAlreadyExists(err) {
+        return err
+    }
+    return r.setStatus(res, SandboxResourceStatus{Phase: StatusCreating, ObservedGeneration: res.Generation})
+}
+
+// adopt sets the Sandbox resource as controller of a ReplicaSet created before the CRD.
+func (r *Reconciler) adopt(rs *v1.ReplicaSet, res *SandboxResource) error {
+    klog.Infof("Adopting replicaset %s by sandbox resource", rs.Name)
+    rs = rs.DeepCopy()
+    rs.OwnerReferences = append(rs.OwnerReferences, *res.ownerReference())
+    _, err := r.controller.client.AppsV1().ReplicaSets(rs.Namespace).Update(context.TODO(), rs, v1meta.UpdateOptions{})
+    return err
+}
+
+func (r *Reconciler) updateStatus(res *SandboxResource, rs *v1.ReplicaSet) error {
+    sb, err := r.controller.sandboxOf(rs)
+    if err != nil {
+        return err
+    }
+    r.controller.fillStatus(sb, rs)
+    return r.setStatus(res, SandboxResourceStatus{
+        Phase:              sb.Status,
+        Reason:             sb.Reason,
+        PodIP:              sb.PodIP,
+        Node:               sb.Node,
+        ReadyReplicas:      rs.Status.ReadyReplicas,
+        ObservedGeneration: res.Generation,
+    })
+}
+
+func (r *Reconciler) setStatus(res *SandboxResource, status SandboxResourceStatus) error {
+    if res.Status == status {
+        return nil
+    }
+    res = &SandboxResource{TypeMeta: res.TypeMeta, ObjectMeta: res.ObjectMeta, Spec: res.Spec, Status: status}
+    u, err := res.toUnstructured()
+    if err != nil {
+        return err
+    }
+    _, err = r.client.Resource(SandboxGVR).Namespace(res.Namespace).UpdateStatus(context.TODO(), u, v1meta.UpdateOptions{})
+    return err
+}
+
+// adoptReplicaSets migrates the sandboxes created before the CRD, a Sandbox resource is created
+// from the sandbox-data annotation of every ReplicaSet without one, the reconcile then adopts it.
+func (r *Reconciler) adoptReplicaSets() error {
+    selector, _ := labels.Parse("owner=agent-sandbox")
+    rss, err := activator.ReplicaSets(r.rootCtx).Lister().List(selector)
+    if err != nil {
+        return err
+    }
+    for _, rs := range rss {
+        if rs.DeletionTimestamp != nil || v1meta.GetControllerOf(rs) != nil {
+            continue
+        }
+        sb, err := ParseSandboxData(rs)
+        if err != nil {
+            klog.Errorf("Skip adopting replicaset %s: %v", rs.Name, err)
+            continue
+        }
+        sb.Name = rs.Name
+        u, err := newSandboxResource(sb).toUnstructured()
+        if err != nil {
+            return err
+        }
+        u.SetNamespace(rs.Namespace)
+        _, err = r.client.Resource(SandboxGVR).Namespace(rs.Namespace).Create(context.TODO(), u, v1meta.CreateOptions{})
+        if err != nil && !apierrors.IsAlreadyExists(err) {
+            klog.Errorf("Failed to create sandbox resource for replicaset %s: %v", rs.Name, err)
+            continue
+        }
+        klog.Infof("Migrated replicaset %s to a sandbox resource", rs.Name)
+    }
+    return nil
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "testing"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    dynamicfake "k8s.io/client-go/dynamic/fake"
+    "k8s.io/client-go/tools/cache"
+    "knative.dev/pkg/injection/clients/dynamicclient"
+)
+
+// newTestReconciler returns a reconciler of the controller, the informer cache and the api server hold the resource.
+func newTestReconciler(t *testing.T, s *Controller, res *SandboxResource) *Reconciler {
+    u, err := res.toUnstructured()
+    if err != nil {
+        t.Fatal(err)
+    }
+    dynamic := dynamicclient.Get(s.rootCtx).(*dynamicfake.FakeDynamicClient)
+    if err := dynamic.Tracker().Create(SandboxGVR, u, res.Namespace); err != nil {
+        t.Fatal(err)
+    }
+    // never run, the cache is filled by the test
+    informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &unstructured.Unstructured{}, 0, cache.Indexers{})
+    if err := informer.GetIndexer().Add(u); err != nil {
+        t.Fatal(err)
+    }
+    return &Reconciler{rootCtx: s.rootCtx, controller: s, client: dynamic, informer: informer}
+}
+
+func testSandboxResource(name string, paused bool) *SandboxResource {
+    sb := &Sandbox{}
+    sb.Name = name
+    res := newSandboxResource(sb)
+    res.UID = "resource-uid"
+    res.Spec.Paused = paused
+    return res
+}
+
+func TestReconcileCreatesReplicaSet(t *testing.T) {
+    s, kube := newTestController(t)
+    res := testSandboxResource("sb", false)
+    r := newTestReconciler(t, s, res)
+
+    if err := r.reconcile(res.Namespace + "/sb"); err != nil {
+        t.Fatal(err)
+    }
+    rs, err := kube.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Get(context.TODO(), "sb", v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if owner := v1meta.GetControllerOf(rs); owner == nil || owner.UID != res.UID {
+        t.Fatalf("expected the replicaset to be controlled by the sandbox resource, got %v", owner)
+    }
+
+    u, err := r.client.Resource(SandboxGVR).Namespace(res.Namespace).Get(context.TODO(), "sb", v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if phase, _, _ := unstructured.NestedString(u.Object, "status", "phase"); phase != StatusCreating {
+        t.Fatalf("expected the creating phase, got %q", phase)
+    }
+}
+
+func TestReconcilePaused(t *testing.T) {
+    res := testSandboxResource("sb", true)
+    rs := testSandboxReplicaSet(t, "sb", StatusRunning)
+    rs.OwnerReferences = append(rs.OwnerReferences, *res.ownerReference())
+    s, kube := newTestController(t, rs)
+    r := newTestReconciler(t, s, res)
+
+    if err := r.reconcile(res.Namespace + "/sb"); err != nil {
+        t.Fatal(err)
+    }
+    if replicas, sb := getSandbox(t, kube, "sb"); replicas != 0 || sb.Status != StatusPaused {
+        t.Fatalf("expected the spec to pause the sandbox, got %s with %d replicas", sb.Status, replicas)
+    }
+}
@@ -0,0 +1,385 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sort"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    jsonpatch "github.com/evanphx/json-patch/v5"
+    v1 "k8s.io/api/apps/v1"
+    v1core "k8s.io/api/core/v1"
+    "k8s.io/apimachinery/pkg/api/resource"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/util/strategicpatch"
+    "sigs.k8s.io/yaml"
+)
+
+const (
+    // OverlayStrategicMerge is a partial ReplicaSet merged like kubectl patch --type strategic.
+    OverlayStrategicMerge = "strategic"
+
+    // OverlayJSONPatch is a list of RFC 6902 operations like kubectl patch --type json.
+    OverlayJSONPatch = "json"
+)
+
+// Overlay customizes the ReplicaSet built for every sandbox, e.g. to add a nodeSelector and tolerations.
+type Overlay struct {
+    Type  string
+    Patch []byte
+}
+
+// LoadOverlay reads a yaml or json overlay file, a list is a json patch, an object a strategic merge patch.
+func LoadOverlay(file string) (*Overlay, error) {
+    val, err := os.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+    return ParseOverlay(val, "template file "+file)
+}
+
+// ParseOverlay parses a yaml or json overlay, source names it in errors. A go template fails, its {{.Sandbox.Name}}
+// or {{.Sandbox.Image}} would be taken literally, the sandbox fields are set by buildReplicaSet.
+func ParseOverlay(val []byte, source string) (*Overlay, error) {
+    if bytes.Contains(val, []byte("{{")) {
+        return nil, fmt.Errorf("%s is a go template, the sandbox ReplicaSet is not rendered from a template anymore, "+
+            "convert it to a strategic merge patch with only the fields to customize, e.g. spec.template.spec.nodeSelector", source)
+    }
+    patch, err := yaml.YAMLToJSON(val)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse %s: %v", source, err)
+    }
+
+    overlay := &Overlay{Type: OverlayStrategicMerge, Patch: patch}
+    if trimmed := bytes.TrimSpace(patch); len(trimmed) > 0 && trimmed[0] == '[' {
+        if _, err := jsonpatch.DecodePatch(patch); err != nil {
+            return nil, fmt.Errorf("invalid json patch in %s: %v", source, err)
+        }
+        overlay.Type = OverlayJSONPatch
+    }
+    return overlay, nil
+}
+
+// loadEnvironmentOverlays loads the template_file or template_patch of each environment.
+func loadEnvironmentOverlays(envs []*config.Environment) (map[string]*Overlay, error) {
+    overlays := make(map[string]*Overlay)
+    for _, env := range envs {
+        var overlay *Overlay
+        var err error
+        switch {
+        case env.TemplateFile != "":
+            overlay, err = LoadOverlay(env.TemplateFile)
+        case len(env.TemplatePatch) > 0:
+            overlay, err = ParseOverlay(env.TemplatePatch, "template_patch of environment "+env.Name)
+        default:
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        overlays[env.Name] = overlay
+    }
+    return overlays, nil
+}
+
+// Apply patches the ReplicaSet with the overlay.
+func (o *Overlay) Apply(rs *v1.ReplicaSet) (*v1.ReplicaSet, error) {
+    original, err := json.Marshal(rs)
+    if err != nil {
+        return nil, err
+    }
+
+    var patched []byte
+    switch o.Type {
+    case OverlayJSONPatch:
+        patch, err := jsonpatch.DecodePatch(o.Patch)
+        if err != nil {
+            return nil, err
+        }
+        patched, err = patch.Apply(original)
+        if err != nil {
+            return nil, fmt.Errorf("apply json patch fail: %v", err)
+        }
+    default:
+        patched, err = strategicpatch.StrategicMergePatch(original, o.Patch, v1.ReplicaSet{})
+        if err != nil {
+            return nil, fmt.Errorf("apply strategic merge patch fail: %v", err)
+        }
+    }
+
+    out := &v1.ReplicaSet{}
+    if err := json.Unmarshal(patched, out); err != nil {
+        return nil, fmt.Errorf("unmarshal patched replicaset fail: %v", err)
+    }
+    return out, nil
+}
+
+// buildReplicaSet builds the sandbox ReplicaSet and applies SandboxTemplateOverlay on top. The identity of the
+// sandbox, name, selector, owner labels and sandbox-data, is set again after the overlay so it can not be changed.
+func buildReplicaSet(sb *Sandbox) (*v1.ReplicaSet, error) {
+    raw, err := json.Marshal(sb)
+    if err != nil {
+        return nil, err
+    }
+    requests, err := resourceList(sb.CPU, sb.Memory)
+    if err != nil {
+        return nil, err
+    }
+    limits, err := resourceList(sb.CPULimit, sb.MemoryLimit)
+    if err != nil {
+        return nil, err
+    }
+
+    replicas := int32(1)
+    rs := &v1.ReplicaSet{
+        TypeMeta: v1meta.TypeMeta{
+            APIVersion: "apps/v1",
+            Kind:       "ReplicaSet",
+        },
+        Spec: v1.ReplicaSetSpec{
+            Replicas: &replicas,
+            Template: v1core.PodTemplateSpec{
+                Spec: v1core.PodSpec{
+                    Containers: []v1core.Container{
+                        {
+                            Name:            "sandbox",
+                            Image:           sb.Image,
+                            ImagePullPolicy: v1core.PullIfNotPresent,
+                            Env: []v1core.EnvVar{
+                                {
+                                    Name: "INSTANCE_NAME",
+                                    ValueFrom: &v1core.EnvVarSource{
+                                        FieldRef: &v1core.ObjectFieldSelector{FieldPath: "metadata.name"},
+                                    },
+                                },
+                            },
+                            Resources: v1core.ResourceRequirements{
+                                Requests: requests,
+                                Limits:   limits,
+                            },
+                        },
+                    },
+                },
+            },
+        },
+    }
+
+    if SandboxTemplateOverlay != nil {
+        if rs, err = SandboxTemplateOverlay.Apply(rs); err != nil {
+            return nil, err
+        }
+    }
+    if env, ok := config.LookupEnvironment(sb.Environment); ok {
+        applyEnvironment(rs, env)
+        if overlay := environmentOverlays[env.Name]; overlay != nil {
+            if rs, err = overlay.Apply(rs); err != nil {
+                return nil, fmt.Errorf("environment %s: %v", env.Name, err)
+            }
+        }
+    }
+
+    rs.Name = sb.Name
+    rs.Namespace = config.TenantNamespace(sb.Tenant)
+    if rs.Annotations == nil {
+        rs.Annotations = make(map[string]string)
+    }
+    rs.Annotations[SandboxDataAnnotation] = string(raw)
+    rs.Spec.Replicas = &replicas
+    rs.Spec.Selector = &v1meta.LabelSelector{
+        MatchLabels: map[string]string{"sandbox": sb.Name},
+    }
+    applyLabels(rs, sb.Labels)
+    for _, l := range []map[string]string{rs.Labels, rs.Spec.Template.Labels} {
+        l["sandbox"] = sb.Name
+        l["owner"] = "agent-sandbox"
+        if sb.Tenant != "" {
+            l[auth.TenantLabel] = sb.Tenant
+        }
+    }
+    if sb.Idempotency != nil {
+        rs.Labels[IdempotencyKeyLabel] = sb.Idempotency.Key
+    }
+    applyContainerSpec(rs, sb)
+    applyVolumes(rs, sb)
+    return rs, nil
+}
+
+// applyEnvironment merges the scheduling and pod settings of the environment, a nodeSelector key or a volume of
+// the same name set by SandboxTemplateFile is replaced, tolerations and pull secrets are added.
+func applyEnvironment(rs *v1.ReplicaSet, env *config.Environment) {
+    spec := &rs.Spec.Template.Spec
+    if len(env.NodeSelector) > 0 && spec.NodeSelector == nil {
+        spec.NodeSelector = make(map[string]string)
+    }
+    for k, v := range env.NodeSelector {
+        spec.NodeSelector[k] = v
+    }
+    spec.Tolerations = append(spec.Tolerations, env.Tolerations...)
+    if env.Affinity != nil {
+        spec.Affinity = env.Affinity.DeepCopy()
+    }
+    if env.RuntimeClassName != "" {
+        runtimeClassName := env.RuntimeClassName
+        spec.RuntimeClassName = &runtimeClassName
+    }
+    if env.PriorityClassName != "" {
+        spec.PriorityClassName = env.PriorityClassName
+    }
+    for _, name := range env.ImagePullSecrets {
+        exists := false
+        for _, s := range spec.ImagePullSecrets {
+            if s.Name == name {
+                exists = true
+            }
+        }
+        if !exists {
+            spec.ImagePullSecrets = append(spec.ImagePullSecrets, v1core.LocalObjectReference{Name: name})
+        }
+    }
+    for _, volume := range env.Volumes {
+        replaced := false
+        for i := range spec.Volumes {
+            if spec.Volumes[i].Name == volume.Name {
+                spec.Volumes[i] = *volume.DeepCopy()
+                replaced = true
+            }
+        }
+        if !replaced {
+            spec.Volumes = append(spec.Volumes, *volume.DeepCopy())
+        }
+    }
+    if c := sandboxContainer(rs); c != nil {
+        for _, mount := range env.VolumeMounts {
+            replaced := false
+            for i := range c.VolumeMounts {
+                if c.VolumeMounts[i].MountPath == mount.MountPath {
+                    c.VolumeMounts[i] = mount
+                    replaced = true
+                }
+            }
+            if !replaced {
+                c.VolumeMounts = append(c.VolumeMounts, mount)
+            }
+        }
+    }
+}
+
+// sandboxContainer returns the container named sandbox or else the first one, nil if there is none.
+func sandboxContainer(rs *v1.ReplicaSet) *v1core.Container {
+    containers := rs.Spec.Template.Spec.Containers
+    if len(containers) == 0 {
+        return nil
+    }
+    for i := range containers {
+        if containers[i].Name == "sandbox" {
+            return &containers[i]
+        }
+    }
+    return &containers[0]
+}
+
+func resourceList(cpu string, memory string) (v1core.ResourceList, error) {
+    list := v1core.ResourceList{}
+    for name, value := range map[v1core.ResourceName]string{v1core.ResourceCPU: cpu, v1core.ResourceMemory: memory} {
+        if value == "" {
+            continue
+        }
+        q, err := resource.ParseQuantity(value)
+        if err != nil {
+            return nil, fmt.Errorf("invalid %s %q: %v", name, value, err)
+        }
+        list[name] = q
+    }
+    return list, nil
+}
+
+// applyLabels sets the user-defined labels on the ReplicaSet and its pod template, the selector is left alone.
+func applyLabels(rs *v1.ReplicaSet, userLabels map[string]string) {
+    if rs.Labels == nil {
+        rs.Labels = make(map[string]string)
+    }
+    if rs.Spec.Template.Labels == nil {
+        rs.Spec.Template.Labels = make(map[string]string)
+    }
+    for k, v := range userLabels {
+        if reservedLabels[k] {
+            continue
+        }
+        rs.Labels[k] = v
+        rs.Spec.Template.Labels[k] = v
+    }
+}
+
+// applyContainerSpec sets Env, Args, Workdir and Ports of the sandbox on the sandbox container, the container named
+// sandbox or else the first one. They are set on the typed object, so no value can break the rendered yaml.
+func applyContainerSpec(rs *v1.ReplicaSet, sb *Sandbox) {
+    c := sandboxContainer(rs)
+    if c == nil {
+        return
+    }
+
+    names := make([]string, 0, len(sb.Env))
+    for name := range sb.Env {
+        names = append(names, name)
+    }
+    // stable order, a changed order would roll the pod template
+    sort.Strings(names)
+    for _, name := range names {
+        value := sb.Env[name]
+        if value == nil {
+            continue
+        }
+        env := v1core.EnvVar{Name: name, Value: *value}
+        replaced := false
+        for i := range c.Env {
+            if c.Env[i].Name == name {
+                c.Env[i] = env
+                replaced = true
+            }
+        }
+        if !replaced {
+            c.Env = append(c.Env, env)
+        }
+    }
+
+    if len(sb.Args) > 0 {
+        c.Args = sb.Args
+    }
+    if sb.Workdir != "" {
+        c.WorkingDir = sb.Workdir
+    }
+    for _, port := range sb.Ports {
+        exists := false
+        for _, p := range c.Ports {
+            if p.ContainerPort == int32(port) {
+                exists = true
+            }
+        }
+        if !exists {
+            c.Ports = append(c.Ports, v1core.ContainerPort{
+                Name:          fmt.Sprintf("port-%d", port),
+                ContainerPort: int32(port),
+                Protocol:      v1core.ProtocolTCP,
+            })
+        }
+    }
+}
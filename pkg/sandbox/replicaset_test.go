@@ -0,0 +1,240 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1core "k8s.io/api/core/v1"
+)
+
+func TestParseOverlayRejectsTemplate(t *testing.T) {
+    template := `apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: {{.Sandbox.Name}}
+spec:
+  template:
+    spec:
+      containers:
+      - name: sandbox
+        image: {{.Sandbox.Image}}
+`
+    file := filepath.Join(t.TempDir(), "sandbox_template.yaml")
+    if err := os.WriteFile(file, []byte(template), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := LoadOverlay(file); err == nil || !strings.Contains(err.Error(), "go template") {
+        t.Fatalf("expected the template file to fail, got %v", err)
+    }
+    if _, err := ParseOverlay([]byte(template), "template_patch of environment test"); err == nil {
+        t.Fatal("expected the template patch to fail")
+    }
+}
+
+func TestBuildReplicaSetContainerSpec(t *testing.T) {
+    // values which would break out of an unquoted template field are kept verbatim
+    image := "busybox\n        command: [\"sh\"]"
+    value := "a\"b\n  - name: INJECTED"
+    sb := &Sandbox{}
+    sb.Name = "sb-spec"
+    sb.Image = image
+    sb.Env = map[string]*string{"B": &value, "A": &value}
+    sb.Args = []string{"-c", "echo '{{.Sandbox.Name}}'"}
+    sb.Workdir = "/work dir"
+    sb.Ports = []int{8080, 8080, 9090}
+    sb.Environment = "custom"
+
+    rs, err := buildReplicaSet(sb)
+    if err != nil {
+        t.Fatal(err)
+    }
+    c := sandboxContainer(rs)
+    if c.Image != image || c.Command != nil {
+        t.Fatalf("unexpected image %q or command %v", c.Image, c.Command)
+    }
+    if !reflect.DeepEqual(c.Args, sb.Args) || c.WorkingDir != "/work dir" {
+        t.Fatalf("unexpected args %v or workdir %q", c.Args, c.WorkingDir)
+    }
+    var names []string
+    for _, env := range c.Env {
+        names = append(names, env.Name)
+        if env.Name != "INSTANCE_NAME" && env.Value != value {
+            t.Fatalf("unexpected value %q of %s", env.Value, env.Name)
+        }
+    }
+    if want := []string{"INSTANCE_NAME", "A", "B"}; !reflect.DeepEqual(names, want) {
+        t.Fatalf("expected env %v, got %v", want, names)
+    }
+    if len(c.Ports) != 2 || c.Ports[0].ContainerPort != 8080 || c.Ports[1].ContainerPort != 9090 {
+        t.Fatalf("unexpected ports %v", c.Ports)
+    }
+}
+
+// withOverlay sets SandboxTemplateOverlay for the test.
+func withOverlay(t *testing.T, overlay string) {
+    parsed, err := ParseOverlay([]byte(overlay), "test overlay")
+    if err != nil {
+        t.Fatal(err)
+    }
+    saved := SandboxTemplateOverlay
+    SandboxTemplateOverlay = parsed
+    t.Cleanup(func() { SandboxTemplateOverlay = saved })
+}
+
+func TestBuildReplicaSetOverlay(t *testing.T) {
+    withOverlay(t, `
+metadata:
+  name: renamed
+  labels:
+    sandbox: other
+    team: infra
+spec:
+  selector:
+    matchLabels:
+      sandbox: other
+  template:
+    spec:
+      nodeSelector:
+        pool: sandboxes
+      containers:
+      - name: sidecar
+        image: envoy
+`)
+    sb := &Sandbox{}
+    sb.Name = "sb"
+    sb.Make()
+    rs, err := buildReplicaSet(sb)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // the identity of the sandbox can not be changed by the overlay
+    if rs.Name != "sb" || rs.Labels["sandbox"] != "sb" || rs.Spec.Selector.MatchLabels["sandbox"] != "sb" || rs.Labels["owner"] != "agent-sandbox" {
+        t.Fatalf("unexpected identity %s with labels %v and selector %v", rs.Name, rs.Labels, rs.Spec.Selector.MatchLabels)
+    }
+    if rs.Labels["team"] != "infra" || rs.Spec.Template.Spec.NodeSelector["pool"] != "sandboxes" {
+        t.Fatalf("expected the overlay to be applied, got labels %v and node selector %v", rs.Labels, rs.Spec.Template.Spec.NodeSelector)
+    }
+    containers := rs.Spec.Template.Spec.Containers
+    if len(containers) != 2 || sandboxContainer(rs).Image != sb.Image {
+        t.Fatalf("expected the sidecar next to the sandbox container, got %v", containers)
+    }
+    if _, ok := rs.Annotations[SandboxDataAnnotation]; !ok {
+        t.Fatal("expected the sandbox-data annotation")
+    }
+}
+
+func TestBuildReplicaSetJSONPatch(t *testing.T) {
+    withOverlay(t, `[{"op": "add", "path": "/spec/template/spec/hostname", "value": "sandbox"}]`)
+    if SandboxTemplateOverlay.Type != OverlayJSONPatch {
+        t.Fatalf("expected a list to be a json patch, got %s", SandboxTemplateOverlay.Type)
+    }
+    sb := &Sandbox{}
+    sb.Name = "sb"
+    sb.Make()
+    rs, err := buildReplicaSet(sb)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rs.Spec.Template.Spec.Hostname != "sandbox" {
+        t.Fatalf("expected the json patch to be applied, got hostname %q", rs.Spec.Template.Spec.Hostname)
+    }
+
+    withOverlay(t, `[{"op": "replace", "path": "/spec/template/spec/missing/field", "value": 1}]`)
+    if _, err := buildReplicaSet(sb); err == nil {
+        t.Fatal("expected a json patch of a missing path to fail")
+    }
+}
+
+func TestBuildReplicaSetEnvironment(t *testing.T) {
+    withOverlay(t, `
+spec:
+  template:
+    spec:
+      nodeSelector:
+        pool: sandboxes
+        zone: a
+      imagePullSecrets:
+      - name: registry
+`)
+    env := &config.Environment{
+        Name:              "gpu",
+        Image:             "cuda",
+        NodeSelector:      map[string]string{"pool": "gpu"},
+        Tolerations:       []v1core.Toleration{{Key: "nvidia.com/gpu", Operator: v1core.TolerationOpExists}},
+        RuntimeClassName:  "gvisor",
+        PriorityClassName: "high",
+        ImagePullSecrets:  []string{"registry", "nvcr"},
+        Volumes:           []v1core.Volume{{Name: "models", VolumeSource: v1core.VolumeSource{EmptyDir: &v1core.EmptyDirVolumeSource{}}}},
+        VolumeMounts:      []v1core.VolumeMount{{Name: "models", MountPath: "/models"}},
+        TemplatePatch:     []byte(`{"spec":{"template":{"spec":{"hostname":"gpu"}}}}`),
+    }
+    withEnvironments(t, env)
+    overlays, err := loadEnvironmentOverlays(*config.Environments)
+    if err != nil {
+        t.Fatal(err)
+    }
+    saved := environmentOverlays
+    environmentOverlays = overlays
+    t.Cleanup(func() { environmentOverlays = saved })
+
+    sb := &Sandbox{}
+    sb.Name = "sb"
+    sb.Environment = "gpu"
+    sb.Make()
+    rs, err := buildReplicaSet(sb)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    spec := rs.Spec.Template.Spec
+    // the environment replaces the keys of the global overlay and keeps the others
+    if spec.NodeSelector["pool"] != "gpu" || spec.NodeSelector["zone"] != "a" {
+        t.Fatalf("unexpected node selector %v", spec.NodeSelector)
+    }
+    if len(spec.Tolerations) != 1 || *spec.RuntimeClassName != "gvisor" || spec.PriorityClassName != "high" {
+        t.Fatalf("unexpected scheduling settings %v %v %s", spec.Tolerations, spec.RuntimeClassName, spec.PriorityClassName)
+    }
+    if len(spec.ImagePullSecrets) != 2 {
+        t.Fatalf("expected the pull secrets without duplicates, got %v", spec.ImagePullSecrets)
+    }
+    if len(spec.Volumes) != 1 || sandboxContainer(rs).VolumeMounts[0].MountPath != "/models" {
+        t.Fatalf("unexpected volumes %v", spec.Volumes)
+    }
+    if spec.Hostname != "gpu" || sandboxContainer(rs).Image != "cuda" {
+        t.Fatalf("expected the template patch and image of the environment, got %q and %q", spec.Hostname, sandboxContainer(rs).Image)
+    }
+
+    // a sandbox of another environment gets none of it
+    other := &Sandbox{}
+    other.Name = "other"
+    other.Image = "busybox"
+    other.Make()
+    rs, err = buildReplicaSet(other)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if spec := rs.Spec.Template.Spec; spec.RuntimeClassName != nil || spec.Hostname != "" || spec.NodeSelector["pool"] != "sandboxes" {
+        t.Fatalf("unexpected settings of the custom sandbox %v", spec)
+    }
+}
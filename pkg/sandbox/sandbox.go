@@ -17,25 +17,62 @@
 package sandbox
 
 import (
+    "encoding/json"
     "fmt"
-    "os"
+    "path"
+    "strings"
     "time"
 
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
     "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    "github.com/agent-sandbox/agent-sandbox/pkg/status"
+    v1 "k8s.io/api/apps/v1"
+    "k8s.io/apimachinery/pkg/util/validation"
 )
 
-var SandboxDeployTemplate string
+const (
+    // SandboxDataAnnotation is the ReplicaSet annotation holding the json encoded Sandbox spec, a copy of the spec of
+    // the Sandbox resource with the CRD.
+    SandboxDataAnnotation = "sandbox-data"
+)
+
+const (
+    StatusCreating   = status.Creating
+    StatusRunning    = status.Running
+    StatusIdle       = status.Idle
+    StatusPaused     = status.Paused
+    StatusRestarting = status.Restarting
+    StatusDeleting   = status.Deleting
+    StatusError      = status.Error
+)
+
+const (
+    // IdlePolicyDelete deletes the sandbox when the idle timeout is reached.
+    IdlePolicyDelete = "delete"
+
+    // IdlePolicyScaleDown scales the sandbox to zero when the idle timeout is reached, it is kept for later resume.
+    IdlePolicyScaleDown = "scaledown"
+)
+
+// SandboxTemplateOverlay is the operator customization of the sandbox ReplicaSet loaded from SandboxTemplateFile, nil if not set.
+var SandboxTemplateOverlay *Overlay
+
+// environmentOverlays are the template_file or template_patch of the environments by environment name.
+var environmentOverlays map[string]*Overlay
 
 func init() {
-    SandboxDeployTemplate = defaultSandboxTemplate
     if config.Cfg.SandboxTemplateFile != "" {
-        var err error
-        var val []byte
-        if val, err = os.ReadFile(config.Cfg.SandboxTemplateFile); err != nil {
+        overlay, err := LoadOverlay(config.Cfg.SandboxTemplateFile)
+        if err != nil {
             panic(err)
         }
-        SandboxDeployTemplate = string(val)
+        SandboxTemplateOverlay = overlay
+    }
+    overlays, err := loadEnvironmentOverlays(*config.Environments)
+    if err != nil {
+        panic(err)
     }
+    environmentOverlays = overlays
 }
 
 type SandboxBase struct {
@@ -48,7 +85,7 @@ type SandboxBase struct {
 }
 
 type Sandbox struct {
-    SandboxBase
+    SandboxBase `json:",inline"`
 
     // Set the CMD of the SandboxHandler, overriding any CMD of the container image.
     Args []string `json:"args,omitempty"`
@@ -56,6 +93,9 @@ type Sandbox struct {
     // Associate the sandbox with an app. Required unless creating from a container.
     App string `json:"app,omitempty" jsonschema:"App to for associate the sandbox with an app"`
 
+    // User-defined labels, set on the sandbox ReplicaSet and pod.
+    Labels map[string]string `json:"labels,omitempty"`
+
     // The image to run as the container for the sandbox.
     Image string `json:"image,omitempty"`
 
@@ -89,8 +129,48 @@ type Sandbox struct {
     // HTTP/2 encrypted ports
     Ports []int `json:"ports,omitempty"`
 
-    // Status of the sandbox. Options are 'creating', 'running', 'idle', 'deleting', 'error'.
+    // Persistent volumes of the sandbox, each one a PersistentVolumeClaim which outlives the pod.
+    Volumes []SandboxVolume `json:"volumes,omitempty"`
+
+    // Restore the workspace from a snapshot, the snapshotted volume is provisioned from it.
+    FromSnapshot string `json:"from_snapshot,omitempty"`
+
+    // Tenant owning the sandbox, set from the credentials of the creator.
+    Tenant string `json:"tenant,omitempty"`
+
+    // Hashes of the idempotency key and request of the creation, set from the Idempotency-Key header.
+    Idempotency *Idempotency `json:"idempotency,omitempty"`
+
+    // Keep the ReplicaSet of a failed creation for debugging instead of rolling it back.
+    KeepOnFailure bool `json:"keep_on_failure,omitempty"`
+
+    // Status of the sandbox. Options are 'creating', 'running', 'idle', 'paused', 'restarting', 'deleting', 'error'.
     Status string `json:"status,omitempty"`
+
+    // Human-readable reason of the Status, e.g. the container waiting reason of an errored sandbox.
+    Reason string `json:"reason,omitempty"`
+
+    // IP of the sandbox pod, empty until the pod is running.
+    PodIP string `json:"pod_ip,omitempty"`
+
+    // Node the sandbox pod is scheduled to.
+    Node string `json:"node,omitempty"`
+
+    // Time of the last request or response routed to the sandbox, RFC3339. Empty after a restart of agent-sandbox
+    // until the sandbox is active again or the idler read its events.
+    LastActiveAt string `json:"last_active_at,omitempty"`
+
+    // Creation time of the sandbox, RFC3339.
+    CreatedAt string `json:"created_at,omitempty"`
+
+    // Snapshots of the volumes of the sandbox forked by ForkMethodSnapshot, taken by Create. Never decoded nor stored,
+    // the converter of the Sandbox resource can not skip unexported fields.
+    ForkSnapshots *ForkSnapshots `json:"-"`
+}
+
+// clearLiveFields resets the fields computed on read, they are not stored.
+func (o *Sandbox) clearLiveFields() {
+    o.Status, o.Reason, o.PodIP, o.Node, o.LastActiveAt, o.CreatedAt = "", "", "", "", "", ""
 }
 
 var DefaultSandbox = &Sandbox{
@@ -100,17 +180,39 @@ var DefaultSandbox = &Sandbox{
     MemoryLimit: "1024Mi",
     Timeout:     60,
     IdleTimeout: 10,
+    IdlePolicy:  IdlePolicyDelete,
 }
 
 func (o *Sandbox) Make() {
+    if o.Timeout <= 0 {
+        o.Timeout = DefaultSandbox.Timeout
+    }
     // one day max
     if o.Timeout >= 1440 {
         o.Timeout = 1440
     }
+    if o.IdleTimeout <= 0 {
+        o.IdleTimeout = DefaultSandbox.IdleTimeout
+    }
     // one hour max
     if o.IdleTimeout > 60 {
         o.IdleTimeout = 60
     }
+    if o.IdlePolicy == "" {
+        o.IdlePolicy = DefaultSandbox.IdlePolicy
+    }
+    if o.CPU == "" {
+        o.CPU = DefaultSandbox.CPU
+    }
+    if o.Memory == "" {
+        o.Memory = DefaultSandbox.Memory
+    }
+    if o.CPULimit == "" {
+        o.CPULimit = DefaultSandbox.CPULimit
+    }
+    if o.MemoryLimit == "" {
+        o.MemoryLimit = DefaultSandbox.MemoryLimit
+    }
 
     if o.Environment == "" && o.Image == "" {
         o.Image = config.Cfg.SandboxDefaultImage
@@ -118,7 +220,9 @@ func (o *Sandbox) Make() {
     }
 
     if o.Environment != "" && o.Image == "" {
-        o.Image = config.GetEnvironmentByName(o.Environment).Image
+        if env, ok := config.LookupEnvironment(o.Environment); ok {
+            o.Image = env.Image
+        }
     }
 
     if o.Environment == "" && o.Image != "" {
@@ -129,50 +233,79 @@ func (o *Sandbox) Make() {
         o.Name = fmt.Sprintf("sandbox-%s-%d", o.Environment, time.Now().Unix())
     }
 
+    for i := range o.Volumes {
+        if o.Volumes[i].Name == "" {
+            o.Volumes[i].Name = DefaultVolumeName
+        }
+        if o.Volumes[i].MountPath == "" {
+            o.Volumes[i].MountPath = o.Workdir
+        }
+    }
+
 }
 
-type SandboxKube struct {
-    Sandbox   *Sandbox
-    RawData   string
-    Namespace string
+// reservedLabels are managed by agent-sandbox, they can not be set by the user.
+var reservedLabels = map[string]bool{
+    "sandbox":           true,
+    "owner":             true,
+    PoolLabel:           true,
+    IdempotencyKeyLabel: true,
+    auth.TenantLabel:    true,
 }
 
-const defaultSandboxTemplate = `apiVersion: apps/v1
-kind: ReplicaSet
-metadata:
-  name: {{.Sandbox.Name}}
-  namespace: {{.Namespace}}
-  annotations:
-    sandbox-data:  |
-        {{.RawData}}
-  labels:
-    sandbox: "{{.Sandbox.Name}}"
-    owner: agent-sandbox
-spec:
-  replicas: 1
-  selector:
-    matchLabels:
-      sandbox: "{{.Sandbox.Name}}"
-  template:
-    metadata:
-      labels:
-        sandbox: "{{.Sandbox.Name}}"
-        owner: agent-sandbox
-    spec:
-      containers:
-      - name: sandbox
-        image: {{.Sandbox.Image}}
-        imagePullPolicy: IfNotPresent
-        env:
-        - name: INSTANCE_NAME
-          valueFrom:
-            fieldRef:
-              fieldPath: metadata.name
-        resources:
-          requests:
-            cpu: {{.Sandbox.CPU}}
-            memory: {{.Sandbox.Memory}}
-          limits:
-            cpu: {{.Sandbox.CPULimit}}
-            memory: {{.Sandbox.MemoryLimit}}
-`
+// Validate checks the fields which can not be defaulted by Make.
+func (o *Sandbox) Validate() error {
+    if o.Image == "" {
+        return fmt.Errorf("unknown environment %q", o.Environment)
+    }
+    if o.IdlePolicy != IdlePolicyDelete && o.IdlePolicy != IdlePolicyScaleDown {
+        return fmt.Errorf("invalid idle_policy %q, options are '%s' or '%s'", o.IdlePolicy, IdlePolicyDelete, IdlePolicyScaleDown)
+    }
+    for k, v := range o.Labels {
+        if reservedLabels[k] {
+            return fmt.Errorf("label %s is reserved", k)
+        }
+        if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+            return fmt.Errorf("invalid label key %q: %s", k, strings.Join(errs, ", "))
+        }
+        if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+            return fmt.Errorf("invalid label value %q of %s: %s", v, k, strings.Join(errs, ", "))
+        }
+    }
+    for k := range o.Env {
+        if errs := validation.IsEnvVarName(k); len(errs) > 0 {
+            return fmt.Errorf("invalid env name %q: %s", k, strings.Join(errs, ", "))
+        }
+    }
+    for _, port := range o.Ports {
+        if errs := validation.IsValidPortNum(port); len(errs) > 0 {
+            return fmt.Errorf("invalid port %d: %s", port, strings.Join(errs, ", "))
+        }
+    }
+    if o.Workdir != "" && !path.IsAbs(o.Workdir) {
+        return fmt.Errorf("invalid workdir %q, must be an absolute path", o.Workdir)
+    }
+    if err := validateVolumes(o); err != nil {
+        return err
+    }
+    if _, err := resourceList(o.CPU, o.Memory); err != nil {
+        return err
+    }
+    if _, err := resourceList(o.CPULimit, o.MemoryLimit); err != nil {
+        return err
+    }
+    return nil
+}
+
+// ParseSandboxData decodes the Sandbox stored in the sandbox-data annotation of the ReplicaSet.
+func ParseSandboxData(rs *v1.ReplicaSet) (*Sandbox, error) {
+    sb := &Sandbox{}
+    raw, ok := rs.Annotations[SandboxDataAnnotation]
+    if !ok {
+        return nil, fmt.Errorf("replicaset %s has no %s annotation", rs.Name, SandboxDataAnnotation)
+    }
+    if err := json.Unmarshal([]byte(raw), sb); err != nil {
+        return nil, fmt.Errorf("failed to unmarshal %s of replicaset %s: %v", SandboxDataAnnotation, rs.Name, err)
+    }
+    return sb, nil
+}
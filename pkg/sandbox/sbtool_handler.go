@@ -44,6 +44,10 @@ func (a *Handler) executorHandler(ctx context.Context, req *mcp.CallToolRequest,
         return nil, nil, fmt.Errorf("tool_name is required")
     }
 
+    if _, err := a.lookup(ctx, tool.SandboxName); err != nil {
+        return nil, nil, err
+    }
+
     session, err := a.acquireClientSession(ctx, tool.SandboxName)
     if err != nil {
         return nil, nil, fmt.Errorf("failed to acquire client session for sandbox %s: %v", tool.SandboxName, err)
@@ -81,6 +85,10 @@ func (a *Handler) executorHandler(ctx context.Context, req *mcp.CallToolRequest,
 func (a *Handler) acquireClientSession(ctx context.Context, name string) (*mcp.ClientSession, error) {
     var session *mcp.ClientSession
 
+    if err := a.activator.Activate(ctx, name); err != nil {
+        return nil, fmt.Errorf("failed to activate sandbox %s: %v", name, err)
+    }
+
     sbIP, err := router.AcquireDest(a.rootCtx, name)
     if err != nil {
         return nil, fmt.Errorf("failed to acquire destination IP for sandbox %s: %v", name, err)
@@ -0,0 +1,288 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/klog/v2"
+    "knative.dev/pkg/injection/clients/dynamicclient"
+)
+
+const (
+    // SnapshotVolumeLabel is the sandbox volume a VolumeSnapshot was taken of.
+    SnapshotVolumeLabel = "sandbox-volume"
+)
+
+// VolumeSnapshotGVR is the CSI VolumeSnapshot, the snapshot CRDs and controller must be installed in the cluster.
+var VolumeSnapshotGVR = schema.GroupVersionResource{
+    Group:    "snapshot.storage.k8s.io",
+    Version:  "v1",
+    Resource: "volumesnapshots",
+}
+
+// Snapshot is a VolumeSnapshot of a sandbox volume, it outlives the sandbox.
+type Snapshot struct {
+    Name        string `json:"name"`
+    Sandbox     string `json:"sandbox"`
+    Volume      string `json:"volume"`
+    Tenant      string `json:"tenant,omitempty"`
+    Environment string `json:"environment,omitempty"`
+    Image       string `json:"image,omitempty"`
+    Ready       bool   `json:"ready"`
+    RestoreSize string `json:"restore_size,omitempty"`
+    Error       string `json:"error,omitempty"`
+    CreatedAt   string `json:"created_at,omitempty"`
+}
+
+// SnapshotRequest is the body of a snapshot creation, all fields are optional.
+type SnapshotRequest struct {
+    // Name of the snapshot. default <sandbox>-<volume>-<unix time>
+    Name string `json:"name,omitempty"`
+
+    // Volume to snapshot. default the first volume of the sandbox
+    Volume string `json:"volume,omitempty"`
+
+    // VolumeSnapshotClass of the snapshot, the cluster default class when empty.
+    SnapshotClass string `json:"snapshot_class,omitempty"`
+}
+
+// CreateSnapshot takes a VolumeSnapshot of a volume of the sandbox.
+func (s *Controller) CreateSnapshot(name string, req *SnapshotRequest) (*Snapshot, error) {
+    sb := s.Get(name)
+    if sb == nil {
+        return nil, fmt.Errorf("sandbox %s not found", name)
+    }
+    if len(sb.Volumes) == 0 {
+        return nil, fmt.Errorf("sandbox %s has no volumes to snapshot", name)
+    }
+    volume := sb.Volumes[0]
+    if req.Volume != "" {
+        found := false
+        for _, v := range sb.Volumes {
+            if v.Name == req.Volume {
+                volume, found = v, true
+            }
+        }
+        if !found {
+            return nil, fmt.Errorf("sandbox %s has no volume %s", name, req.Volume)
+        }
+    }
+    snapshotName := req.Name
+    if snapshotName == "" {
+        snapshotName = fmt.Sprintf("%s-%s-%d", name, volume.Name, time.Now().Unix())
+    }
+    // the snapshots are found by name like the sandboxes, the names are unique over the namespaces of the tenants
+    if config.Cfg.SandboxNamespacePerTenant {
+        if _, err := s.getSnapshot(snapshotName); err == nil {
+            return nil, fmt.Errorf("snapshot %s already exists", snapshotName)
+        }
+    }
+    // the snapshot is taken next to the claim of the volume
+    namespace := s.namespace(name)
+
+    // the spec of the sandbox is kept to restore the volume the way it was mounted
+    source := *sb
+    source.clearLiveFields()
+    raw, err := json.Marshal(source)
+    if err != nil {
+        return nil, err
+    }
+
+    spec := map[string]interface{}{
+        "source": map[string]interface{}{
+            "persistentVolumeClaimName": volume.claimName(name),
+        },
+    }
+    labels := map[string]interface{}{
+        "sandbox":           name,
+        "owner":             "agent-sandbox",
+        SnapshotVolumeLabel: volume.Name,
+    }
+    if sb.Tenant != "" {
+        labels[auth.TenantLabel] = sb.Tenant
+    }
+    if req.SnapshotClass != "" {
+        spec["volumeSnapshotClassName"] = req.SnapshotClass
+    }
+    u := &unstructured.Unstructured{Object: map[string]interface{}{
+        "apiVersion": VolumeSnapshotGVR.Group + "/" + VolumeSnapshotGVR.Version,
+        "kind":       "VolumeSnapshot",
+        "metadata": map[string]interface{}{
+            "name":      snapshotName,
+            "namespace": namespace,
+            "labels":    labels,
+            "annotations": map[string]interface{}{
+                SandboxDataAnnotation: string(raw),
+            },
+        },
+        "spec": spec,
+    }}
+
+    created, err := dynamicclient.Get(s.rootCtx).Resource(VolumeSnapshotGVR).Namespace(namespace).Create(context.TODO(), u, v1meta.CreateOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("create volume snapshot fail: %v", err)
+    }
+    klog.Infof("Created snapshot %s of volume %s of sandbox %s", snapshotName, volume.Name, name)
+    return snapshotFromUnstructured(created), nil
+}
+
+// ListSnapshots returns the snapshots taken of the sandbox, also after the sandbox is deleted.
+func (s *Controller) ListSnapshots(name string) ([]*Snapshot, error) {
+    list, err := dynamicclient.Get(s.rootCtx).Resource(VolumeSnapshotGVR).Namespace(config.SandboxNamespaces()).List(context.TODO(), v1meta.ListOptions{
+        LabelSelector: fmt.Sprintf("owner=agent-sandbox,sandbox=%s", name),
+    })
+    if err != nil {
+        return nil, fmt.Errorf("list volume snapshots fail: %v", err)
+    }
+    snapshots := make([]*Snapshot, 0, len(list.Items))
+    for i := range list.Items {
+        snapshots = append(snapshots, snapshotFromUnstructured(&list.Items[i]))
+    }
+    return snapshots, nil
+}
+
+// getSnapshot returns the VolumeSnapshot by name, it is searched in the namespaces of all tenants with
+// config.Cfg.SandboxNamespacePerTenant.
+func (s *Controller) getSnapshot(snapshot string) (*unstructured.Unstructured, error) {
+    snapshots := dynamicclient.Get(s.rootCtx).Resource(VolumeSnapshotGVR)
+    if !config.Cfg.SandboxNamespacePerTenant {
+        return snapshots.Namespace(config.Cfg.SandboxNamespace).Get(context.TODO(), snapshot, v1meta.GetOptions{})
+    }
+    list, err := snapshots.List(context.TODO(), v1meta.ListOptions{
+        LabelSelector: "owner=agent-sandbox",
+        FieldSelector: "metadata.name=" + snapshot,
+    })
+    if err != nil {
+        return nil, err
+    }
+    if len(list.Items) == 0 {
+        return nil, apierrors.NewNotFound(VolumeSnapshotGVR.GroupResource(), snapshot)
+    }
+    return &list.Items[0], nil
+}
+
+// GetSnapshot returns the snapshot and the spec of the sandbox it was taken of.
+func (s *Controller) GetSnapshot(snapshot string) (*Snapshot, *Sandbox, error) {
+    u, err := s.getSnapshot(snapshot)
+    if err != nil {
+        return nil, nil, fmt.Errorf("get snapshot %s fail: %v", snapshot, err)
+    }
+    if u.GetLabels()["owner"] != "agent-sandbox" {
+        return nil, nil, fmt.Errorf("volume snapshot %s is not a sandbox snapshot", snapshot)
+    }
+    source := &Sandbox{}
+    if err := json.Unmarshal([]byte(u.GetAnnotations()[SandboxDataAnnotation]), source); err != nil {
+        return nil, nil, fmt.Errorf("failed to parse snapshot %s: %v", snapshot, err)
+    }
+    return snapshotFromUnstructured(u), source, nil
+}
+
+// DeleteSnapshot deletes a snapshot of the sandbox.
+func (s *Controller) DeleteSnapshot(name string, snapshot string) error {
+    u, err := s.getSnapshot(snapshot)
+    if err != nil {
+        return fmt.Errorf("get snapshot %s fail: %v", snapshot, err)
+    }
+    if u.GetLabels()["owner"] != "agent-sandbox" || u.GetLabels()["sandbox"] != name {
+        return fmt.Errorf("snapshot %s is not a snapshot of sandbox %s", snapshot, name)
+    }
+    return dynamicclient.Get(s.rootCtx).Resource(VolumeSnapshotGVR).Namespace(u.GetNamespace()).Delete(context.TODO(), snapshot, v1meta.DeleteOptions{})
+}
+
+// snapshotEnvironment sets the environment and image of a sandbox restored from a snapshot to the ones the snapshot was
+// taken in when the request left them empty, like the restoreSandbox tool. It must run before Make defaults them.
+func (s *Controller) snapshotEnvironment(sb *Sandbox) error {
+    if sb.FromSnapshot == "" || sb.Environment != "" || sb.Image != "" {
+        return nil
+    }
+    snap, source, err := s.GetSnapshot(sb.FromSnapshot)
+    if err != nil {
+        return err
+    }
+    if !auth.Allowed(sb.Tenant, source.Tenant) {
+        return fmt.Errorf("snapshot %s not found", sb.FromSnapshot)
+    }
+    sb.Environment, sb.Image = snap.Environment, snap.Image
+    return nil
+}
+
+// restoreFromSnapshot sets FromSnapshot as the source of the snapshotted volume, a volume of the same name keeps its
+// mount_path and size, else the volume is mounted as in the snapshotted sandbox.
+func (s *Controller) restoreFromSnapshot(sb *Sandbox) error {
+    if sb.FromSnapshot == "" {
+        return nil
+    }
+    snap, source, err := s.GetSnapshot(sb.FromSnapshot)
+    if err != nil {
+        return err
+    }
+    if !auth.Allowed(sb.Tenant, source.Tenant) {
+        return fmt.Errorf("snapshot %s not found", sb.FromSnapshot)
+    }
+    var volume *SandboxVolume
+    for i := range source.Volumes {
+        if source.Volumes[i].Name == snap.Volume {
+            volume = &source.Volumes[i]
+        }
+    }
+    if volume == nil {
+        return fmt.Errorf("snapshot %s has no volume %s", snap.Name, snap.Volume)
+    }
+
+    for i := range sb.Volumes {
+        if sb.Volumes[i].Name == volume.Name {
+            sb.Volumes[i].Snapshot = snap.Name
+            sb.Volumes[i].ClaimName = ""
+            if sb.Volumes[i].Size == "" {
+                sb.Volumes[i].Size = volume.Size
+            }
+            return nil
+        }
+    }
+    restored := *volume
+    restored.Snapshot, restored.ClaimName, restored.Retain = snap.Name, "", false
+    sb.Volumes = append(sb.Volumes, restored)
+    return nil
+}
+
+func snapshotFromUnstructured(u *unstructured.Unstructured) *Snapshot {
+    snap := &Snapshot{
+        Name:      u.GetName(),
+        Sandbox:   u.GetLabels()["sandbox"],
+        Volume:    u.GetLabels()[SnapshotVolumeLabel],
+        Tenant:    u.GetLabels()[auth.TenantLabel],
+        CreatedAt: u.GetCreationTimestamp().Format(time.RFC3339),
+    }
+    source := &Sandbox{}
+    if err := json.Unmarshal([]byte(u.GetAnnotations()[SandboxDataAnnotation]), source); err == nil {
+        snap.Environment, snap.Image = source.Environment, source.Image
+    }
+    snap.Ready, _, _ = unstructured.NestedBool(u.Object, "status", "readyToUse")
+    snap.RestoreSize, _, _ = unstructured.NestedString(u.Object, "status", "restoreSize")
+    snap.Error, _, _ = unstructured.NestedString(u.Object, "status", "error", "message")
+    return snap
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestCreateSnapshot(t *testing.T) {
+    s, _ := newForkSource(t)
+
+    snap, err := s.CreateSnapshot("source", &SnapshotRequest{Name: "snap"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    // the first volume by default, with the environment of the sandbox
+    if snap.Name != "snap" || snap.Sandbox != "source" || snap.Volume != "workspace" || snap.Image == "" {
+        t.Fatalf("unexpected snapshot %+v", snap)
+    }
+    if _, err := s.CreateSnapshot("source", &SnapshotRequest{Name: "cache", Volume: "cache"}); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.CreateSnapshot("source", &SnapshotRequest{Volume: "missing"}); err == nil {
+        t.Fatal("expected the snapshot of a missing volume to fail")
+    }
+
+    snapshots, err := s.ListSnapshots("source")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(snapshots) != 2 {
+        t.Fatalf("expected 2 snapshots, got %d", len(snapshots))
+    }
+
+    if err := s.DeleteSnapshot("other", "snap"); err == nil {
+        t.Fatal("expected the snapshot not to be deleted as a snapshot of another sandbox")
+    }
+    if err := s.DeleteSnapshot("source", "snap"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestRestoreFromSnapshot(t *testing.T) {
+    s, _ := newForkSource(t)
+    if _, err := s.CreateSnapshot("source", &SnapshotRequest{Name: "snap"}); err != nil {
+        t.Fatal(err)
+    }
+
+    // the snapshotted volume is mounted as in the source sandbox
+    sb := &Sandbox{}
+    sb.Name = "restored"
+    sb.FromSnapshot = "snap"
+    if err := s.snapshotEnvironment(sb); err != nil {
+        t.Fatal(err)
+    }
+    if sb.Environment == "" || sb.Image == "" {
+        t.Fatalf("expected the environment of the snapshot, got %q %q", sb.Environment, sb.Image)
+    }
+    if err := s.restoreFromSnapshot(sb); err != nil {
+        t.Fatal(err)
+    }
+    if len(sb.Volumes) != 1 || sb.Volumes[0].Snapshot != "snap" || sb.Volumes[0].MountPath != "/workspace" || sb.Volumes[0].Size != "1Gi" {
+        t.Fatalf("unexpected restored volumes %+v", sb.Volumes)
+    }
+
+    // a volume of the same name keeps its mount path and size
+    sb = &Sandbox{}
+    sb.Name = "restored"
+    sb.FromSnapshot = "snap"
+    sb.Volumes = []SandboxVolume{{Name: "workspace", Size: "5Gi", MountPath: "/home", ClaimName: "kept"}}
+    if err := s.restoreFromSnapshot(sb); err != nil {
+        t.Fatal(err)
+    }
+    if v := sb.Volumes[0]; len(sb.Volumes) != 1 || v.Snapshot != "snap" || v.MountPath != "/home" || v.Size != "5Gi" || v.ClaimName != "" {
+        t.Fatalf("unexpected restored volumes %+v", sb.Volumes)
+    }
+
+    // the snapshots of other tenants are not found
+    sb = &Sandbox{Tenant: "team-b"}
+    sb.Name = "restored"
+    sb.FromSnapshot = "snap"
+    if err := s.restoreFromSnapshot(sb); err == nil || !strings.Contains(err.Error(), "not found") {
+        t.Fatalf("expected the snapshot of another tenant not to be found, got %v", err)
+    }
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    v1 "k8s.io/api/apps/v1"
+    v1core "k8s.io/api/core/v1"
+)
+
+// unrecoverableWaitingReasons are the container waiting reasons which do not heal without a spec change.
+var unrecoverableWaitingReasons = map[string]bool{
+    "ErrImagePull":               true,
+    "ImagePullBackOff":           true,
+    "InvalidImageName":           true,
+    "ErrImageNeverPull":          true,
+    "CrashLoopBackOff":           true,
+    "CreateContainerConfigError": true,
+    "CreateContainerError":       true,
+    "RunContainerError":          true,
+}
+
+// podFailure returns the reason and message of a pod which can not become Ready by itself, ok is false for healthy or still starting pods.
+func podFailure(pod *v1core.Pod) (reason string, message string, ok bool) {
+    if pod.Status.Phase == v1core.PodFailed {
+        return pod.Status.Reason, pod.Status.Message, true
+    }
+    for _, cond := range pod.Status.Conditions {
+        if cond.Type == v1core.PodScheduled && cond.Status == v1core.ConditionFalse && cond.Reason == v1core.PodReasonUnschedulable {
+            return cond.Reason, cond.Message, true
+        }
+    }
+    for _, cs := range pod.Status.ContainerStatuses {
+        if cs.State.Waiting == nil || !unrecoverableWaitingReasons[cs.State.Waiting.Reason] {
+            continue
+        }
+        reason, message = cs.State.Waiting.Reason, cs.State.Waiting.Message
+        // CrashLoopBackOff hides why the container died, e.g. OOMKilled
+        if last := cs.LastTerminationState.Terminated; last != nil && last.Reason != "" {
+            message = fmt.Sprintf("%s, last terminated with %s exit code %d", message, last.Reason, last.ExitCode)
+            if last.Reason == "OOMKilled" {
+                reason = last.Reason
+            }
+        }
+        return reason, message, true
+    }
+    return "", "", false
+}
+
+// podPending returns why a pod is not Ready yet, e.g. ContainerCreating.
+func podPending(pod *v1core.Pod) string {
+    for _, cs := range pod.Status.ContainerStatuses {
+        if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+            return cs.State.Waiting.Reason
+        }
+    }
+    return string(pod.Status.Phase)
+}
+
+// fillStatus derives the live status of the sandbox from its ReplicaSet, pods and the last activity recorded by the activator,
+// the status stored in the sandbox-data annotation only tells apart the user initiated transitions.
+func (s *Controller) fillStatus(sb *Sandbox, rs *v1.ReplicaSet) {
+    recorded := sb.Status
+    sb.clearLiveFields()
+    sb.CreatedAt = rs.CreationTimestamp.UTC().Format(time.RFC3339)
+
+    if s.activator != nil {
+        // the cache only, a list must not call kube-api per sandbox
+        if last := s.activator.CachedLastActiveTime(rs.Name); last > 0 {
+            sb.LastActiveAt = time.Unix(last, 0).UTC().Format(time.RFC3339)
+        }
+    }
+
+    if rs.DeletionTimestamp != nil {
+        sb.Status, sb.Reason = StatusDeleting, "Sandbox is being deleted"
+        return
+    }
+
+    if rs.Spec.Replicas != nil && *rs.Spec.Replicas == 0 {
+        if recorded == StatusPaused {
+            sb.Status, sb.Reason = StatusPaused, "Sandbox is paused, resume it to continue"
+        } else {
+            sb.Status, sb.Reason = StatusIdle, "Sandbox is scaled down, it is woken up by the next request"
+        }
+        return
+    }
+
+    var pending *v1core.Pod
+    for _, pod := range s.GetInstances(rs.Name) {
+        if pod.DeletionTimestamp != nil {
+            continue
+        }
+        if reason, message, failed := podFailure(pod); failed {
+            sb.Status, sb.Reason = StatusError, fmt.Sprintf("%s: %s", reason, message)
+            sb.Node = pod.Spec.NodeName
+            return
+        }
+        if activator.IsPodReady(pod) {
+            sb.Status, sb.Reason = StatusRunning, ""
+            sb.PodIP, sb.Node = pod.Status.PodIP, pod.Spec.NodeName
+            if s.isIdle(sb, rs) {
+                sb.Status, sb.Reason = StatusIdle, fmt.Sprintf("No activity for %d minutes, idle policy %s is going to be applied", sb.IdleTimeout, sb.IdlePolicy)
+            }
+            return
+        }
+        pending = pod
+    }
+
+    if recorded == StatusRestarting {
+        sb.Status, sb.Reason = StatusRestarting, "Sandbox is restarting"
+        return
+    }
+    sb.Status, sb.Reason = StatusCreating, "Waiting for the sandbox pod to be created"
+    if pending != nil {
+        sb.Reason = fmt.Sprintf("Waiting for the sandbox pod to be ready: %s", podPending(pending))
+        sb.Node = pending.Spec.NodeName
+    }
+}
+
+func (s *Controller) isIdle(sb *Sandbox, rs *v1.ReplicaSet) bool {
+    if sb.IdleTimeout <= 0 || sb.LastActiveAt == "" {
+        return false
+    }
+    last, err := time.Parse(time.RFC3339, sb.LastActiveAt)
+    if err != nil || rs.CreationTimestamp.After(last) {
+        return false
+    }
+    return time.Since(last) > time.Duration(sb.IdleTimeout)*time.Minute
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "strings"
+    "testing"
+
+    v1core "k8s.io/api/core/v1"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/runtime"
+)
+
+// waitingPod is a pod of the sandbox with a waiting container.
+func waitingPod(name string, reason string) *v1core.Pod {
+    pod := testReadyPod(name)
+    pod.Status = v1core.PodStatus{
+        Phase: v1core.PodPending,
+        ContainerStatuses: []v1core.ContainerStatus{{
+            Name:  "sandbox",
+            State: v1core.ContainerState{Waiting: &v1core.ContainerStateWaiting{Reason: reason, Message: "back-off"}},
+        }},
+    }
+    return pod
+}
+
+func TestFillStatus(t *testing.T) {
+    oom := waitingPod("sb", "CrashLoopBackOff")
+    oom.Status.ContainerStatuses[0].LastTerminationState.Terminated = &v1core.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}
+    unschedulable := testReadyPod("sb")
+    unschedulable.Status = v1core.PodStatus{Conditions: []v1core.PodCondition{{
+        Type: v1core.PodScheduled, Status: v1core.ConditionFalse, Reason: v1core.PodReasonUnschedulable, Message: "0/3 nodes are available",
+    }}}
+    deleting := testReadyPod("sb")
+    now := v1meta.Now()
+    deleting.DeletionTimestamp = &now
+
+    tests := []struct {
+        name      string
+        recorded  string
+        replicas  int32
+        deleting  bool
+        pods      []*v1core.Pod
+        status    string
+        reason    string
+        withPodIP bool
+    }{
+        {name: "ready", recorded: StatusRunning, replicas: 1, pods: []*v1core.Pod{testReadyPod("sb")}, status: StatusRunning, withPodIP: true},
+        {name: "deleting", recorded: StatusRunning, replicas: 1, deleting: true, pods: []*v1core.Pod{testReadyPod("sb")}, status: StatusDeleting},
+        {name: "paused", recorded: StatusPaused, replicas: 0, status: StatusPaused},
+        {name: "scaled down", recorded: StatusRunning, replicas: 0, status: StatusIdle},
+        {name: "no pod", recorded: StatusRunning, replicas: 1, status: StatusCreating, reason: "to be created"},
+        {name: "deleted pod", recorded: StatusRunning, replicas: 1, pods: []*v1core.Pod{deleting}, status: StatusCreating},
+        {name: "pending", recorded: StatusCreating, replicas: 1, pods: []*v1core.Pod{waitingPod("sb", "ContainerCreating")}, status: StatusCreating, reason: "ContainerCreating"},
+        {name: "restarting", recorded: StatusRestarting, replicas: 1, status: StatusRestarting},
+        {name: "image", recorded: StatusRunning, replicas: 1, pods: []*v1core.Pod{waitingPod("sb", "ImagePullBackOff")}, status: StatusError, reason: "ImagePullBackOff"},
+        {name: "oom", recorded: StatusRunning, replicas: 1, pods: []*v1core.Pod{oom}, status: StatusError, reason: "OOMKilled"},
+        {name: "unschedulable", recorded: StatusCreating, replicas: 1, pods: []*v1core.Pod{unschedulable}, status: StatusError, reason: "0/3 nodes"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rs := testSandboxReplicaSet(t, "sb", tt.recorded)
+            rs.Spec.Replicas = &tt.replicas
+            if tt.deleting {
+                rs.DeletionTimestamp = &now
+            }
+            objects := []runtime.Object{rs}
+            for _, pod := range tt.pods {
+                objects = append(objects, pod)
+            }
+            s, _ := newTestController(t, objects...)
+
+            sb, err := ParseSandboxData(rs)
+            if err != nil {
+                t.Fatal(err)
+            }
+            s.fillStatus(sb, rs)
+            if sb.Status != tt.status || !strings.Contains(sb.Reason, tt.reason) {
+                t.Fatalf("expected status %s with reason %q, got %s: %s", tt.status, tt.reason, sb.Status, sb.Reason)
+            }
+            if (sb.PodIP != "") != tt.withPodIP {
+                t.Fatalf("unexpected pod ip %q", sb.PodIP)
+            }
+        })
+    }
+}
@@ -0,0 +1,302 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "reflect"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/util/retry"
+    "k8s.io/klog/v2"
+)
+
+// SandboxPatch is the body of PATCH /sandbox/{name}, the fields left out are unchanged.
+// A null value in Env or Labels removes the key.
+type SandboxPatch struct {
+    Timeout     *int               `json:"timeout,omitempty"`
+    IdleTimeout *int               `json:"idle_timeout,omitempty"`
+    IdlePolicy  *string            `json:"idle_policy,omitempty"`
+    Env         map[string]*string `json:"env,omitempty"`
+    Labels      map[string]*string `json:"labels,omitempty"`
+    CPU         *string            `json:"cpu,omitempty"`
+    Memory      *string            `json:"memory,omitempty"`
+    CPULimit    *string            `json:"cpu_limit,omitempty"`
+    MemoryLimit *string            `json:"memory_limit,omitempty"`
+}
+
+func (p *SandboxPatch) apply(sb *Sandbox) {
+    if p.Timeout != nil {
+        sb.Timeout = *p.Timeout
+    }
+    if p.IdleTimeout != nil {
+        sb.IdleTimeout = *p.IdleTimeout
+    }
+    if p.IdlePolicy != nil {
+        sb.IdlePolicy = *p.IdlePolicy
+    }
+    for k, v := range p.Env {
+        if sb.Env == nil {
+            sb.Env = make(map[string]*string)
+        }
+        if v == nil {
+            delete(sb.Env, k)
+        } else {
+            sb.Env[k] = v
+        }
+    }
+    for k, v := range p.Labels {
+        if sb.Labels == nil {
+            sb.Labels = make(map[string]string)
+        }
+        if v == nil {
+            delete(sb.Labels, k)
+        } else {
+            sb.Labels[k] = *v
+        }
+    }
+    if p.CPU != nil {
+        sb.CPU = *p.CPU
+    }
+    if p.Memory != nil {
+        sb.Memory = *p.Memory
+    }
+    if p.CPULimit != nil {
+        sb.CPULimit = *p.CPULimit
+    }
+    if p.MemoryLimit != nil {
+        sb.MemoryLimit = *p.MemoryLimit
+    }
+}
+
+// patchOf returns the patch turning the current sandbox into the desired one, nil if they do not differ. Only the
+// fields of SandboxPatch are applied to an existing sandbox, the others are fixed at creation.
+func patchOf(current *Sandbox, desired *Sandbox) *SandboxPatch {
+    p := &SandboxPatch{
+        IdlePolicy:  changed(current.IdlePolicy, desired.IdlePolicy),
+        CPU:         changed(current.CPU, desired.CPU),
+        Memory:      changed(current.Memory, desired.Memory),
+        CPULimit:    changed(current.CPULimit, desired.CPULimit),
+        MemoryLimit: changed(current.MemoryLimit, desired.MemoryLimit),
+        Timeout:     changed(current.Timeout, desired.Timeout),
+        IdleTimeout: changed(current.IdleTimeout, desired.IdleTimeout),
+        Env:         map[string]*string{},
+        Labels:      map[string]*string{},
+    }
+    for k, v := range current.Env {
+        if d := desired.Env[k]; d == nil {
+            p.Env[k] = nil
+        } else if v == nil || *v != *d {
+            p.Env[k] = d
+        }
+    }
+    for k, d := range desired.Env {
+        if _, ok := current.Env[k]; !ok && d != nil {
+            p.Env[k] = d
+        }
+    }
+    for k, v := range current.Labels {
+        if d, ok := desired.Labels[k]; !ok {
+            p.Labels[k] = nil
+        } else if d != v {
+            p.Labels[k] = &d
+        }
+    }
+    for k, d := range desired.Labels {
+        if _, ok := current.Labels[k]; !ok {
+            d := d
+            p.Labels[k] = &d
+        }
+    }
+
+    if p.IdlePolicy == nil && p.CPU == nil && p.Memory == nil && p.CPULimit == nil && p.MemoryLimit == nil &&
+        p.Timeout == nil && p.IdleTimeout == nil && len(p.Env) == 0 && len(p.Labels) == 0 {
+        return nil
+    }
+    return p
+}
+
+func changed[T comparable](current T, desired T) *T {
+    if current == desired {
+        return nil
+    }
+    return &desired
+}
+
+// Update applies the patch to a running sandbox. Lifetime and idle settings are applied in place, labels are
+// relabeled in place, resources are resized in place where the cluster supports it and Env needs a pod restart.
+// With the CRD the patch is written to the Sandbox resource and applied by the reconciler.
+func (s *Controller) Update(name string, patch *SandboxPatch) (*Sandbox, error) {
+    if config.Cfg.SandboxCRDEnabled {
+        return s.updateResource(name, patch)
+    }
+
+    restart, err := s.applyPatch(name, patch)
+    if err != nil {
+        return nil, err
+    }
+    if restart {
+        if err := s.Restart(name, config.Cfg.SandboxActivationTimeout); err != nil {
+            return nil, fmt.Errorf("sandbox %s updated but %v", name, err)
+        }
+    }
+
+    return s.Get(name), nil
+}
+
+// applyPatch writes the patched sandbox to its ReplicaSet and the running pods, restart reports whether the pods
+// must be restarted to apply it.
+func (s *Controller) applyPatch(name string, patch *SandboxPatch) (restart bool, err error) {
+    var before, after *Sandbox
+    var scaledDown bool
+    namespace := s.namespace(name)
+    err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+        rs, err := s.client.AppsV1().ReplicaSets(namespace).Get(context.TODO(), name, v1meta.GetOptions{})
+        if err != nil {
+            return err
+        }
+        if before, err = ParseSandboxData(rs); err != nil {
+            return err
+        }
+        after, _ = ParseSandboxData(rs)
+        scaledDown = before.Status == StatusPaused || rs.Spec.Replicas == nil || *rs.Spec.Replicas == 0
+        patch.apply(after)
+        after.Make()
+        if err := after.Validate(); err != nil {
+            return err
+        }
+        if err := s.updateQuota(after); err != nil {
+            return err
+        }
+
+        // the pod template is rendered again, so the ReplicaSet starts the next pods with the new spec
+        rendered, err := buildReplicaSet(after)
+        if err != nil {
+            return err
+        }
+        for k := range before.Labels {
+            if _, ok := after.Labels[k]; !ok {
+                delete(rs.Labels, k)
+            }
+        }
+        applyLabels(rs, after.Labels)
+        rs.Spec.Template.Labels = rendered.Spec.Template.Labels
+        rs.Spec.Template.Spec = rendered.Spec.Template.Spec
+
+        raw, err := json.Marshal(after)
+        if err != nil {
+            return err
+        }
+        rs.Annotations[SandboxDataAnnotation] = string(raw)
+        _, err = s.client.AppsV1().ReplicaSets(namespace).Update(context.TODO(), rs, v1meta.UpdateOptions{})
+        return err
+    })
+    if err != nil {
+        return false, err
+    }
+
+    if !reflect.DeepEqual(before.Labels, after.Labels) {
+        s.relabelPods(name, before.Labels, after.Labels)
+    }
+
+    if scaledDown {
+        // no pods to restart or resize, the pods of the resume start from the rendered template
+        return false, nil
+    }
+    restart = !reflect.DeepEqual(before.Env, after.Env)
+    resized := before.CPU != after.CPU || before.Memory != after.Memory || before.CPULimit != after.CPULimit || before.MemoryLimit != after.MemoryLimit
+    if resized && !restart {
+        if err := s.resizePods(name, after); err != nil {
+            klog.Infof("In-place resize of sandbox %s is not supported, restart it: %v", name, err)
+            restart = true
+        }
+    }
+    return restart, nil
+}
+
+// updateResource writes the patched spec to the Sandbox resource and waits until the reconciler applied it.
+func (s *Controller) updateResource(name string, patch *SandboxPatch) (*Sandbox, error) {
+    res, err := s.fetchResource(name)
+    if err != nil {
+        return nil, err
+    }
+    after := res.Spec.Sandbox
+    after.Name = name
+    patch.apply(&after)
+    after.Make()
+    if err := after.Validate(); err != nil {
+        return nil, err
+    }
+    if err := s.updateQuota(&after); err != nil {
+        return nil, err
+    }
+    if err := s.updateResourceSpec(&after, res.Spec.Paused); err != nil {
+        return nil, fmt.Errorf("update sandbox resource fail: %v", err)
+    }
+
+    err = s.waitApplied(name, func(sb *Sandbox) bool {
+        return patchOf(sb, &after) == nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return s.Get(name), nil
+}
+
+func (s *Controller) relabelPods(name string, before map[string]string, after map[string]string) {
+    labels := make(map[string]interface{})
+    for k := range before {
+        if _, ok := after[k]; !ok {
+            labels[k] = nil
+        }
+    }
+    for k, v := range after {
+        labels[k] = v
+    }
+    patch, _ := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"labels": labels}})
+    for _, pod := range s.GetInstances(name) {
+        _, err := s.client.CoreV1().Pods(pod.Namespace).Patch(context.TODO(), pod.Name, types.MergePatchType, patch, v1meta.PatchOptions{})
+        if err != nil {
+            klog.Errorf("Failed to relabel pod %s of sandbox %s: %v", pod.Name, name, err)
+        }
+    }
+}
+
+// resizePods patches the container resources of the running pods by their resize subresource, it needs the
+// InPlacePodVerticalScaling feature.
+func (s *Controller) resizePods(name string, sb *Sandbox) error {
+    rendered, err := buildReplicaSet(sb)
+    if err != nil {
+        return err
+    }
+    for _, pod := range s.GetInstances(name) {
+        containers := make([]map[string]interface{}, 0, len(rendered.Spec.Template.Spec.Containers))
+        for _, c := range rendered.Spec.Template.Spec.Containers {
+            containers = append(containers, map[string]interface{}{"name": c.Name, "resources": c.Resources})
+        }
+        patch, _ := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"containers": containers}})
+        _, err := s.client.CoreV1().Pods(pod.Namespace).Patch(context.TODO(), pod.Name, types.StrategicMergePatchType, patch, v1meta.PatchOptions{}, "resize")
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
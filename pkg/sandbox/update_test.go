@@ -0,0 +1,152 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "testing"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1 "k8s.io/api/apps/v1"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func stringPtr(s string) *string {
+    return &s
+}
+
+func intPtr(i int) *int {
+    return &i
+}
+
+// labeledSandbox is the ReplicaSet of a running sandbox with labels and an env.
+func labeledSandbox(t *testing.T, name string) *v1.ReplicaSet {
+    sb := &Sandbox{Status: StatusRunning, Labels: map[string]string{"a": "1", "b": "2"}}
+    sb.Name = name
+    sb.Env = map[string]*string{"X": stringPtr("1")}
+    sb.Make()
+    rs, err := buildReplicaSet(sb)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return rs
+}
+
+func TestApplyPatch(t *testing.T) {
+    pod := testReadyPod("sb")
+    pod.Labels["a"], pod.Labels["b"] = "1", "2"
+    s, kube := newTestController(t, labeledSandbox(t, "sb"), pod)
+
+    restart, err := s.applyPatch("sb", &SandboxPatch{
+        Timeout: intPtr(120),
+        Labels:  map[string]*string{"a": nil, "c": stringPtr("3")},
+        Env:     map[string]*string{"Y": stringPtr("2")},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !restart {
+        t.Fatal("expected a changed env to restart the pods")
+    }
+
+    rs, err := kube.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Get(context.TODO(), "sb", v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    sb, err := ParseSandboxData(rs)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if sb.Timeout != 120 || len(sb.Env) != 2 || *sb.Env["Y"] != "2" {
+        t.Fatalf("expected the patch to be stored, got timeout %d and env %v", sb.Timeout, sb.Env)
+    }
+    if _, ok := rs.Labels["a"]; ok || rs.Labels["b"] != "2" || rs.Labels["c"] != "3" {
+        t.Fatalf("unexpected labels of the replicaset %v", rs.Labels)
+    }
+    found := false
+    for _, env := range sandboxContainer(rs).Env {
+        found = found || (env.Name == "Y" && env.Value == "2")
+    }
+    if !found {
+        t.Fatal("expected the pod template to have the new env")
+    }
+
+    relabeled := listPods(t, kube)["sb-pod"].Labels
+    if _, ok := relabeled["a"]; ok || relabeled["c"] != "3" || relabeled["sandbox"] != "sb" {
+        t.Fatalf("unexpected labels of the running pod %v", relabeled)
+    }
+}
+
+func TestApplyPatchScaledDown(t *testing.T) {
+    rs := labeledSandbox(t, "sb")
+    zero := int32(0)
+    rs.Spec.Replicas = &zero
+    s, kube := newTestController(t, rs)
+
+    // the pods of the next resume get the new resources, there is nothing to restart
+    restart, err := s.applyPatch("sb", &SandboxPatch{CPU: stringPtr("500m"), Env: map[string]*string{"X": nil}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if restart {
+        t.Fatal("expected no restart of a scaled down sandbox")
+    }
+    updated, err := kube.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Get(context.TODO(), "sb", v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cpu := sandboxContainer(updated).Resources.Requests.Cpu().String(); cpu != "500m" {
+        t.Fatalf("expected the template to request 500m cpu, got %s", cpu)
+    }
+}
+
+func TestApplyPatchInvalid(t *testing.T) {
+    s, kube := newTestController(t, labeledSandbox(t, "sb"))
+
+    if _, err := s.applyPatch("sb", &SandboxPatch{IdlePolicy: stringPtr("hibernate")}); err == nil {
+        t.Fatal("expected an invalid idle policy to fail")
+    }
+    for _, action := range kube.Actions() {
+        if action.GetVerb() == "update" {
+            t.Fatalf("expected no update, got %v", action)
+        }
+    }
+}
+
+func TestPatchOf(t *testing.T) {
+    current := &Sandbox{Labels: map[string]string{"a": "1", "b": "2"}}
+    current.Env = map[string]*string{"X": stringPtr("1"), "Y": stringPtr("1")}
+    current.Make()
+    if p := patchOf(current, current); p != nil {
+        t.Fatalf("expected no patch of the same sandbox, got %+v", p)
+    }
+
+    desired := *current
+    desired.Labels = map[string]string{"a": "1", "b": "3"}
+    desired.Env = map[string]*string{"X": stringPtr("1"), "Z": stringPtr("1")}
+    desired.IdleTimeout = current.IdleTimeout + 5
+    p := patchOf(current, &desired)
+    if p == nil || p.IdleTimeout == nil || *p.IdleTimeout != desired.IdleTimeout || p.Timeout != nil || p.CPU != nil {
+        t.Fatalf("unexpected patch %+v", p)
+    }
+    if len(p.Labels) != 1 || *p.Labels["b"] != "3" {
+        t.Fatalf("unexpected labels of the patch %v", p.Labels)
+    }
+    if len(p.Env) != 2 || p.Env["Y"] != nil || *p.Env["Z"] != "1" {
+        t.Fatalf("unexpected env of the patch %v", p.Env)
+    }
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "fmt"
+    "path"
+    "strings"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1 "k8s.io/api/apps/v1"
+    v1core "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    "k8s.io/apimachinery/pkg/api/resource"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/util/validation"
+    "k8s.io/klog/v2"
+)
+
+// DefaultVolumeName is the name of a sandbox volume without name, mounted at the Workdir.
+const DefaultVolumeName = "workspace"
+
+// SandboxVolume is a PersistentVolumeClaim provisioned for the sandbox, it survives pod restarts and scale downs.
+type SandboxVolume struct {
+    // Name of the volume, unique within the sandbox. default workspace
+    Name string `json:"name,omitempty"`
+
+    // Requested storage, e.g. 10Gi.
+    Size string `json:"size"`
+
+    // StorageClass of the claim, the cluster default storage class when empty.
+    StorageClass string `json:"storage_class,omitempty"`
+
+    // Path the volume is mounted at in the sandbox container. default Workdir
+    MountPath string `json:"mount_path,omitempty"`
+
+    // Keep the claim when the sandbox is deleted, a new sandbox of the same name or with ClaimName re-attaches it.
+    // A re-attached claim is always kept.
+    Retain bool `json:"retain,omitempty"`
+
+    // Attach this existing sandbox volume instead of the claim named <sandbox>-<volume>, e.g. a volume retained by another
+    // sandbox. Only claims labelled owner=agent-sandbox are attached.
+    ClaimName string `json:"claim_name,omitempty"`
+
+    // Provision the claim from this VolumeSnapshot, see Sandbox.FromSnapshot.
+    Snapshot string `json:"snapshot,omitempty"`
+
+    // Provision the claim as a clone of this claim, e.g. the volume of a forked sandbox.
+    CloneFrom string `json:"clone_from,omitempty"`
+}
+
+// dataSource returns the snapshot or claim the volume is provisioned from, nil for an empty volume.
+func (v *SandboxVolume) dataSource() *v1core.TypedLocalObjectReference {
+    switch {
+    case v.Snapshot != "":
+        apiGroup := VolumeSnapshotGVR.Group
+        return &v1core.TypedLocalObjectReference{APIGroup: &apiGroup, Kind: "VolumeSnapshot", Name: v.Snapshot}
+    case v.CloneFrom != "":
+        return &v1core.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: v.CloneFrom}
+    }
+    return nil
+}
+
+// claimName returns the name of the PersistentVolumeClaim of the volume.
+func (v *SandboxVolume) claimName(sandbox string) string {
+    if v.ClaimName != "" {
+        return v.ClaimName
+    }
+    return sandbox + "-" + v.Name
+}
+
+func validateVolumes(sb *Sandbox) error {
+    names := make(map[string]bool)
+    mountPaths := make(map[string]bool)
+    for _, v := range sb.Volumes {
+        if errs := validation.IsDNS1123Label(v.Name); len(errs) > 0 {
+            return fmt.Errorf("invalid volume name %q: %s", v.Name, strings.Join(errs, ", "))
+        }
+        if names[v.Name] {
+            return fmt.Errorf("duplicate volume %s", v.Name)
+        }
+        names[v.Name] = true
+
+        if v.MountPath == "" {
+            return fmt.Errorf("volume %s has no mount_path, set it or the workdir", v.Name)
+        }
+        if !path.IsAbs(v.MountPath) {
+            return fmt.Errorf("invalid mount_path %q of volume %s, must be an absolute path", v.MountPath, v.Name)
+        }
+        if mountPaths[path.Clean(v.MountPath)] {
+            return fmt.Errorf("duplicate mount_path %s", v.MountPath)
+        }
+        mountPaths[path.Clean(v.MountPath)] = true
+
+        if v.ClaimName == "" || v.Size != "" {
+            if _, err := resource.ParseQuantity(v.Size); err != nil {
+                return fmt.Errorf("invalid size %q of volume %s: %v", v.Size, v.Name, err)
+            }
+        }
+        if v.Snapshot != "" && v.CloneFrom != "" {
+            return fmt.Errorf("volume %s can not have both snapshot and clone_from", v.Name)
+        }
+        if errs := validation.IsDNS1123Subdomain(v.claimName(sb.Name)); len(errs) > 0 {
+            return fmt.Errorf("invalid claim name %q of volume %s: %s", v.claimName(sb.Name), v.Name, strings.Join(errs, ", "))
+        }
+    }
+    return nil
+}
+
+// applyVolumes mounts the claims of the sandbox volumes in the sandbox container.
+func applyVolumes(rs *v1.ReplicaSet, sb *Sandbox) {
+    c := sandboxContainer(rs)
+    if c == nil {
+        return
+    }
+    spec := &rs.Spec.Template.Spec
+    for _, v := range sb.Volumes {
+        spec.Volumes = append(spec.Volumes, v1core.Volume{
+            Name: v.Name,
+            VolumeSource: v1core.VolumeSource{
+                PersistentVolumeClaim: &v1core.PersistentVolumeClaimVolumeSource{ClaimName: v.claimName(sb.Name)},
+            },
+        })
+        c.VolumeMounts = append(c.VolumeMounts, v1core.VolumeMount{Name: v.Name, MountPath: v.MountPath})
+    }
+}
+
+// ensureVolumes creates the missing claims of the sandbox volumes, owned by owner unless retained. An existing sandbox
+// volume is re-attached without owner, it is refused while it belongs to another sandbox.
+func (s *Controller) ensureVolumes(sb *Sandbox, owner v1meta.OwnerReference) error {
+    namespace := config.TenantNamespace(sb.Tenant)
+    claims := s.client.CoreV1().PersistentVolumeClaims(namespace)
+    for _, v := range sb.Volumes {
+        name := v.claimName(sb.Name)
+        pvc, err := claims.Get(context.TODO(), name, v1meta.GetOptions{})
+        if err == nil && !auth.Allowed(sb.Tenant, pvc.Labels[auth.TenantLabel]) {
+            return fmt.Errorf("volume claim %s not found", name)
+        }
+        if apierrors.IsNotFound(err) {
+            if v.ClaimName != "" && v.Size == "" {
+                return fmt.Errorf("volume claim %s not found", name)
+            }
+            if err := s.checkDataSource(sb, v); err != nil {
+                return err
+            }
+            if _, err := claims.Create(context.TODO(), newVolumeClaim(sb, v, owner), v1meta.CreateOptions{}); err != nil {
+                return fmt.Errorf("create volume claim %s fail: %v", name, err)
+            }
+            klog.Infof("Created volume claim %s of sandbox %s", name, sb.Name)
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        if pvc.Labels["owner"] != "agent-sandbox" {
+            return fmt.Errorf("volume claim %s is not a sandbox volume", name)
+        }
+        if ds := v.dataSource(); ds != nil && (pvc.Spec.DataSource == nil || pvc.Spec.DataSource.Kind != ds.Kind || pvc.Spec.DataSource.Name != ds.Name) {
+            return fmt.Errorf("volume claim %s already exists, %s %s can not be restored into it", name, ds.Kind, ds.Name)
+        }
+
+        if user := pvc.Labels["sandbox"]; user != "" && user != sb.Name {
+            if _, err := s.client.AppsV1().ReplicaSets(namespace).Get(context.TODO(), user, v1meta.GetOptions{}); err == nil {
+                return fmt.Errorf("volume claim %s is in use by sandbox %s", name, user)
+            }
+        }
+        for _, ref := range pvc.OwnerReferences {
+            if ref.UID != owner.UID {
+                return fmt.Errorf("volume claim %s is owned by %s %s", name, ref.Kind, ref.Name)
+            }
+        }
+
+        if pvc.Labels["sandbox"] == sb.Name && (sb.Tenant == "" || pvc.Labels[auth.TenantLabel] == sb.Tenant) {
+            continue
+        }
+        // a re-attached claim is never owned by the sandbox, it is kept when the sandbox is deleted
+        pvc.Labels["sandbox"] = sb.Name
+        if sb.Tenant != "" {
+            pvc.Labels[auth.TenantLabel] = sb.Tenant
+        }
+        if _, err := claims.Update(context.TODO(), pvc, v1meta.UpdateOptions{}); err != nil {
+            return fmt.Errorf("attach volume claim %s fail: %v", name, err)
+        }
+        klog.Infof("Attached volume claim %s to sandbox %s", name, sb.Name)
+    }
+    return nil
+}
+
+// checkDataSource refuses to provision a volume from a snapshot or claim of another tenant.
+func (s *Controller) checkDataSource(sb *Sandbox, v SandboxVolume) error {
+    if sb.Tenant == "" {
+        return nil
+    }
+    switch {
+    case v.Snapshot != "":
+        _, source, err := s.GetSnapshot(v.Snapshot)
+        if err != nil {
+            return err
+        }
+        if !auth.Allowed(sb.Tenant, source.Tenant) {
+            return fmt.Errorf("snapshot %s not found", v.Snapshot)
+        }
+    case v.CloneFrom != "":
+        pvc, err := s.client.CoreV1().PersistentVolumeClaims(config.TenantNamespace(sb.Tenant)).Get(context.TODO(), v.CloneFrom, v1meta.GetOptions{})
+        if err != nil {
+            return fmt.Errorf("get volume claim %s fail: %v", v.CloneFrom, err)
+        }
+        if !auth.Allowed(sb.Tenant, pvc.Labels[auth.TenantLabel]) {
+            return fmt.Errorf("volume claim %s not found", v.CloneFrom)
+        }
+    }
+    return nil
+}
+
+func newVolumeClaim(sb *Sandbox, v SandboxVolume, owner v1meta.OwnerReference) *v1core.PersistentVolumeClaim {
+    pvc := &v1core.PersistentVolumeClaim{
+        ObjectMeta: v1meta.ObjectMeta{
+            Name:      v.claimName(sb.Name),
+            Namespace: config.TenantNamespace(sb.Tenant),
+            Labels: map[string]string{
+                "sandbox": sb.Name,
+                "owner":   "agent-sandbox",
+            },
+        },
+        Spec: v1core.PersistentVolumeClaimSpec{
+            AccessModes: []v1core.PersistentVolumeAccessMode{v1core.ReadWriteOnce},
+            Resources: v1core.ResourceRequirements{
+                Requests: v1core.ResourceList{v1core.ResourceStorage: resource.MustParse(v.Size)},
+            },
+        },
+    }
+    if sb.Tenant != "" {
+        pvc.Labels[auth.TenantLabel] = sb.Tenant
+    }
+    if v.StorageClass != "" {
+        storageClass := v.StorageClass
+        pvc.Spec.StorageClassName = &storageClass
+    }
+    pvc.Spec.DataSource = v.dataSource()
+    if !v.Retain {
+        pvc.OwnerReferences = []v1meta.OwnerReference{owner}
+    }
+    return pvc
+}
+
+// replicaSetOwnerReference makes the sandbox volumes garbage collected with the ReplicaSet.
+func replicaSetOwnerReference(rs *v1.ReplicaSet) v1meta.OwnerReference {
+    blockOwnerDeletion := true
+    return v1meta.OwnerReference{
+        APIVersion:         "apps/v1",
+        Kind:               "ReplicaSet",
+        Name:               rs.Name,
+        UID:                rs.UID,
+        BlockOwnerDeletion: &blockOwnerDeletion,
+    }
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+    "context"
+    "strings"
+    "testing"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/auth"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    v1core "k8s.io/api/core/v1"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateVolumes(t *testing.T) {
+    tests := map[string]struct {
+        volumes []SandboxVolume
+        err     string
+    }{
+        "valid":          {volumes: []SandboxVolume{{Name: "a", Size: "1Gi", MountPath: "/a"}, {Name: "b", ClaimName: "kept", MountPath: "/b"}}},
+        "name":           {volumes: []SandboxVolume{{Name: "A_", Size: "1Gi", MountPath: "/a"}}, err: "invalid volume name"},
+        "duplicate":      {volumes: []SandboxVolume{{Name: "a", Size: "1Gi", MountPath: "/a"}, {Name: "a", Size: "1Gi", MountPath: "/b"}}, err: "duplicate volume"},
+        "mount path":     {volumes: []SandboxVolume{{Name: "a", Size: "1Gi"}}, err: "no mount_path"},
+        "relative":       {volumes: []SandboxVolume{{Name: "a", Size: "1Gi", MountPath: "work"}}, err: "absolute path"},
+        "same path":      {volumes: []SandboxVolume{{Name: "a", Size: "1Gi", MountPath: "/a"}, {Name: "b", Size: "1Gi", MountPath: "/a/"}}, err: "duplicate mount_path"},
+        "size":           {volumes: []SandboxVolume{{Name: "a", Size: "big", MountPath: "/a"}}, err: "invalid size"},
+        "snapshot clone": {volumes: []SandboxVolume{{Name: "a", Size: "1Gi", MountPath: "/a", Snapshot: "s", CloneFrom: "c"}}, err: "both snapshot and clone_from"},
+    }
+    for name, tt := range tests {
+        sb := &Sandbox{}
+        sb.Name = "sb"
+        sb.Volumes = tt.volumes
+        err := validateVolumes(sb)
+        if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+            t.Errorf("%s: expected error %q, got %v", name, tt.err, err)
+        }
+    }
+}
+
+// sandboxClaim is a volume claim of a sandbox.
+func sandboxClaim(name string, sandbox string, tenant string) *v1core.PersistentVolumeClaim {
+    pvc := &v1core.PersistentVolumeClaim{ObjectMeta: v1meta.ObjectMeta{
+        Name:      name,
+        Namespace: config.Cfg.SandboxNamespace,
+        Labels:    map[string]string{"owner": "agent-sandbox", "sandbox": sandbox},
+    }}
+    if tenant != "" {
+        pvc.Labels[auth.TenantLabel] = tenant
+    }
+    return pvc
+}
+
+func TestEnsureVolumes(t *testing.T) {
+    foreign := sandboxClaim("foreign", "", "")
+    foreign.Labels = nil
+    s, kube := newTestController(t,
+        sandboxClaim("kept", "deleted", "team-a"),
+        sandboxClaim("used", "running", "team-a"),
+        sandboxClaim("other-tenant", "deleted", "team-b"),
+        foreign,
+        testSandboxReplicaSet(t, "running", StatusRunning),
+    )
+    owner := v1meta.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "sb", UID: "sb-uid"}
+    sb := &Sandbox{Tenant: "team-a"}
+    sb.Name = "sb"
+    sb.Volumes = []SandboxVolume{
+        {Name: "workspace", Size: "1Gi", MountPath: "/workspace"},
+        {Name: "cache", Size: "1Gi", MountPath: "/cache", Retain: true},
+        {Name: "kept", ClaimName: "kept", MountPath: "/kept"},
+    }
+    if err := s.ensureVolumes(sb, owner); err != nil {
+        t.Fatal(err)
+    }
+
+    claims := kube.CoreV1().PersistentVolumeClaims(config.Cfg.SandboxNamespace)
+    for name, owned := range map[string]bool{"sb-workspace": true, "sb-cache": false, "kept": false} {
+        pvc, err := claims.Get(context.TODO(), name, v1meta.GetOptions{})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if (len(pvc.OwnerReferences) == 1) != owned || pvc.Labels["sandbox"] != "sb" || pvc.Labels[auth.TenantLabel] != "team-a" {
+            t.Errorf("unexpected claim %s with owners %v and labels %v", name, pvc.OwnerReferences, pvc.Labels)
+        }
+    }
+
+    for claim, expected := range map[string]string{
+        "used":         "in use by sandbox running",
+        "other-tenant": "not found",
+        "foreign":      "not found",
+        "missing":      "not found",
+    } {
+        sb.Volumes = []SandboxVolume{{Name: "v", ClaimName: claim, MountPath: "/v"}}
+        if err := s.ensureVolumes(sb, owner); err == nil || !strings.Contains(err.Error(), expected) {
+            t.Errorf("expected the claim %s to be refused with %q, got %v", claim, expected, err)
+        }
+    }
+    // without tenants the other claims are not hidden
+    sb.Tenant = ""
+    sb.Volumes = []SandboxVolume{{Name: "v", ClaimName: "foreign", MountPath: "/v"}}
+    if err := s.ensureVolumes(sb, owner); err == nil || !strings.Contains(err.Error(), "not a sandbox volume") {
+        t.Errorf("expected the claim foreign to be refused, got %v", err)
+    }
+}
+
+func TestApplyVolumes(t *testing.T) {
+    sb := &Sandbox{}
+    sb.Name = "sb"
+    sb.Volumes = []SandboxVolume{{Name: "workspace", Size: "1Gi", MountPath: "/workspace"}, {Name: "data", ClaimName: "kept", MountPath: "/data"}}
+    sb.Make()
+    rs, err := buildReplicaSet(sb)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var claims []string
+    for _, v := range rs.Spec.Template.Spec.Volumes {
+        claims = append(claims, v.PersistentVolumeClaim.ClaimName)
+    }
+    if strings.Join(claims, ",") != "sb-workspace,kept" || len(sandboxContainer(rs).VolumeMounts) != 2 {
+        t.Fatalf("unexpected volumes %v", rs.Spec.Template.Spec.Volumes)
+    }
+}
@@ -18,24 +18,49 @@ package scaler
 
 import (
     "context"
+    "time"
 
     "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/sandbox"
+    "k8s.io/apimachinery/pkg/util/wait"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/record"
+    "k8s.io/klog/v2"
+    kubeclient "knative.dev/pkg/client/injection/kube/client"
+)
+
+const (
+    ComponentName = "agent-sandbox-scaler"
+
+    // ScalingInterval is how often the sandboxes are checked against their lifecycle limits.
+    ScalingInterval = 30 * time.Second
 )
 
 type Scaler struct {
-    rootCtx   context.Context
-    activator *activator.Activator
+    rootCtx    context.Context
+    activator  *activator.Activator
+    controller *sandbox.Controller
+    client     kubernetes.Interface
+    recorder   record.EventRecorder
 }
 
-func NewScaler(ctx context.Context) *Scaler {
-    a := activator.NewActivator(ctx)
+// NewScaler shares the Activator with the router and handlers, so the idle check sees their last events.
+func NewScaler(ctx context.Context, a *activator.Activator) *Scaler {
     scaler := &Scaler{
-        rootCtx:   ctx,
-        activator: a,
+        rootCtx:    ctx,
+        activator:  a,
+        controller: sandbox.NewController(ctx, a),
+        client:     kubeclient.Get(ctx),
+        recorder:   getRecorder(ctx),
     }
     return scaler
 }
 
+// RunScaling runs the lifecycle checks every ScalingInterval, it blocks until rootCtx is done.
 func (s *Scaler) RunScaling() {
-
+    klog.Infof("Starting sandbox scaler, interval %s", ScalingInterval)
+    wait.Until(func() {
+        s.reapExpired()
+        s.scaleIdle()
+    }, ScalingInterval, s.rootCtx.Done())
 }
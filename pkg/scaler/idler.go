@@ -0,0 +1,122 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scaler
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/sandbox"
+    v1 "k8s.io/api/apps/v1"
+    corev1 "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    "k8s.io/apimachinery/pkg/labels"
+    "k8s.io/klog/v2"
+)
+
+const (
+    // EventReasonIdle is the event reason recorded on the ReplicaSet when the sandbox reached its IdleTimeout.
+    EventReasonIdle = "SandboxIdle"
+)
+
+// scaleIdle applies the IdlePolicy to the running sandboxes which had no activity for longer than their IdleTimeout.
+func (s *Scaler) scaleIdle() {
+    selector, _ := labels.Parse("owner=agent-sandbox")
+    rss, err := activator.ReplicaSets(s.rootCtx).Lister().List(selector)
+    if err != nil {
+        klog.Errorf("Failed to list sandboxes for idle check: %v", err)
+        return
+    }
+
+    now := time.Now()
+    for _, rs := range rss {
+        // skip the deleting, already scaled down and not yet ready sandboxes
+        if rs.DeletionTimestamp != nil || rs.Spec.Replicas == nil || *rs.Spec.Replicas == 0 || rs.Status.ReadyReplicas == 0 {
+            continue
+        }
+        sb, err := sandbox.ParseSandboxData(rs)
+        if err != nil {
+            klog.Errorf("Skip idle check of sandbox %s: %v", rs.Name, err)
+            continue
+        }
+
+        idleTimeout := sb.IdleTimeout
+        if idleTimeout <= 0 {
+            idleTimeout = sandbox.DefaultSandbox.IdleTimeout
+        }
+        lastActive := s.lastActiveTime(rs)
+        if now.Sub(lastActive) < time.Duration(idleTimeout)*time.Minute {
+            continue
+        }
+
+        policy := sb.IdlePolicy
+        if policy == "" {
+            policy = sandbox.DefaultSandbox.IdlePolicy
+        }
+        klog.Infof("Sandbox %s idle since %s, idle timeout %d minutes, apply policy %s", rs.Name, lastActive, idleTimeout, policy)
+        s.recorder.Eventf(rs, corev1.EventTypeNormal, EventReasonIdle,
+            "Sandbox idle since %s exceeded the idle timeout of %d minutes, apply idle policy %s", lastActive.Format(time.RFC3339), idleTimeout, policy)
+
+        if err := s.applyIdlePolicy(rs.Name, policy); err != nil && !apierrors.IsNotFound(err) {
+            klog.Errorf("Failed to apply idle policy %s to sandbox %s: %v", policy, rs.Name, err)
+        }
+    }
+}
+
+func (s *Scaler) applyIdlePolicy(name string, policy string) error {
+    switch policy {
+    case sandbox.IdlePolicyScaleDown:
+        if err := s.controller.SetStatus(name, sandbox.StatusIdle); err != nil {
+            return err
+        }
+        return s.controller.Scale(name, 0)
+    case sandbox.IdlePolicyDelete:
+        // the idle status publishes the idle event, before the deleted one
+        if err := s.controller.SetStatus(name, sandbox.StatusIdle); err != nil {
+            return err
+        }
+        if err := s.controller.Delete(name); err != nil {
+            return err
+        }
+        s.activator.Forget(name)
+        return nil
+    default:
+        return fmt.Errorf("unknown idle policy %q", policy)
+    }
+}
+
+// lastActiveTime is the latest of the last request or response through the activator
+// and the creation of the sandbox pods, so a freshly created or resumed sandbox is not idle.
+func (s *Scaler) lastActiveTime(rs *v1.ReplicaSet) time.Time {
+    last := rs.CreationTimestamp.Time
+    if t := s.activator.GetLastActiveTime(rs.Name); t > 0 && time.Unix(t, 0).After(last) {
+        last = time.Unix(t, 0)
+    }
+
+    selector, _ := labels.Parse("sandbox=" + rs.Name)
+    pods, err := activator.Pods(s.rootCtx).Lister().Pods(rs.Namespace).List(selector)
+    if err != nil {
+        return last
+    }
+    for _, pod := range pods {
+        if pod.CreationTimestamp.After(last) {
+            last = pod.CreationTimestamp.Time
+        }
+    }
+    return last
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scaler
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    "github.com/agent-sandbox/agent-sandbox/pkg/sandbox"
+    v1 "k8s.io/api/apps/v1"
+    corev1 "k8s.io/api/core/v1"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    k8stesting "k8s.io/client-go/testing"
+)
+
+// idleSandbox is a running sandbox with the idle settings.
+func idleSandbox(idleTimeout int, policy string) *sandbox.Sandbox {
+    sb := &sandbox.Sandbox{Status: sandbox.StatusRunning}
+    sb.Timeout = 24 * 60
+    sb.IdleTimeout = idleTimeout
+    sb.IdlePolicy = policy
+    return sb
+}
+
+func TestScaleIdle(t *testing.T) {
+    deleted := testReplicaSet(t, "deleted", idleSandbox(30, sandbox.IdlePolicyDelete), time.Hour)
+    scaled := testReplicaSet(t, "scaled", idleSandbox(30, sandbox.IdlePolicyScaleDown), time.Hour)
+    young := testReplicaSet(t, "young", idleSandbox(30, sandbox.IdlePolicyDelete), 10*time.Minute)
+    // a restarted pod counts as activity
+    restarted := testReplicaSet(t, "restarted", idleSandbox(30, sandbox.IdlePolicyDelete), time.Hour)
+    pod := &corev1.Pod{ObjectMeta: v1meta.ObjectMeta{
+        Name:              "restarted-pod",
+        Namespace:         restarted.Namespace,
+        Labels:            map[string]string{"owner": "agent-sandbox", "sandbox": "restarted"},
+        CreationTimestamp: v1meta.NewTime(time.Now().Add(-5 * time.Minute)),
+    }}
+    // the scaled down and not yet ready sandboxes are skipped
+    down := testReplicaSet(t, "down", idleSandbox(30, sandbox.IdlePolicyDelete), time.Hour)
+    zero := int32(0)
+    down.Spec.Replicas = &zero
+    starting := testReplicaSet(t, "starting", idleSandbox(30, sandbox.IdlePolicyDelete), time.Hour)
+    starting.Status.ReadyReplicas = 0
+
+    s := newTestScaler(t, []*v1.ReplicaSet{deleted, scaled, young, restarted, down, starting}, pod)
+    s.scaleIdle()
+
+    for name, expected := range map[string]bool{"deleted": false, "scaled": true, "young": true, "restarted": true, "down": true, "starting": true} {
+        if got := s.exists(t, name); got != expected {
+            t.Errorf("expected sandbox %s to exist %t, got %t", name, expected, got)
+        }
+    }
+
+    rs, err := s.kube.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Get(context.TODO(), "scaled", v1meta.GetOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if *rs.Spec.Replicas != 0 {
+        t.Fatalf("expected the scaledown policy to scale to 0, got %d", *rs.Spec.Replicas)
+    }
+    sb, err := sandbox.ParseSandboxData(rs)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if sb.Status != sandbox.StatusIdle {
+        t.Fatalf("expected the scaled down sandbox to be idle, got %s", sb.Status)
+    }
+}
+
+func TestApplyIdlePolicyUnknown(t *testing.T) {
+    rs := testReplicaSet(t, "idle", idleSandbox(30, "hibernate"), time.Hour)
+    s := newTestScaler(t, []*v1.ReplicaSet{rs})
+    if err := s.applyIdlePolicy("idle", "hibernate"); err == nil {
+        t.Fatal("expected an unknown idle policy to fail")
+    }
+    if !s.exists(t, "idle") {
+        t.Fatal("expected the sandbox to be kept")
+    }
+}
+
+func TestApplyIdlePolicyDelete(t *testing.T) {
+    rs := testReplicaSet(t, "idle", &sandbox.Sandbox{Status: sandbox.StatusRunning}, time.Hour)
+    s := newTestScaler(t, []*v1.ReplicaSet{rs})
+    if err := s.applyIdlePolicy("idle", sandbox.IdlePolicyDelete); err != nil {
+        t.Fatal(err)
+    }
+
+    // the idle status is recorded first, the event hub publishes the idle event from it before the deleted one
+    var verbs []string
+    for _, action := range s.kube.Actions() {
+        if action.GetResource().Resource != "replicasets" || action.GetVerb() == "get" {
+            continue
+        }
+        verbs = append(verbs, action.GetVerb())
+        if update, ok := action.(k8stesting.UpdateAction); ok {
+            sb, err := sandbox.ParseSandboxData(update.GetObject().(*v1.ReplicaSet))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if sb.Status != sandbox.StatusIdle {
+                t.Fatalf("expected the idle status to be recorded, got %s", sb.Status)
+            }
+        }
+    }
+    if len(verbs) != 2 || verbs[0] != "update" || verbs[1] != "delete" {
+        t.Fatalf("expected the idle status update before the delete, got %v", verbs)
+    }
+    if s.exists(t, "idle") {
+        t.Fatal("expected the idle sandbox to be deleted")
+    }
+}
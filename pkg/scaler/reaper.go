@@ -0,0 +1,90 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scaler
+
+import (
+    "context"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/sandbox"
+    corev1 "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/labels"
+    "k8s.io/klog/v2"
+)
+
+const (
+    // EventReasonTimeout is the event reason recorded on the ReplicaSet when the sandbox reached its Timeout.
+    EventReasonTimeout = "SandboxTimeout"
+)
+
+// reapExpired deletes the sandboxes which lived longer than their Timeout.
+func (s *Scaler) reapExpired() {
+    selector, _ := labels.Parse("owner=agent-sandbox")
+    rss, err := activator.ReplicaSets(s.rootCtx).Lister().List(selector)
+    if err != nil {
+        klog.Errorf("Failed to list sandboxes for reaping: %v", err)
+        return
+    }
+
+    now := time.Now()
+    for _, rs := range rss {
+        if rs.DeletionTimestamp != nil {
+            continue
+        }
+        sb, err := sandbox.ParseSandboxData(rs)
+        if err != nil {
+            klog.Errorf("Skip reaping sandbox %s: %v", rs.Name, err)
+            continue
+        }
+
+        timeout := sb.Timeout
+        if timeout <= 0 {
+            timeout = sandbox.DefaultSandbox.Timeout
+        }
+        deadline := rs.CreationTimestamp.Add(time.Duration(timeout) * time.Minute)
+        if now.Before(deadline) {
+            continue
+        }
+
+        // guard with the uid, a sandbox re-created with the same name must not be deleted
+        current, err := s.client.AppsV1().ReplicaSets(rs.Namespace).Get(context.TODO(), rs.Name, v1meta.GetOptions{})
+        if err != nil {
+            if !apierrors.IsNotFound(err) {
+                klog.Errorf("Failed to get timed out sandbox %s: %v", rs.Name, err)
+            }
+            continue
+        }
+        if current.UID != rs.UID {
+            klog.Infof("Skip reaping sandbox %s, it was re-created", rs.Name)
+            continue
+        }
+
+        klog.Infof("Sandbox %s reached its timeout of %d minutes, created at %s, deleting", rs.Name, timeout, rs.CreationTimestamp)
+        s.recorder.Eventf(rs, corev1.EventTypeNormal, EventReasonTimeout,
+            "Sandbox reached its maximum lifetime of %d minutes (created at %s), deleting", timeout, rs.CreationTimestamp.Format(time.RFC3339))
+
+        // delete by the controller like the idle policy, with the CRD the Sandbox resource goes, not only its ReplicaSet
+        if err := s.controller.Delete(rs.Name); err != nil && !apierrors.IsNotFound(err) {
+            klog.Errorf("Failed to delete timed out sandbox %s: %v", rs.Name, err)
+            continue
+        }
+        s.activator.Forget(rs.Name)
+    }
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright 2025 The https://github.com/agent-sandbox/agent-sandbox Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scaler
+
+import (
+    "context"
+    "encoding/json"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/agent-sandbox/agent-sandbox/pkg/activator"
+    "github.com/agent-sandbox/agent-sandbox/pkg/config"
+    "github.com/agent-sandbox/agent-sandbox/pkg/sandbox"
+    v1 "k8s.io/api/apps/v1"
+    corev1 "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+    dynamicfake "k8s.io/client-go/dynamic/fake"
+    appsinformers "k8s.io/client-go/informers/apps/v1"
+    coreinformers "k8s.io/client-go/informers/core/v1"
+    kubefake "k8s.io/client-go/kubernetes/fake"
+    appslisters "k8s.io/client-go/listers/apps/v1"
+    corelisters "k8s.io/client-go/listers/core/v1"
+    "k8s.io/client-go/tools/cache"
+    "k8s.io/client-go/tools/record"
+    kubeclient "knative.dev/pkg/client/injection/kube/client"
+    rsfiltered "knative.dev/pkg/client/injection/kube/informers/apps/v1/replicaset/filtered"
+    podfiltered "knative.dev/pkg/client/injection/kube/informers/core/v1/pod/filtered"
+    "knative.dev/pkg/controller"
+    "knative.dev/pkg/injection/clients/dynamicclient"
+)
+
+// testReplicaSetInformer serves the lister of the indexer, the informer is never run.
+type testReplicaSetInformer struct {
+    indexer cache.Indexer
+}
+
+func (i *testReplicaSetInformer) Informer() cache.SharedIndexInformer {
+    return nil
+}
+
+func (i *testReplicaSetInformer) Lister() appslisters.ReplicaSetLister {
+    return appslisters.NewReplicaSetLister(i.indexer)
+}
+
+var _ appsinformers.ReplicaSetInformer = &testReplicaSetInformer{}
+
+// testPodInformer serves the lister of the indexer, the informer is never run.
+type testPodInformer struct {
+    indexer cache.Indexer
+}
+
+func (i *testPodInformer) Informer() cache.SharedIndexInformer {
+    return nil
+}
+
+func (i *testPodInformer) Lister() corelisters.PodLister {
+    return corelisters.NewPodLister(i.indexer)
+}
+
+var _ coreinformers.PodInformer = &testPodInformer{}
+
+// testScaler is a scaler on fake clients, the informer cache holds the cached ReplicaSets and the pods, the api
+// server all of them.
+type testScaler struct {
+    *Scaler
+    kube    *kubefake.Clientset
+    dynamic *dynamicfake.FakeDynamicClient
+    indexer cache.Indexer
+}
+
+func newTestScaler(t *testing.T, cached []*v1.ReplicaSet, objects ...runtime.Object) *testScaler {
+    indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+    pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+    var kubeObjects []runtime.Object
+    var dynamicObjects []*unstructured.Unstructured
+    for _, rs := range cached {
+        if err := indexer.Add(rs); err != nil {
+            t.Fatal(err)
+        }
+        kubeObjects = append(kubeObjects, rs.DeepCopy())
+    }
+    for _, obj := range objects {
+        switch o := obj.(type) {
+        case *unstructured.Unstructured:
+            dynamicObjects = append(dynamicObjects, o)
+        case *corev1.Pod:
+            if err := pods.Add(o); err != nil {
+                t.Fatal(err)
+            }
+            kubeObjects = append(kubeObjects, o.DeepCopy())
+        default:
+            kubeObjects = append(kubeObjects, obj)
+        }
+    }
+    kube := kubefake.NewSimpleClientset(kubeObjects...)
+    // the objects are added by SandboxGVR, the fake guesses the plural of Sandbox wrong
+    dynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+        map[schema.GroupVersionResource]string{sandbox.SandboxGVR: sandbox.SandboxKind + "List"})
+    for _, obj := range dynamicObjects {
+        if err := dynamic.Tracker().Create(sandbox.SandboxGVR, obj, obj.GetNamespace()); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    t.Cleanup(cancel)
+    ctx = context.WithValue(ctx, kubeclient.Key{}, kube)
+    ctx = context.WithValue(ctx, dynamicclient.Key{}, dynamic)
+    ctx = context.WithValue(ctx, rsfiltered.Key{Selector: activator.SandboxSelector}, &testReplicaSetInformer{indexer: indexer})
+    ctx = context.WithValue(ctx, podfiltered.Key{Selector: activator.SandboxSelector}, &testPodInformer{indexer: pods})
+    ctx = controller.WithEventRecorder(ctx, record.NewFakeRecorder(100))
+
+    a := activator.NewActivator(ctx)
+    return &testScaler{
+        Scaler: &Scaler{
+            rootCtx:    ctx,
+            activator:  a,
+            controller: sandbox.NewController(ctx, a),
+            client:     kube,
+            recorder:   record.NewFakeRecorder(100),
+        },
+        kube:    kube,
+        dynamic: dynamic,
+        indexer: indexer,
+    }
+}
+
+// testReplicaSet is the ReplicaSet of a running sandbox created age ago.
+func testReplicaSet(t *testing.T, name string, sb *sandbox.Sandbox, age time.Duration) *v1.ReplicaSet {
+    sb.Name = name
+    raw, err := json.Marshal(sb)
+    if err != nil {
+        t.Fatal(err)
+    }
+    replicas := int32(1)
+    return &v1.ReplicaSet{
+        ObjectMeta: v1meta.ObjectMeta{
+            Name:              name,
+            Namespace:         config.Cfg.SandboxNamespace,
+            UID:               types.UID(name + "-uid"),
+            CreationTimestamp: v1meta.NewTime(time.Now().Add(-age)),
+            Labels:            map[string]string{"owner": "agent-sandbox", "sandbox": name},
+            Annotations:       map[string]string{sandbox.SandboxDataAnnotation: string(raw)},
+        },
+        Spec:   v1.ReplicaSetSpec{Replicas: &replicas},
+        Status: v1.ReplicaSetStatus{Replicas: 1, ReadyReplicas: 1},
+    }
+}
+
+func (s *testScaler) exists(t *testing.T, name string) bool {
+    _, err := s.kube.AppsV1().ReplicaSets(config.Cfg.SandboxNamespace).Get(context.TODO(), name, v1meta.GetOptions{})
+    if err != nil && !apierrors.IsNotFound(err) {
+        t.Fatal(err)
+    }
+    return err == nil
+}
+
+func TestReapExpired(t *testing.T) {
+    withTimeout := func(minutes int) *sandbox.Sandbox {
+        sb := &sandbox.Sandbox{}
+        sb.Timeout = minutes
+        return sb
+    }
+    young := testReplicaSet(t, "young", withTimeout(60), 10*time.Minute)
+    expired := testReplicaSet(t, "expired", withTimeout(60), 61*time.Minute)
+    // the default Timeout applies to a sandbox without one
+    defaulted := testReplicaSet(t, "defaulted", withTimeout(0), time.Duration(sandbox.DefaultSandbox.Timeout+1)*time.Minute)
+    deleting := testReplicaSet(t, "deleting", withTimeout(1), time.Hour)
+    now := v1meta.Now()
+    deleting.DeletionTimestamp = &now
+
+    s := newTestScaler(t, []*v1.ReplicaSet{young, expired, defaulted, deleting})
+    s.reapExpired()
+
+    for name, expected := range map[string]bool{"young": true, "expired": false, "defaulted": false, "deleting": true} {
+        if got := s.exists(t, name); got != expected {
+            t.Errorf("expected sandbox %s to exist %t, got %t", name, expected, got)
+        }
+    }
+}
+
+func TestReapExpiredSkipsRecreated(t *testing.T) {
+    sb := &sandbox.Sandbox{}
+    sb.Timeout = 1
+    cached := testReplicaSet(t, "recreated", sb, time.Hour)
+    // the informer still holds the expired sandbox, the api server already a new one of the same name
+    current := cached.DeepCopy()
+    current.UID = "new-uid"
+    current.CreationTimestamp = v1meta.Now()
+
+    s := newTestScaler(t, nil, current)
+    if err := s.indexer.Add(cached); err != nil {
+        t.Fatal(err)
+    }
+    s.reapExpired()
+
+    if !s.exists(t, "recreated") {
+        t.Fatal("expected the re-created sandbox to be kept")
+    }
+}
+
+func TestReapExpiredSandboxResource(t *testing.T) {
+    saved := config.Cfg.SandboxCRDEnabled
+    config.Cfg.SandboxCRDEnabled = true
+    t.Cleanup(func() { config.Cfg.SandboxCRDEnabled = saved })
+
+    sb := &sandbox.Sandbox{}
+    sb.Timeout = 1
+    rs := testReplicaSet(t, "crd", sb, time.Hour)
+    res := &unstructured.Unstructured{}
+    res.SetAPIVersion(sandbox.SandboxGroup + "/" + sandbox.SandboxVersion)
+    res.SetKind(sandbox.SandboxKind)
+    res.SetNamespace(rs.Namespace)
+    res.SetName(rs.Name)
+    res.SetUID("crd-resource-uid")
+    controls := true
+    rs.OwnerReferences = []v1meta.OwnerReference{{
+        APIVersion: res.GetAPIVersion(),
+        Kind:       sandbox.SandboxKind,
+        Name:       res.GetName(),
+        UID:        res.GetUID(),
+        Controller: &controls,
+    }}
+
+    s := newTestScaler(t, []*v1.ReplicaSet{rs}, res)
+    s.reapExpired()
+
+    // the reconciler re-creates the missing ReplicaSet of an existing Sandbox resource, so the resource must go
+    _, err := s.dynamic.Resource(sandbox.SandboxGVR).Namespace(rs.Namespace).Get(context.TODO(), rs.Name, v1meta.GetOptions{})
+    if !apierrors.IsNotFound(err) {
+        t.Fatalf("expected the sandbox resource to be deleted, got %v", err)
+    }
+    // the ReplicaSet is garbage collected with the resource, not deleted under it
+    for _, action := range s.kube.Actions() {
+        if action.GetVerb() == "delete" {
+            t.Fatalf("expected no direct delete of the replicaset, got %v", action)
+        }
+    }
+}
+
+func TestReapExpiredRecordsEvent(t *testing.T) {
+    sb := &sandbox.Sandbox{}
+    sb.Timeout = 30
+    s := newTestScaler(t, []*v1.ReplicaSet{testReplicaSet(t, "expired", sb, time.Hour)})
+    s.reapExpired()
+
+    recorder := s.recorder.(*record.FakeRecorder)
+    select {
+    case event := <-recorder.Events:
+        if !strings.Contains(event, EventReasonTimeout) || !strings.Contains(event, "30 minutes") {
+            t.Fatalf("unexpected event %q", event)
+        }
+    default:
+        t.Fatal("expected a timeout event")
+    }
+}